@@ -0,0 +1,71 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/kinvolk/inspektor-gadget/pkg/k8sutil"
+	"github.com/spf13/cobra"
+
+	"github.com/Azure/kubectl-aks/cmd/utils"
+	"github.com/Azure/kubectl-aks/pkg/runtime/agent"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Manage the node agent used by the agent runtime",
+	Long: fmt.Sprintf("Manage the node agent used by '--runtime %s': a privileged DaemonSet in the %s namespace\n"+
+		"that runs commands on its node through pod exec, without creating a pod per command.",
+		RuntimeAgent, agent.Namespace),
+}
+
+var agentInstallCmd = &cobra.Command{
+	Use:          "install",
+	Short:        "Install or update the node agent DaemonSet",
+	Long:         "Install or update the node agent DaemonSet, using the --debug-image container image",
+	Args:         cobra.NoArgs,
+	RunE:         agentInstallCmdRun,
+	SilenceUsage: true,
+}
+
+var agentUninstallCmd = &cobra.Command{
+	Use:          "uninstall",
+	Short:        "Remove the node agent DaemonSet",
+	Args:         cobra.NoArgs,
+	RunE:         agentUninstallCmdRun,
+	SilenceUsage: true,
+}
+
+func init() {
+	utils.AddCommonFlags(agentCmd, &commonFlags)
+	agentCmd.AddCommand(agentInstallCmd, agentUninstallCmd)
+	rootCmd.AddCommand(agentCmd)
+}
+
+func agentInstallCmdRun(cmd *cobra.Command, args []string) error {
+	resolveRuntimeFromConfig()
+
+	clientset, err := k8sutil.NewClientsetFromConfigFlags(utils.KubernetesConfigFlags)
+	if err != nil {
+		return fmt.Errorf("creating kubernetes client: %w", err)
+	}
+	if err := agent.Install(cmd.Context(), clientset, debugImage, version); err != nil {
+		return err
+	}
+	fmt.Printf("Agent %s installed in namespace %s\n", version, agent.Namespace)
+	return nil
+}
+
+func agentUninstallCmdRun(cmd *cobra.Command, args []string) error {
+	clientset, err := k8sutil.NewClientsetFromConfigFlags(utils.KubernetesConfigFlags)
+	if err != nil {
+		return fmt.Errorf("creating kubernetes client: %w", err)
+	}
+	if err := agent.Uninstall(cmd.Context(), clientset); err != nil {
+		return err
+	}
+	fmt.Println("Agent uninstalled")
+	return nil
+}
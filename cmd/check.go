@@ -13,7 +13,6 @@ import (
 	"github.com/Azure/kubectl-aks/cmd/utils/config"
 	"github.com/Azure/kubectl-aks/pkg/check"
 	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
-	"github.com/Azure/kubectl-aks/pkg/runtime/vmss"
 )
 
 var checkCmd = &cobra.Command{
@@ -72,6 +71,10 @@ func registerCheck(c check.Check) {
 
 	utils.AddNodeFlags(cmd)
 	utils.AddCommonFlags(cmd, &commonFlags)
+	addGroupFlag(cmd)
+	if cc, ok := c.(check.Configurable); ok {
+		cc.AddFlags(cmd.Flags())
+	}
 
 	switch c.Mode() {
 	case check.ModeVerify:
@@ -87,6 +90,9 @@ func makeCheckRunFunc(c check.Check) func(cmd *cobra.Command, args []string) err
 		if c.Mode() == check.ModeTrace {
 			duration = traceDuration
 		}
+		if ea, ok := c.(check.EnvironmentAware); ok {
+			ea.SetEnvironment(checkEnvironment())
+		}
 
 		// Cluster fan-out mode
 		if cl := utils.GetClusterFlag(); cl != "" {
@@ -94,7 +100,7 @@ func makeCheckRunFunc(c check.Check) func(cmd *cobra.Command, args []string) err
 		}
 
 		// Single node mode
-		rt, err := buildRuntime()
+		rt, err := buildRuntime(cmd.Context())
 		if err != nil {
 			return err
 		}
@@ -107,12 +113,53 @@ func makeCheckRunFunc(c check.Check) func(cmd *cobra.Command, args []string) err
 		output, hasFailure := check.FormatResults([]check.NodeResult{*nr})
 		fmt.Fprint(os.Stdout, output)
 		if hasFailure {
-			os.Exit(1)
+			return errNodesFailed
 		}
 		return nil
 	}
 }
 
+// checkEnvironment returns the environment of the target cluster from the
+// CLI configuration: the --cluster one, or the current cluster when targeting
+// a single node.
+func checkEnvironment() *check.Environment {
+	cfg := config.New()
+	cluster := utils.GetClusterFlag()
+	if cluster == "" {
+		cluster = cfg.CurrentClusterName()
+	}
+	env := &check.Environment{
+		Cloud:  utils.GetCloudConfiguration(),
+		NodeVM: storedNodeVM,
+	}
+	if cluster != "" {
+		env.SysctlBaseline = cfg.GetClusterSysctlBaseline(cluster)
+	}
+	return env
+}
+
+// storedNodeVM returns the VM information stored in the config for a node.
+func storedNodeVM(nodeName string) (*check.NodeVM, bool) {
+	if nodeName == "" {
+		return nil, false
+	}
+	nc, ok := config.New().GetNodeConfig(nodeName)
+	if !ok {
+		return nil, false
+	}
+	vm, err := utils.VirtualMachineFromNodeConfig(nc)
+	if err != nil || vm.SubscriptionID == "" {
+		return nil, false
+	}
+	return &check.NodeVM{
+		SubscriptionID:    vm.SubscriptionID,
+		NodeResourceGroup: vm.NodeResourceGroup,
+		VMScaleSet:        vm.VMScaleSet,
+		InstanceID:        vm.InstanceID,
+		Name:              vm.Name,
+	}, true
+}
+
 func runCheckOnCluster(cmd *cobra.Command, c check.Check, clusterName string, duration int) error {
 	cfg := config.New()
 	nodes, err := cfg.ListClusterNodes(clusterName)
@@ -133,31 +180,27 @@ func runCheckOnCluster(cmd *cobra.Command, c check.Check, clusterName string, du
 
 		switch runtimeFlag {
 		case RuntimeKubeAPI:
-			return buildKubectlDebugRuntime()
+			return buildKubectlDebugRuntime(clusterName)
+		case RuntimeAKSCommand:
+			return buildAKSCommandRuntime(cmd.Context(), clusterName)
+		case RuntimeAgent:
+			return buildAgentRuntime()
+		case RuntimeAuto:
+			return buildAutoRuntime(cmd.Context(), clusterName, nc), nil
 		default:
-			cred, err := utils.GetCredentials()
-			if err != nil {
-				return nil, fmt.Errorf("authenticating: %w", err)
-			}
-			vm := &utils.VirtualMachineScaleSetVM{
-				SubscriptionID:    nc.GetString(utils.SubscriptionIDKey),
-				NodeResourceGroup: nc.GetString(utils.NodeResourceGroupKey),
-				VMScaleSet:        nc.GetString(utils.VMSSKey),
-				InstanceID:        nc.GetString(utils.VMSSInstanceIDKey),
-			}
-			return &vmss.Runtime{
-				Credential:     cred,
-				VM:             vm,
-				OutputTruncate: utils.OutputTruncateTail,
-			}, nil
+			return buildNodeVMSSRuntime(clusterName, nc)
 		}
 	}
 
 	results := check.RunOnNodes(cmd.Context(), c, nodes, factory, utils.DefaultRunCommandTimeoutInSeconds, duration)
-	output, hasFailure := check.FormatResults(results)
+	format := check.FormatResults
+	if len(results) > 1 && shouldGroup(cmd) {
+		format = check.FormatGroupedResults
+	}
+	output, hasFailure := format(results)
 	fmt.Fprint(os.Stdout, output)
 	if hasFailure {
-		os.Exit(1)
+		return errNodesFailed
 	}
 	return nil
 }
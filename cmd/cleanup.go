@@ -0,0 +1,106 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kinvolk/inspektor-gadget/pkg/k8sutil"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Azure/kubectl-aks/cmd/utils"
+	"github.com/Azure/kubectl-aks/pkg/runtime/kubectldebug"
+)
+
+var (
+	cleanupTTL    time.Duration
+	cleanupDryRun bool
+)
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Delete debug pods leaked by the kube-api runtime",
+	Long: "Delete the debug pods created by kubectl-aks, in all namespaces, that are older than --ttl and\n" +
+		"completed or past their command timeout.\n" +
+		"Debug pods are deleted once their command completes, but they leak if kubectl-aks is killed before.",
+	Args:         cobra.NoArgs,
+	RunE:         cleanupCmdRun,
+	SilenceUsage: true,
+}
+
+func init() {
+	cleanupCmd.Flags().DurationVar(&cleanupTTL, "ttl", kubectldebug.DefaultCleanupTTL, "Minimum age of the debug pods to delete")
+	cleanupCmd.Flags().BoolVar(&cleanupDryRun, "dry-run", false, "Only list the debug pods that would be deleted")
+	utils.AddCommonFlags(cleanupCmd, &commonFlags)
+	rootCmd.AddCommand(cleanupCmd)
+}
+
+func cleanupCmdRun(cmd *cobra.Command, args []string) error {
+	clientset, err := k8sutil.NewClientsetFromConfigFlags(utils.KubernetesConfigFlags)
+	if err != nil {
+		return fmt.Errorf("creating kubernetes client: %w", err)
+	}
+
+	var pods []corev1.Pod
+	if cleanupDryRun {
+		pods, err = kubectldebug.StalePods(cmd.Context(), clientset, cleanupTTL)
+	} else {
+		pods, err = kubectldebug.Cleanup(cmd.Context(), clientset, cleanupTTL)
+	}
+	if len(pods) == 0 && err == nil {
+		fmt.Printf("No leaked debug pods older than %s\n", cleanupTTL)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tNODE\tAGE\tOWNER")
+	for _, pod := range pods {
+		age := duration.HumanDuration(time.Since(pod.CreationTimestamp.Time))
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pod.Namespace, pod.Name, pod.Spec.NodeName, age,
+			pod.Annotations[kubectldebug.OwnerAnnotation])
+	}
+	w.Flush()
+	if err != nil {
+		return err
+	}
+	if cleanupDryRun {
+		fmt.Printf("%d debug pod(s) would be deleted\n", len(pods))
+	} else {
+		fmt.Printf("%d debug pod(s) deleted\n", len(pods))
+	}
+	return nil
+}
+
+// startupCleanupOnce runs the opportunistic sweep once per process, rather
+// than for every node of a cluster.
+var startupCleanupOnce sync.Once
+
+// startupCleanup deletes leaked debug pods in the background. Errors, e.g. a
+// lack of permission to list pods in all namespaces, are ignored, and the
+// sweep is abandoned if kubectl-aks exits before it completes.
+func startupCleanup(clientset kubernetes.Interface) {
+	startupCleanupOnce.Do(func() {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			deleted, err := kubectldebug.Cleanup(ctx, clientset, kubectldebug.DefaultCleanupTTL)
+			if err != nil {
+				log.Debugf("Cleaning up leaked debug pods: %s", err)
+			}
+			for _, pod := range deleted {
+				log.Debugf("Deleted leaked debug pod %s/%s", pod.Namespace, pod.Name)
+			}
+		}()
+	})
+}
@@ -6,12 +6,15 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"github.com/Azure/kubectl-aks/cmd/utils"
 	"github.com/Azure/kubectl-aks/cmd/utils/config"
+	"github.com/Azure/kubectl-aks/pkg/check"
+	"github.com/Azure/kubectl-aks/pkg/runtime/kubectldebug"
 )
 
 var configCmd = &cobra.Command{
@@ -87,6 +90,38 @@ var listClustersCmd = &cobra.Command{
 	SilenceUsage: true,
 }
 
+var setSysctlBaselineCmd = &cobra.Command{
+	Use:          "set-sysctl-baseline",
+	Short:        "Store the expected sysctl values of a cluster, used by the sysctl-compliance check",
+	Long:         "Store the expected sysctl values of a cluster from a sysctl.conf style file, used by the sysctl-compliance check. An empty file removes the baseline",
+	RunE:         setSysctlBaselineCmdRun,
+	SilenceUsage: true,
+}
+
+var setRunCommandBackendCmd = &cobra.Command{
+	Use:          "set-run-command-backend",
+	Short:        "Set the RunCommand backend of a cluster for the azure-api runtime (action or managed)",
+	Long:         "Set the RunCommand backend of a cluster for the azure-api runtime: \"action\" (default) or \"managed\", which falls back to the action on VMs where managed run commands aren't available",
+	RunE:         setRunCommandBackendCmdRun,
+	SilenceUsage: true,
+}
+
+var setRunCommandOutputContainerCmd = &cobra.Command{
+	Use:          "set-run-command-output-container",
+	Short:        "Set the blob container the managed run commands of a cluster write their output to",
+	Long:         "Set the URL, with a SAS token allowing to create, write, read and delete blobs, of the blob container the managed run commands of a cluster write their output to, so it isn't limited to 4096 bytes. An empty URL removes the setting",
+	RunE:         setRunCommandOutputContainerCmdRun,
+	SilenceUsage: true,
+}
+
+var setDebugPodProfileCmd = &cobra.Command{
+	Use:          "set-debug-pod-profile",
+	Short:        "Store the debug pod profile of a cluster, used by the kube-api and aks-command runtimes",
+	Long:         "Store the debug pod profile of a cluster from a strategic merge patch file (YAML or JSON) applied to the debug pod of the kube-api and aks-command runtimes, e.g. to set its namespace, tolerations, priority class or resources. An empty file removes the profile",
+	RunE:         setDebugPodProfileCmdRun,
+	SilenceUsage: true,
+}
+
 var importCmd = importCmdCommand()
 
 func init() {
@@ -97,7 +132,7 @@ func init() {
 	}
 	rootCmd.AddCommand(configCmd)
 
-	configCmd.AddCommand(showConfigCmd, useNodeCmd, useClusterCmd, unsetCurrentNodeCmd, unsetNodeCmd, unsetClusterCmd, unsetAllCmd, setNodeCmd, listClustersCmd, importCmd)
+	configCmd.AddCommand(showConfigCmd, useNodeCmd, useClusterCmd, unsetCurrentNodeCmd, unsetNodeCmd, unsetClusterCmd, unsetAllCmd, setNodeCmd, listClustersCmd, setSysctlBaselineCmd, setRunCommandBackendCmd, setRunCommandOutputContainerCmd, setDebugPodProfileCmd, importCmd)
 	utils.AddNodeFlagsOnly(setNodeCmd)
 }
 
@@ -177,6 +212,65 @@ func setNodeCmdRun(cmd *cobra.Command, args []string) error {
 	}
 }
 
+func setSysctlBaselineCmdRun(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s <cluster name> <file>", cmd.CommandPath())
+	}
+	content, err := os.ReadFile(args[1])
+	if err != nil {
+		return fmt.Errorf("reading sysctl baseline: %w", err)
+	}
+	var lines []string
+	if trimmed := strings.TrimSpace(string(content)); trimmed != "" {
+		lines = strings.Split(trimmed, "\n")
+	}
+	if _, err := check.ParseSysctlBaseline(lines); err != nil {
+		return fmt.Errorf("parsing %s: %w", args[1], err)
+	}
+	return config.New().SetClusterSysctlBaseline(args[0], lines)
+}
+
+func setRunCommandBackendCmdRun(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s <cluster name> <action|managed>", cmd.CommandPath())
+	}
+	backend, err := utils.ParseRunCommandBackend(args[1])
+	if err != nil {
+		return err
+	}
+	return config.New().SetClusterRunCommandBackend(args[0], string(backend))
+}
+
+func setRunCommandOutputContainerCmdRun(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s <cluster name> <container URL>", cmd.CommandPath())
+	}
+	if args[1] != "" {
+		if _, err := utils.ParseRunCommandOutputContainer(args[1]); err != nil {
+			return err
+		}
+	}
+	return config.New().SetClusterRunCommandOutputContainer(args[0], args[1])
+}
+
+func setDebugPodProfileCmdRun(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s <cluster name> <file>", cmd.CommandPath())
+	}
+	content, err := os.ReadFile(args[1])
+	if err != nil {
+		return fmt.Errorf("reading debug pod profile: %w", err)
+	}
+	profile := strings.TrimSpace(string(content))
+	if profile != "" {
+		r := &kubectldebug.Runtime{Profile: []byte(profile)}
+		if _, err := r.BuildDebugPod("node", "true", utils.DefaultRunCommandTimeoutInSeconds); err != nil {
+			return fmt.Errorf("validating %s: %w", args[1], err)
+		}
+	}
+	return config.New().SetClusterDebugPodProfile(args[0], profile)
+}
+
 func importCmdCommand() *cobra.Command {
 	var subscriptionID string
 	var resourceGroup string
@@ -196,17 +290,17 @@ func importCmdCommand() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			resolveRuntimeFromConfig()
 
-			var vms map[string]*utils.VirtualMachineScaleSetVM
+			var vms map[string]*utils.VirtualMachine
 
 			utils.DefaultSpinner.Start()
 			utils.DefaultSpinner.Suffix = " Importing..."
 
 			switch runtimeFlag {
 			case RuntimeKubeAPI:
-				v, err := utils.VirtualMachineScaleSetVMsViaKubeconfig()
+				v, err := utils.VirtualMachinesViaKubeconfig()
 				utils.DefaultSpinner.Stop()
 				if err != nil {
-					return fmt.Errorf("getting VMSS VMs via Kubernetes API: %w", err)
+					return fmt.Errorf("getting VMs via Kubernetes API: %w", err)
 				}
 				vms = v
 
@@ -228,10 +322,10 @@ func importCmdCommand() *cobra.Command {
 						"Alternatively, use '--runtime kube-api' to import via kubeconfig",
 						utils.SubscriptionIDKey, utils.ResourceGroupKey, utils.ClusterNameKey)
 				}
-				v, err := utils.VirtualMachineScaleSetVMsViaAzureAPI(subscriptionID, resourceGroup, clusterName)
+				v, err := utils.VirtualMachinesViaAzureAPI(subscriptionID, resourceGroup, clusterName)
 				utils.DefaultSpinner.Stop()
 				if err != nil {
-					return fmt.Errorf("getting VMSS VMs via Azure API: %w", err)
+					return fmt.Errorf("getting VMs via Azure API: %w", err)
 				}
 				vms = v
 			}
@@ -247,7 +341,13 @@ func importCmdCommand() *cobra.Command {
 					return fmt.Errorf("clearing old nodes for %s: %w", clusterName, err)
 				}
 				for nn, vm := range vms {
-					if err := cfg.SetClusterNodeConfigWithVMSSInfo(clusterName, nn, vm.SubscriptionID, vm.NodeResourceGroup, vm.VMScaleSet, vm.InstanceID); err != nil {
+					var err error
+					if vm.IsScaleSetVM() {
+						err = cfg.SetClusterNodeConfigWithVMSSInfo(clusterName, nn, vm.SubscriptionID, vm.NodeResourceGroup, vm.VMScaleSet, vm.InstanceID, string(vm.OS))
+					} else {
+						err = cfg.SetClusterNodeConfigWithVMInfo(clusterName, nn, vm.SubscriptionID, vm.NodeResourceGroup, vm.Name, string(vm.OS))
+					}
+					if err != nil {
 						return fmt.Errorf("setting node config for %s: %w", nn, err)
 					}
 				}
@@ -263,7 +363,13 @@ func importCmdCommand() *cobra.Command {
 			// Legacy fallback: no cluster name available (kube-api without detectable context).
 			logrus.Warn("Could not detect cluster name from kubeconfig context; storing nodes in legacy format")
 			for nn, vm := range vms {
-				if err := cfg.SetNodeConfigWithVMSSInfoFlag(nn, vm.SubscriptionID, vm.NodeResourceGroup, vm.VMScaleSet, vm.InstanceID); err != nil {
+				var err error
+				if vm.IsScaleSetVM() {
+					err = cfg.SetNodeConfigWithVMSSInfoFlag(nn, vm.SubscriptionID, vm.NodeResourceGroup, vm.VMScaleSet, vm.InstanceID)
+				} else {
+					err = cfg.SetNodeConfigWithResourceIDFlag(nn, vm.ResourceID())
+				}
+				if err != nil {
 					return fmt.Errorf("setting node config for %s: %w", nn, err)
 				}
 			}
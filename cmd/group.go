@@ -0,0 +1,30 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// groupOutput holds the --group flag value.
+var groupOutput bool
+
+// addGroupFlag adds the --group flag to cmd.
+func addGroupFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&groupOutput, "group", false,
+		"print each distinct output once with the nodes that produced it (default true when stdout is a terminal)")
+}
+
+// shouldGroup returns whether the identical outputs of the nodes are printed
+// once: --group if given, otherwise whether stdout is a terminal, so that
+// piped output keeps a block per node.
+func shouldGroup(cmd *cobra.Command) bool {
+	if cmd.Flags().Changed("group") {
+		return groupOutput
+	}
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
@@ -4,9 +4,14 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"github.com/Azure/kubectl-aks/cmd/utils"
@@ -14,11 +19,17 @@ import (
 )
 
 const (
-	RuntimeAzureAPI = "azure-api"
-	RuntimeKubeAPI  = "kube-api"
+	RuntimeAzureAPI   = "azure-api"
+	RuntimeKubeAPI    = "kube-api"
+	RuntimeAKSCommand = "aks-command"
+	RuntimeAgent      = "agent"
+	RuntimeAuto       = "auto"
 
-	runtimeKey    = "runtime"
-	debugImageKey = "debug-image"
+	runtimeKey           = "runtime"
+	debugImageKey        = "debug-image"
+	runCommandBackendKey = "run-command-backend"
+	runCommandOutputKey  = "run-command-output-container"
+	debugPodProfileKey   = "debug-pod-profile"
 )
 
 // Common flags for all subcommands
@@ -26,13 +37,22 @@ var commonFlags utils.CommonFlags
 
 // Runtime flags
 var (
-	runtimeFlag string
-	debugImage  string
+	runtimeFlag           string
+	debugImage            string
+	runCommandBackendFlag string
+	runCommandOutputFlag  string
+	debugPodProfileFlag   string
 )
 
+// errNodesFailed is returned by commands when a node didn't succeed, after
+// reporting it, so kubectl-aks exits with a non-zero code.
+var errNodesFailed = errors.New("not all nodes succeeded")
+
 var rootCmd = &cobra.Command{
 	Use:   "kubectl-aks",
 	Short: "Azure Kubernetes Service (AKS) kubectl plugin",
+	// Errors are printed by Execute, which doesn't print errNodesFailed.
+	SilenceErrors: true,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		cfg := config.New()
 		if cfg.IsLegacyConfig() {
@@ -45,14 +65,41 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&runtimeFlag, runtimeKey, RuntimeAzureAPI,
-		"Runtime to use for command execution. Supported values: azure-api, kube-api")
+		"Runtime to use for command execution. Supported values: azure-api, kube-api, aks-command, agent, auto")
 	rootCmd.PersistentFlags().StringVar(&debugImage, debugImageKey, "busybox:latest",
-		"Container image to use for the kube-api runtime")
+		"Container image to use for the kube-api and aks-command runtimes, and the agent")
+	rootCmd.PersistentFlags().StringVar(&debugPodProfileFlag, debugPodProfileKey, "",
+		"Strategic merge patch file applied to the debug pod of the kube-api and aks-command runtimes. Defaults to the profile set for the cluster")
+	rootCmd.PersistentFlags().StringVar(&runCommandBackendFlag, runCommandBackendKey, "",
+		"RunCommand backend of the azure-api runtime: action or managed. Defaults to the one set for the cluster, or action")
+	rootCmd.PersistentFlags().StringVar(&runCommandOutputFlag, runCommandOutputKey, "",
+		"URL with a SAS token of the blob container the managed RunCommand backend writes the output to, so it isn't limited to 4096 bytes. Defaults to the one set for the cluster")
 }
 
+// Execute runs the root command with a context cancelled by the first
+// interrupt, so runtimes can stop and clean up what they created on the
+// nodes. A second interrupt exits right away.
 func Execute() {
-	err := rootCmd.Execute()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := make(chan os.Signal, 1)
+	signal.Notify(s, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-s
+		log.Warn("Cancelling, hit 'Ctrl+C' again to exit without cleaning up.")
+		cancel()
+		<-s
+		os.Exit(1)
+	}()
+
+	err := rootCmd.ExecuteContext(ctx)
+	cancel()
 	if err != nil {
+		// The nodes that failed were already reported.
+		if !errors.Is(err, errNodesFailed) {
+			rootCmd.PrintErrln("Error:", err.Error())
+		}
 		os.Exit(1)
 	}
 }
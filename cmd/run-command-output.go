@@ -0,0 +1,300 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Azure/kubectl-aks/pkg/nodegroup"
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+
+	// timeoutExitCode is the exit code of GNU timeout, used by the runtimes
+	// to stop commands.
+	timeoutExitCode = 124
+)
+
+// Output flags
+var (
+	outputFormat string
+	outputDir    string
+)
+
+// nodeStatus is the outcome of running the command on a node.
+type nodeStatus string
+
+const (
+	statusSucceeded nodeStatus = "succeeded"
+	statusFailed    nodeStatus = "failed"
+	statusTimedOut  nodeStatus = "timed out"
+	statusCancelled nodeStatus = "cancelled"
+)
+
+func (r *nodeResult) status() nodeStatus {
+	switch {
+	case errors.Is(r.Err, context.Canceled):
+		return statusCancelled
+	case errors.Is(r.Err, pkgruntime.ErrTimeout),
+		errors.Is(r.Err, context.DeadlineExceeded),
+		r.ExitCode != nil && *r.ExitCode == timeoutExitCode:
+		return statusTimedOut
+	case r.Err != nil, r.ExitCode != nil && *r.ExitCode != 0:
+		return statusFailed
+	default:
+		return statusSucceeded
+	}
+}
+
+// nodeReport is the JSON representation of a nodeResult.
+type nodeReport struct {
+	Node            string     `json:"node"`
+	Status          nodeStatus `json:"status"`
+	ExitCode        *int       `json:"exitCode"`
+	DurationSeconds float64    `json:"durationSeconds"`
+	Runtime         string     `json:"runtime,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	Stdout          string     `json:"stdout,omitempty"`
+	Stderr          string     `json:"stderr,omitempty"`
+}
+
+// name returns the node name, or "node" when the node was given by its VM,
+// e.g. with --id.
+func (r *nodeResult) name() string {
+	if r.NodeName == "" {
+		return "node"
+	}
+	return r.NodeName
+}
+
+// report returns the JSON representation of r, with or without its output.
+func (r *nodeResult) report(withOutput bool) nodeReport {
+	rep := nodeReport{
+		Node:            r.name(),
+		Status:          r.status(),
+		ExitCode:        r.ExitCode,
+		DurationSeconds: r.Duration.Round(time.Millisecond).Seconds(),
+		Runtime:         r.Runtime,
+	}
+	if r.Err != nil {
+		rep.Error = r.Err.Error()
+	}
+	if withOutput {
+		rep.Stdout, rep.Stderr = r.Stdout, r.Stderr
+	}
+	return rep
+}
+
+// resultsSummary counts the nodes by status.
+type resultsSummary struct {
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	TimedOut  int `json:"timedOut"`
+	Cancelled int `json:"cancelled"`
+}
+
+func summarize(results []nodeResult) resultsSummary {
+	var s resultsSummary
+	for i := range results {
+		switch results[i].status() {
+		case statusSucceeded:
+			s.Succeeded++
+		case statusFailed:
+			s.Failed++
+		case statusTimedOut:
+			s.TimedOut++
+		case statusCancelled:
+			s.Cancelled++
+		}
+	}
+	return s
+}
+
+func (s resultsSummary) String() string {
+	str := fmt.Sprintf("%d succeeded, %d failed, %d timed out", s.Succeeded, s.Failed, s.TimedOut)
+	if s.Cancelled > 0 {
+		str += fmt.Sprintf(", %d cancelled", s.Cancelled)
+	}
+	return str
+}
+
+// reportResults prints the results in the --output format to the output of
+// cmd, with the identical outputs of the nodes printed once if group is set,
+// and writes them to --output-dir if set. It returns errNodesFailed if any
+// node didn't succeed.
+func reportResults(cmd *cobra.Command, results []nodeResult, group bool) error {
+	stdout, stderr := cmd.OutOrStdout(), cmd.ErrOrStderr()
+	if outputDir != "" {
+		if err := writeOutputDir(outputDir, results); err != nil {
+			return err
+		}
+	}
+
+	summary := summarize(results)
+	switch {
+	case outputFormat == outputJSON:
+		reports := make([]nodeReport, len(results))
+		for i := range results {
+			reports[i] = results[i].report(true)
+		}
+		b, err := marshalJSON(struct {
+			Summary resultsSummary `json:"summary"`
+			Nodes   []nodeReport   `json:"nodes"`
+		}{summary, reports})
+		if err != nil {
+			return fmt.Errorf("encoding results: %w", err)
+		}
+		stdout.Write(b)
+	case outputDir != "":
+		// The output is in the files, only show where it went.
+		for i := range results {
+			fmt.Fprintf(stdout, "%s: %s\n", results[i].name(), resultDescription(&results[i]))
+		}
+		fmt.Fprintf(stderr, "%s, output written to %s\n", summary, outputDir)
+	case group && len(results) > 1:
+		printGroupedResults(stdout, stderr, results)
+		fmt.Fprintln(stderr, summary)
+	default:
+		printResults(stdout, stderr, results)
+		fmt.Fprintln(stderr, summary)
+	}
+
+	if summary.Succeeded != len(results) {
+		return errNodesFailed
+	}
+	return nil
+}
+
+// printResults prints the output of every node after a header.
+func printResults(stdout, stderr io.Writer, results []nodeResult) {
+	for i := range results {
+		r := &results[i]
+		printResult(stdout, stderr, r, r.NodeName, r.headerDetails())
+	}
+}
+
+// printGroupedResults prints each distinct output once, after a header
+// listing the nodes that produced it, as pdsh's dshbak does.
+func printGroupedResults(stdout, stderr io.Writer, results []nodeResult) {
+	nodes := make([]string, len(results))
+	outputs := make([]string, len(results))
+	for i := range results {
+		r := &results[i]
+		nodes[i] = r.NodeName
+		// Nodes are only grouped when everything in their block is the same.
+		outputs[i] = strings.Join([]string{
+			strings.Join(r.headerDetails(), ", "),
+			fmt.Sprint(r.Err),
+			nodegroup.Normalize(r.Stdout),
+			nodegroup.Normalize(r.Stderr),
+		}, "\x00")
+	}
+	for _, g := range nodegroup.ByOutput(nodes, outputs) {
+		r := &results[g.Index]
+		details := append([]string{nodegroup.Count(len(g.Nodes))}, r.headerDetails()...)
+		printResult(stdout, stderr, r, nodegroup.Compress(g.Nodes), details)
+	}
+}
+
+// headerDetails returns the runtime and non-zero exit code of r, shown next
+// to the node name.
+func (r *nodeResult) headerDetails() []string {
+	var details []string
+	if r.Runtime != "" {
+		details = append(details, r.Runtime)
+	}
+	if r.ExitCode != nil && *r.ExitCode != 0 {
+		details = append(details, fmt.Sprintf("exit code %d", *r.ExitCode))
+	}
+	return details
+}
+
+// printResult prints the output of r after a header with the given nodes and
+// details.
+func printResult(stdout, stderr io.Writer, r *nodeResult, nodes string, details []string) {
+	if len(details) > 0 {
+		fmt.Fprintf(stdout, "=== %s (%s) ===\n", nodes, strings.Join(details, ", "))
+	} else {
+		fmt.Fprintf(stdout, "=== %s ===\n", nodes)
+	}
+	switch {
+	case errors.Is(r.Err, context.Canceled):
+		fmt.Fprintln(stderr, "CANCELLED")
+	case r.Err != nil:
+		fmt.Fprintf(stderr, "ERROR: %s\n", r.Err)
+	default:
+		if r.Stderr != "" {
+			fmt.Fprintf(stderr, "%s", r.Stderr)
+		}
+		fmt.Fprintf(stdout, "%s", r.Stdout)
+	}
+	fmt.Fprintln(stdout)
+}
+
+// resultDescription returns the status of a node with its exit code or
+// error, and duration.
+func resultDescription(r *nodeResult) string {
+	desc := string(r.status())
+	switch {
+	case r.Err != nil && !errors.Is(r.Err, context.Canceled):
+		desc += ": " + r.Err.Error()
+	case r.ExitCode != nil:
+		desc += fmt.Sprintf(" (exit code %d)", *r.ExitCode)
+	}
+	return fmt.Sprintf("%s in %s", desc, r.Duration.Round(time.Millisecond))
+}
+
+// writeOutputDir writes <node>.stdout, <node>.stderr and <node>.json, with
+// the exit code, duration and error, for every node.
+func writeOutputDir(dir string, results []nodeResult) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+	for i := range results {
+		r := &results[i]
+		name := r.name()
+		b, err := marshalJSON(r.report(false))
+		if err != nil {
+			return fmt.Errorf("encoding result of node %s: %w", name, err)
+		}
+		files := map[string][]byte{
+			name + ".stdout": []byte(r.Stdout),
+			name + ".stderr": []byte(r.Stderr),
+			name + ".json":   b,
+		}
+		for file, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, file), content, 0o644); err != nil {
+				return fmt.Errorf("writing output of node %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// marshalJSON returns the indented JSON encoding of v, followed by a newline,
+// without escaping the characters of URLs in errors.
+func marshalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
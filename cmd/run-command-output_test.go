@@ -0,0 +1,169 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func exitCode(code int) *int {
+	return &code
+}
+
+func TestNodeResultStatus(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		result      nodeResult
+		expected    nodeStatus
+	}{
+		{"exit code 0", nodeResult{ExitCode: exitCode(0)}, statusSucceeded},
+		{"unknown exit code", nodeResult{}, statusSucceeded},
+		{"non-zero exit code", nodeResult{ExitCode: exitCode(1)}, statusFailed},
+		{"error", nodeResult{Err: errors.New("creating debug pod: forbidden")}, statusFailed},
+		{"timeout exit code", nodeResult{ExitCode: exitCode(timeoutExitCode)}, statusTimedOut},
+		{"timeout error", nodeResult{Err: fmt.Errorf("%w after 60 seconds", pkgruntime.ErrTimeout)}, statusTimedOut},
+		{"deadline exceeded", nodeResult{Err: context.DeadlineExceeded}, statusTimedOut},
+		{"cancelled", nodeResult{Err: fmt.Errorf("command cancelled: %w", context.Canceled)}, statusCancelled},
+	} {
+		if status := tc.result.status(); status != tc.expected {
+			t.Fatalf("Failed test %q: status is %q, want %q", tc.description, status, tc.expected)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	results := []nodeResult{
+		{ExitCode: exitCode(0)},
+		{ExitCode: exitCode(0)},
+		{ExitCode: exitCode(2)},
+		{ExitCode: exitCode(timeoutExitCode)},
+	}
+	summary := summarize(results)
+	if expected := (resultsSummary{Succeeded: 2, Failed: 1, TimedOut: 1}); summary != expected {
+		t.Fatalf("summarize() = %+v, want %+v", summary, expected)
+	}
+	if expected := "2 succeeded, 1 failed, 1 timed out"; summary.String() != expected {
+		t.Fatalf("summary is %q, want %q", summary, expected)
+	}
+
+	results = append(results, nodeResult{Err: context.Canceled})
+	if expected := "2 succeeded, 1 failed, 1 timed out, 1 cancelled"; summarize(results).String() != expected {
+		t.Fatalf("summary is %q, want %q", summarize(results), expected)
+	}
+}
+
+func TestWriteOutputDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	results := []nodeResult{
+		{NodeName: "node0", Stdout: "ok\n", Stderr: "warning\n", ExitCode: exitCode(0), Duration: 1500 * time.Millisecond},
+		{Err: errors.New("polling command response: EOF")},
+	}
+	if err := writeOutputDir(dir, results); err != nil {
+		t.Fatalf("writeOutputDir() = %v", err)
+	}
+
+	for file, expected := range map[string]string{
+		"node0.stdout": "ok\n",
+		"node0.stderr": "warning\n",
+		"node.stdout":  "",
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil || string(b) != expected {
+			t.Fatalf("%s is %q, %v, want %q", file, b, err, expected)
+		}
+	}
+
+	var rep nodeReport
+	b, err := os.ReadFile(filepath.Join(dir, "node.json"))
+	if err != nil {
+		t.Fatalf("reading node.json: %v", err)
+	}
+	if err := json.Unmarshal(b, &rep); err != nil {
+		t.Fatalf("decoding node.json: %v", err)
+	}
+	if rep.Node != "node" || rep.Status != statusFailed || rep.Error != "polling command response: EOF" || rep.Stdout != "" {
+		t.Fatalf("unexpected report %+v", rep)
+	}
+}
+
+func TestReportResultsJSON(t *testing.T) {
+	defer func(format string) { outputFormat = format }(outputFormat)
+	outputFormat = outputJSON
+
+	var stdout, stderr bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	err := reportResults(cmd, []nodeResult{
+		{NodeName: "node0", Stdout: "<ok>\n", ExitCode: exitCode(0), Duration: 1234 * time.Millisecond, Runtime: "kube-api"},
+		{NodeName: "node1", ExitCode: exitCode(3)},
+	}, false)
+	if !errors.Is(err, errNodesFailed) {
+		t.Fatalf("reportResults() = %v, want errNodesFailed", err)
+	}
+
+	expected := `{
+  "summary": {
+    "succeeded": 1,
+    "failed": 1,
+    "timedOut": 0,
+    "cancelled": 0
+  },
+  "nodes": [
+    {
+      "node": "node0",
+      "status": "succeeded",
+      "exitCode": 0,
+      "durationSeconds": 1.234,
+      "runtime": "kube-api",
+      "stdout": "<ok>\n"
+    },
+    {
+      "node": "node1",
+      "status": "failed",
+      "exitCode": 3,
+      "durationSeconds": 0
+    }
+  ]
+}
+`
+	if stdout.String() != expected {
+		t.Fatalf("unexpected output:\n%s", stdout.String())
+	}
+}
+
+func TestReportResultsGrouped(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	err := reportResults(cmd, []nodeResult{
+		{NodeName: "node0", Stdout: "5.15.0-1057-azure\n", ExitCode: exitCode(0)},
+		{NodeName: "node1", Stdout: "5.15.0-1057-azure\r\n", ExitCode: exitCode(0)},
+		{NodeName: "node2", Stdout: "5.15.0-1060-azure\n", ExitCode: exitCode(0)},
+	}, true)
+	if err != nil {
+		t.Fatalf("reportResults() = %v", err)
+	}
+
+	expected := "=== node[0-1] (2 nodes) ===\n5.15.0-1057-azure\n\n=== node2 (1 node) ===\n5.15.0-1060-azure\n\n"
+	if stdout.String() != expected {
+		t.Fatalf("unexpected output:\n%s", stdout.String())
+	}
+	if stderr.String() != "3 succeeded, 0 failed, 0 timed out\n" {
+		t.Fatalf("unexpected summary %q", stderr.String())
+	}
+}
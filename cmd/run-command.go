@@ -4,13 +4,18 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/Azure/kubectl-aks/cmd/utils"
 	"github.com/Azure/kubectl-aks/cmd/utils/config"
 	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+	"github.com/Azure/kubectl-aks/pkg/runtime/agent"
+	"github.com/Azure/kubectl-aks/pkg/runtime/akscommand"
+	"github.com/Azure/kubectl-aks/pkg/runtime/auto"
 	"github.com/Azure/kubectl-aks/pkg/runtime/kubectldebug"
 	"github.com/Azure/kubectl-aks/pkg/runtime/vmss"
 	"github.com/kinvolk/inspektor-gadget/pkg/k8sutil"
@@ -21,14 +26,26 @@ var (
 	command      string
 	timeout      int
 	truncateHead bool
+	scriptFile   string
+	interpreter  string
+	envVars      []string
 )
 
 var runCommandCmd = &cobra.Command{
-	Use:          "run-command",
-	Short:        "Run a command in a node",
+	Use:   "run-command",
+	Short: "Run a command in a node",
+	Long: "Run a command in a node.\n\n" +
+		"With --file, run a script instead: the arguments are passed to the script, e.g.\n" +
+		"  kubectl aks run-command --file collect.sh --interpreter bash -- --since 1h",
 	RunE:         runCommandCmdRun,
 	SilenceUsage: true,
 	Args: func(cmd *cobra.Command, args []string) error {
+		if scriptFile != "" {
+			return nil
+		}
+		if cmd.Flags().Changed("interpreter") {
+			return fmt.Errorf("--interpreter requires --file")
+		}
 		if len(args) != 1 {
 			return fmt.Errorf("usage: %s <command>", cmd.CommandPath())
 		}
@@ -40,6 +57,12 @@ var runCommandCmd = &cobra.Command{
 
 func init() {
 	runCommandCmd.Flags().IntVar(&timeout, "timeout", utils.DefaultRunCommandTimeoutInSeconds, "timeout in seconds for the command to complete")
+	runCommandCmd.Flags().StringVarP(&scriptFile, "file", "f", "", "script file to run instead of a command, with the arguments as its arguments")
+	runCommandCmd.Flags().StringVar(&interpreter, "interpreter", "sh", "program running the --file script, e.g. bash or python3")
+	runCommandCmd.Flags().StringArrayVar(&envVars, "env", nil, "environment variable set for the command, as KEY=VALUE (can be repeated)")
+	runCommandCmd.Flags().StringVarP(&outputFormat, "output", "o", outputText, "output format: text or json")
+	addGroupFlag(runCommandCmd)
+	runCommandCmd.Flags().StringVar(&outputDir, "output-dir", "", "directory where the stdout, stderr and result of each node are written")
 	utils.AddNodeFlags(runCommandCmd)
 	utils.AddCommonFlags(runCommandCmd, &commonFlags)
 
@@ -60,16 +83,30 @@ func init() {
 }
 
 func runCommandCmdRun(cmd *cobra.Command, args []string) error {
+	if outputFormat != outputText && outputFormat != outputJSON {
+		return fmt.Errorf("invalid output format %q: use %q or %q", outputFormat, outputText, outputJSON)
+	}
+	if err := resolveCommand(args); err != nil {
+		return err
+	}
+
 	// Fan-out: run across all nodes in a cluster
 	if cl := utils.GetClusterFlag(); cl != "" {
 		return runCommandOnCluster(cmd, cl)
 	}
 
-	rt, err := buildRuntime()
+	rt, err := buildRuntime(cmd.Context())
 	if err != nil {
 		return err
 	}
 
+	if outputFormat == outputJSON || outputDir != "" {
+		return reportResults(cmd, []nodeResult{runOnNode(cmd.Context(), rt, utils.GetNodeName())}, false)
+	}
+	if err := checkNodeOS(cmd.Context(), rt, utils.GetNodeName()); err != nil {
+		return err
+	}
+
 	opts := &pkgruntime.RunOptions{
 		NodeName: utils.GetNodeName(),
 		Command:  command,
@@ -81,17 +118,70 @@ func runCommandCmdRun(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("running command: %w", err)
 	}
 
+	if res.Runtime != "" {
+		fmt.Fprintf(os.Stderr, "Ran with the %s runtime\n", res.Runtime)
+	}
 	fmt.Fprintf(os.Stderr, "%s", res.Stderr)
 	fmt.Fprintf(os.Stdout, "%s", res.Stdout)
 	return nil
 }
 
+// resolveCommand sets the command to run from the --file script and its
+// arguments, and the --env variables.
+func resolveCommand(args []string) error {
+	if err := pkgruntime.ValidateEnv(envVars); err != nil {
+		return err
+	}
+	if scriptFile == "" {
+		command = pkgruntime.CommandWithEnv(command, envVars)
+		return nil
+	}
+
+	content, err := os.ReadFile(scriptFile)
+	if err != nil {
+		return fmt.Errorf("reading script: %w", err)
+	}
+	script := &pkgruntime.Script{
+		Content:     content,
+		Interpreter: interpreter,
+		Args:        args,
+		Env:         envVars,
+	}
+	command = script.Command()
+	return nil
+}
+
+// checkNodeOS returns an error if --file or --env is given for a Windows
+// node, as the command built for them is only understood by a POSIX shell.
+func checkNodeOS(ctx context.Context, rt pkgruntime.Runtime, nodeName string) error {
+	if scriptFile == "" && len(envVars) == 0 {
+		return nil
+	}
+	d, ok := rt.(pkgruntime.OSDetector)
+	if !ok {
+		return nil
+	}
+	nodeOS, err := d.NodeOS(ctx, nodeName)
+	if err != nil {
+		return fmt.Errorf("detecting OS of node %q: %w", nodeName, err)
+	}
+	if nodeOS == pkgruntime.OSWindows {
+		return fmt.Errorf("--file and --env are only supported on Linux nodes: node %q runs Windows", nodeName)
+	}
+	return nil
+}
+
 // nodeResult holds the output from a single node execution.
 type nodeResult struct {
 	NodeName string
 	Stdout   string
 	Stderr   string
-	Err      error
+	// ExitCode is nil when the runtime can't tell it.
+	ExitCode *int
+	Duration time.Duration
+	// Runtime is the runtime chosen by --runtime auto.
+	Runtime string
+	Err     error
 }
 
 func runCommandOnCluster(cmd *cobra.Command, clusterName string) error {
@@ -116,19 +206,7 @@ func runCommandOnCluster(cmd *cobra.Command, clusterName string) error {
 	}
 	wg.Wait()
 
-	for _, r := range results {
-		fmt.Fprintf(os.Stdout, "=== %s ===\n", r.NodeName)
-		if r.Err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: %s\n", r.Err)
-		} else {
-			if r.Stderr != "" {
-				fmt.Fprintf(os.Stderr, "%s", r.Stderr)
-			}
-			fmt.Fprintf(os.Stdout, "%s", r.Stdout)
-		}
-		fmt.Fprintln(os.Stdout)
-	}
-	return nil
+	return reportResults(cmd, results, shouldGroup(cmd))
 }
 
 func runOnSingleNode(cmd *cobra.Command, cfg *config.Config, clusterName, nodeName string) nodeResult {
@@ -146,67 +224,87 @@ func runOnSingleNode(cmd *cobra.Command, cfg *config.Config, clusterName, nodeNa
 
 	switch runtimeFlag {
 	case RuntimeKubeAPI:
-		r, err := buildKubectlDebugRuntime()
+		r, err := buildKubectlDebugRuntime(clusterName)
 		if err != nil {
 			nr.Err = err
 			return nr
 		}
 		rt = r
-	default: // azure-api
-		cred, err := utils.GetCredentials()
+	case RuntimeAKSCommand:
+		r, err := buildAKSCommandRuntime(cmd.Context(), clusterName)
 		if err != nil {
-			nr.Err = fmt.Errorf("authenticating: %w", err)
+			nr.Err = err
 			return nr
 		}
-
-		vm := &utils.VirtualMachineScaleSetVM{
-			SubscriptionID:    nc.GetString(utils.SubscriptionIDKey),
-			NodeResourceGroup: nc.GetString(utils.NodeResourceGroupKey),
-			VMScaleSet:        nc.GetString(utils.VMSSKey),
-			InstanceID:        nc.GetString(utils.VMSSInstanceIDKey),
-		}
-
-		outputTruncate := utils.OutputTruncateTail
-		if truncateHead {
-			outputTruncate = utils.OutputTruncateHead
+		rt = r
+	case RuntimeAgent:
+		r, err := buildAgentRuntime()
+		if err != nil {
+			nr.Err = err
+			return nr
 		}
-
-		rt = &vmss.Runtime{
-			Credential:     cred,
-			VM:             vm,
-			OutputTruncate: outputTruncate,
+		rt = r
+	case RuntimeAuto:
+		rt = buildAutoRuntime(cmd.Context(), clusterName, nc)
+	default: // azure-api
+		r, err := buildNodeVMSSRuntime(clusterName, nc)
+		if err != nil {
+			nr.Err = err
+			return nr
 		}
+		rt = r
 	}
 
+	return runOnNode(cmd.Context(), rt, nodeName)
+}
+
+// runOnNode runs the command on a node with rt and times it.
+func runOnNode(ctx context.Context, rt pkgruntime.Runtime, nodeName string) nodeResult {
+	nr := nodeResult{NodeName: nodeName}
 	opts := &pkgruntime.RunOptions{
 		NodeName: nodeName,
 		Command:  command,
 		Timeout:  timeout,
 	}
 
-	res, err := rt.RunCommand(cmd.Context(), opts)
+	if err := checkNodeOS(ctx, rt, nodeName); err != nil {
+		nr.Err = err
+		return nr
+	}
+
+	start := time.Now()
+	res, err := rt.RunCommand(ctx, opts)
+	nr.Duration = time.Since(start)
 	if err != nil {
 		nr.Err = err
 		return nr
 	}
 	nr.Stdout = res.Stdout
 	nr.Stderr = res.Stderr
+	nr.ExitCode = res.ExitCode
+	nr.Runtime = res.Runtime
 	return nr
 }
 
 // buildRuntime creates the appropriate runtime based on the --runtime flag.
 // It checks (1) CLI flag, (2) config file for runtime preference.
-func buildRuntime() (pkgruntime.Runtime, error) {
+func buildRuntime(ctx context.Context) (pkgruntime.Runtime, error) {
 	resolveRuntimeFromConfig()
 
 	switch runtimeFlag {
 	case RuntimeAzureAPI:
 		return buildVMSSRuntime()
 	case RuntimeKubeAPI:
-		return buildKubectlDebugRuntime()
+		return buildKubectlDebugRuntime(config.New().CurrentClusterName())
+	case RuntimeAKSCommand:
+		return buildAKSCommandRuntime(ctx, config.New().CurrentClusterName())
+	case RuntimeAgent:
+		return buildAgentRuntime()
+	case RuntimeAuto:
+		return buildAutoRuntime(ctx, config.New().CurrentClusterName(), nil), nil
 	default:
-		return nil, fmt.Errorf("unsupported runtime %q: use %q or %q",
-			runtimeFlag, RuntimeAzureAPI, RuntimeKubeAPI)
+		return nil, fmt.Errorf("unsupported runtime %q: use %q, %q, %q, %q or %q",
+			runtimeFlag, RuntimeAzureAPI, RuntimeKubeAPI, RuntimeAKSCommand, RuntimeAgent, RuntimeAuto)
 	}
 }
 
@@ -216,24 +314,69 @@ func buildVMSSRuntime() (pkgruntime.Runtime, error) {
 		return nil, fmt.Errorf("authenticating: %w", err)
 	}
 
-	vm, err := utils.VirtualMachineScaleSetVMFromConfig()
+	vm, err := utils.VirtualMachineFromConfig()
 	if err != nil {
 		return nil, fmt.Errorf("getting vm: %w", err)
 	}
 
+	clusterName := config.New().CurrentClusterName()
+	backend, err := resolveRunCommandBackend(clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	outputTruncate := utils.OutputTruncateTail
+	if truncateHead {
+		outputTruncate = utils.OutputTruncateHead
+	}
+
+	return &vmss.Runtime{
+		Credential:      cred,
+		VM:              vm,
+		OutputTruncate:  outputTruncate,
+		Backend:         backend,
+		OutputContainer: resolveRunCommandOutputContainer(clusterName),
+	}, nil
+}
+
+// buildNodeVMSSRuntime creates the azure-api runtime for a node of a cluster
+// from its configuration.
+func buildNodeVMSSRuntime(clusterName string, nc *config.Config) (pkgruntime.Runtime, error) {
+	cred, err := utils.GetCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("authenticating: %w", err)
+	}
+
+	vm, err := utils.VirtualMachineFromNodeConfig(nc)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, err := resolveRunCommandBackend(clusterName)
+	if err != nil {
+		return nil, err
+	}
+
 	outputTruncate := utils.OutputTruncateTail
 	if truncateHead {
 		outputTruncate = utils.OutputTruncateHead
 	}
 
 	return &vmss.Runtime{
-		Credential:     cred,
-		VM:             vm,
-		OutputTruncate: outputTruncate,
+		Credential:      cred,
+		VM:              vm,
+		OutputTruncate:  outputTruncate,
+		Backend:         backend,
+		OutputContainer: resolveRunCommandOutputContainer(clusterName),
 	}, nil
 }
 
-func buildKubectlDebugRuntime() (pkgruntime.Runtime, error) {
+func buildKubectlDebugRuntime(clusterName string) (pkgruntime.Runtime, error) {
+	profile, err := resolveDebugPodProfile(clusterName)
+	if err != nil {
+		return nil, err
+	}
+
 	config, err := utils.KubernetesConfigFlags.ToRESTConfig()
 	if err != nil {
 		return nil, fmt.Errorf("getting kubernetes config: %w", err)
@@ -243,10 +386,87 @@ func buildKubectlDebugRuntime() (pkgruntime.Runtime, error) {
 	if err != nil {
 		return nil, fmt.Errorf("creating kubernetes client: %w", err)
 	}
+	startupCleanup(clientset)
 
 	return &kubectldebug.Runtime{
 		Clientset: clientset,
 		Config:    config,
 		Image:     debugImage,
+		Profile:   profile,
+	}, nil
+}
+
+func buildAgentRuntime() (pkgruntime.Runtime, error) {
+	config, err := utils.KubernetesConfigFlags.ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("getting kubernetes config: %w", err)
+	}
+
+	clientset, err := k8sutil.NewClientsetFromConfigFlags(utils.KubernetesConfigFlags)
+	if err != nil {
+		return nil, fmt.Errorf("creating kubernetes client: %w", err)
+	}
+
+	return &agent.Runtime{
+		Clientset: clientset,
+		Config:    config,
+		Version:   version,
+	}, nil
+}
+
+// buildAutoRuntime creates the runtime choosing, for each node, the first
+// usable runtime among agent, kube-api, azure-api and aks-command, from the
+// fastest to the one working in most situations. nc is the configuration of
+// the node in the cluster, or nil to use the node flags.
+func buildAutoRuntime(ctx context.Context, clusterName string, nc *config.Config) pkgruntime.Runtime {
+	return &auto.Runtime{Candidates: []auto.Candidate{
+		{Name: RuntimeAgent, New: buildAgentRuntime},
+		{Name: RuntimeKubeAPI, New: func() (pkgruntime.Runtime, error) {
+			return buildKubectlDebugRuntime(clusterName)
+		}},
+		{Name: RuntimeAzureAPI, New: func() (pkgruntime.Runtime, error) {
+			if nc == nil {
+				return buildVMSSRuntime()
+			}
+			return buildNodeVMSSRuntime(clusterName, nc)
+		}},
+		{Name: RuntimeAKSCommand, New: func() (pkgruntime.Runtime, error) {
+			return buildAKSCommandRuntime(ctx, clusterName)
+		}},
+	}}
+}
+
+// buildAKSCommandRuntime creates the aks-command runtime for a cluster, using
+// the subscription and resource group stored by 'config import'.
+func buildAKSCommandRuntime(ctx context.Context, clusterName string) (pkgruntime.Runtime, error) {
+	if clusterName == "" {
+		return nil, fmt.Errorf("%s runtime requires a cluster in the configuration: import it with 'kubectl aks config import'",
+			RuntimeAKSCommand)
+	}
+	subscriptionID, resourceGroup := config.New().GetClusterMetadata(clusterName)
+	if subscriptionID == "" || resourceGroup == "" {
+		return nil, fmt.Errorf("cluster %q has no subscription and resource group in the configuration: import it with --runtime %s",
+			clusterName, RuntimeAzureAPI)
+	}
+
+	profile, err := resolveDebugPodProfile(clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := utils.GetCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("authenticating: %w", err)
+	}
+	armOpts := utils.ARMClientOptions(utils.GetCloudConfiguration())
+	inv, err := akscommand.NewClusterInvoker(ctx, cred, armOpts, subscriptionID, resourceGroup, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &akscommand.Runtime{
+		Invoker: inv,
+		Image:   debugImage,
+		Profile: profile,
 	}, nil
 }
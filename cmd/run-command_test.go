@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+// fakeRuntime runs every command successfully on nodes running os.
+type fakeRuntime struct {
+	os  pkgruntime.OS
+	ran bool
+}
+
+func (r *fakeRuntime) RunCommand(ctx context.Context, opts *pkgruntime.RunOptions) (*pkgruntime.RunResult, error) {
+	r.ran = true
+	return &pkgruntime.RunResult{ExitCode: exitCode(0)}, nil
+}
+
+func (r *fakeRuntime) NodeOS(ctx context.Context, nodeName string) (pkgruntime.OS, error) {
+	return r.os, nil
+}
+
+func TestRunOnNodeWindows(t *testing.T) {
+	defer func(file string, env []string) { scriptFile, envVars = file, env }(scriptFile, envVars)
+
+	for _, tc := range []struct {
+		description string
+		file        string
+		env         []string
+		os          pkgruntime.OS
+		expectedErr string
+	}{
+		{description: "command on Windows", os: pkgruntime.OSWindows},
+		{description: "script on Linux", file: "collect.sh", os: pkgruntime.OSLinux},
+		{description: "script on Windows", file: "collect.sh", os: pkgruntime.OSWindows, expectedErr: "only supported on Linux nodes"},
+		{description: "env on Windows", env: []string{"A=1"}, os: pkgruntime.OSWindows, expectedErr: "only supported on Linux nodes"},
+	} {
+		scriptFile, envVars = tc.file, tc.env
+		rt := &fakeRuntime{os: tc.os}
+		nr := runOnNode(context.Background(), rt, "akswin000000")
+		if tc.expectedErr == "" {
+			if nr.Err != nil || !rt.ran {
+				t.Fatalf("Failed test %q: err is %v, ran is %t", tc.description, nr.Err, rt.ran)
+			}
+			continue
+		}
+		if nr.Err == nil || !strings.Contains(nr.Err.Error(), tc.expectedErr) || rt.ran {
+			t.Fatalf("Failed test %q: err is %v, want %q", tc.description, nr.Err, tc.expectedErr)
+		}
+	}
+}
@@ -4,6 +4,10 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
+	"github.com/Azure/kubectl-aks/cmd/utils"
 	"github.com/Azure/kubectl-aks/cmd/utils/config"
 )
 
@@ -27,3 +31,43 @@ func resolveRuntimeFromConfig() {
 		}
 	}
 }
+
+// resolveRunCommandBackend returns the RunCommand backend of the azure-api
+// runtime: the --run-command-backend flag, the one set for the cluster, or
+// the action.
+func resolveRunCommandBackend(clusterName string) (utils.RunCommandBackend, error) {
+	backend := runCommandBackendFlag
+	if backend == "" && clusterName != "" {
+		backend = config.New().GetClusterRunCommandBackend(clusterName)
+	}
+	if backend == "" {
+		return utils.RunCommandBackendAction, nil
+	}
+	return utils.ParseRunCommandBackend(backend)
+}
+
+// resolveRunCommandOutputContainer returns the URL of the blob container the
+// managed run commands write their output to: the
+// --run-command-output-container flag or the one set for the cluster.
+func resolveRunCommandOutputContainer(clusterName string) string {
+	if runCommandOutputFlag != "" || clusterName == "" {
+		return runCommandOutputFlag
+	}
+	return config.New().GetClusterRunCommandOutputContainer(clusterName)
+}
+
+// resolveDebugPodProfile returns the debug pod profile: the content of the
+// --debug-pod-profile file or the one set for the cluster.
+func resolveDebugPodProfile(clusterName string) ([]byte, error) {
+	if debugPodProfileFlag != "" {
+		profile, err := os.ReadFile(debugPodProfileFlag)
+		if err != nil {
+			return nil, fmt.Errorf("reading debug pod profile: %w", err)
+		}
+		return profile, nil
+	}
+	if clusterName == "" {
+		return nil, nil
+	}
+	return []byte(config.New().GetClusterDebugPodProfile(clusterName)), nil
+}
@@ -11,8 +11,12 @@ import (
 )
 
 const (
-	currentClusterKey = "current-cluster"
-	clustersKey       = "clusters"
+	currentClusterKey    = "current-cluster"
+	clustersKey          = "clusters"
+	sysctlBaselineKey    = "sysctl-baseline"
+	runCommandBackendKey = "run-command-backend"
+	runCommandOutputKey  = "run-command-output-container"
+	debugPodProfileKey   = "debug-pod-profile"
 )
 
 // IsLegacyConfig returns true if the config uses the old format (top-level
@@ -136,6 +140,122 @@ func (c *Config) SetClusterMetadata(clusterName, subscriptionID, resourceGroup s
 	return nil
 }
 
+// GetClusterMetadata returns the subscription and resource group stored for a
+// cluster. They are empty for clusters imported via the Kubernetes API.
+func (c *Config) GetClusterMetadata(clusterName string) (subscriptionID, resourceGroup string) {
+	if err := c.ReadInConfig(); err != nil {
+		return "", ""
+	}
+	prefix := clustersKey + "." + clusterName
+	return c.GetString(prefix + ".subscription"), c.GetString(prefix + ".resource-group")
+}
+
+// SetClusterSysctlBaseline stores the sysctl baseline of a cluster under
+// clusters.<clusterName>.sysctl-baseline. The baseline is kept as a list of
+// sysctl.conf lines because viper would treat the dots in sysctl names as
+// nested keys.
+func (c *Config) SetClusterSysctlBaseline(clusterName string, lines []string) error {
+	if err := c.ReadInConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	if !c.IsSet(clustersKey + "." + clusterName) {
+		return fmt.Errorf("cluster %q not found", clusterName)
+	}
+	c.Set(clustersKey+"."+clusterName+"."+sysctlBaselineKey, lines)
+	if err := c.WriteConfig(); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	return nil
+}
+
+// GetClusterSysctlBaseline returns the sysctl baseline lines stored for a
+// cluster, or nil if there is none.
+func (c *Config) GetClusterSysctlBaseline(clusterName string) []string {
+	if err := c.ReadInConfig(); err != nil {
+		return nil
+	}
+	return c.GetStringSlice(clustersKey + "." + clusterName + "." + sysctlBaselineKey)
+}
+
+// SetClusterRunCommandBackend stores the RunCommand backend of a cluster
+// under clusters.<clusterName>.run-command-backend. An empty backend removes
+// the setting.
+func (c *Config) SetClusterRunCommandBackend(clusterName, backend string) error {
+	if err := c.ReadInConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	if !c.IsSet(clustersKey + "." + clusterName) {
+		return fmt.Errorf("cluster %q not found", clusterName)
+	}
+	c.Set(clustersKey+"."+clusterName+"."+runCommandBackendKey, backend)
+	if err := c.WriteConfig(); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	return nil
+}
+
+// GetClusterRunCommandBackend returns the RunCommand backend stored for a
+// cluster, or "" if there is none.
+func (c *Config) GetClusterRunCommandBackend(clusterName string) string {
+	if err := c.ReadInConfig(); err != nil {
+		return ""
+	}
+	return c.GetString(clustersKey + "." + clusterName + "." + runCommandBackendKey)
+}
+
+// SetClusterRunCommandOutputContainer stores the URL of the blob container
+// managed run commands of a cluster write their output to under
+// clusters.<clusterName>.run-command-output-container. An empty URL removes
+// the setting.
+func (c *Config) SetClusterRunCommandOutputContainer(clusterName, containerURL string) error {
+	if err := c.ReadInConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	if !c.IsSet(clustersKey + "." + clusterName) {
+		return fmt.Errorf("cluster %q not found", clusterName)
+	}
+	c.Set(clustersKey+"."+clusterName+"."+runCommandOutputKey, containerURL)
+	if err := c.WriteConfig(); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	return nil
+}
+
+// GetClusterRunCommandOutputContainer returns the URL of the blob container
+// stored for the output of the managed run commands of a cluster, or "" if
+// there is none.
+func (c *Config) GetClusterRunCommandOutputContainer(clusterName string) string {
+	if err := c.ReadInConfig(); err != nil {
+		return ""
+	}
+	return c.GetString(clustersKey + "." + clusterName + "." + runCommandOutputKey)
+}
+
+// SetClusterDebugPodProfile stores the debug pod profile of a cluster under
+// clusters.<clusterName>.debug-pod-profile. An empty profile removes it.
+func (c *Config) SetClusterDebugPodProfile(clusterName, profile string) error {
+	if err := c.ReadInConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	if !c.IsSet(clustersKey + "." + clusterName) {
+		return fmt.Errorf("cluster %q not found", clusterName)
+	}
+	c.Set(clustersKey+"."+clusterName+"."+debugPodProfileKey, profile)
+	if err := c.WriteConfig(); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	return nil
+}
+
+// GetClusterDebugPodProfile returns the debug pod profile stored for a
+// cluster, or "" if there is none.
+func (c *Config) GetClusterDebugPodProfile(clusterName string) string {
+	if err := c.ReadInConfig(); err != nil {
+		return ""
+	}
+	return c.GetString(clustersKey + "." + clusterName + "." + debugPodProfileKey)
+}
+
 // GetClusterNodeConfig returns the viper sub-tree for a node within a cluster.
 func (c *Config) GetClusterNodeConfig(clusterName, nodeName string) (*Config, bool) {
 	if err := c.ReadInConfig(); err != nil {
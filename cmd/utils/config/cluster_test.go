@@ -89,13 +89,35 @@ func TestClusterConfig(t *testing.T) {
 		t.Parallel()
 		cfg := createAndReadClusterConfig(t)
 
-		err := cfg.SetClusterNodeConfigWithVMSSInfo("test-cluster", "new-node", "sub1", "rg1", "vmss1", "id1")
+		err := cfg.SetClusterNodeConfigWithVMSSInfo("test-cluster", "new-node", "sub1", "rg1", "vmss1", "id1", "")
 		require.NoError(t, err)
 
 		nc, ok := cfg.GetClusterNodeConfig("test-cluster", "new-node")
 		require.True(t, ok)
 		require.Equal(t, "sub1", nc.GetString("subscription"))
 		require.Equal(t, "vmss1", nc.GetString("vmss"))
+		require.False(t, nc.IsSet("os"))
+
+		err = cfg.SetClusterNodeConfigWithVMSSInfo("test-cluster", "akswin000000", "sub1", "rg1", "akswin", "0", "windows")
+		require.NoError(t, err)
+
+		nc, ok = cfg.GetClusterNodeConfig("test-cluster", "akswin000000")
+		require.True(t, ok)
+		require.Equal(t, "windows", nc.GetString("os"))
+	})
+
+	t.Run("SetClusterNodeConfigWithVMInfo", func(t *testing.T) {
+		t.Parallel()
+		cfg := createAndReadClusterConfig(t)
+
+		err := cfg.SetClusterNodeConfigWithVMInfo("test-cluster", "aks-vmpool-12345678-0", "sub1", "rg1", "aks-vmpool-12345678-0", "linux")
+		require.NoError(t, err)
+
+		nc, ok := cfg.GetClusterNodeConfig("test-cluster", "aks-vmpool-12345678-0")
+		require.True(t, ok)
+		require.Equal(t, "aks-vmpool-12345678-0", nc.GetString("vm-name"))
+		require.Equal(t, "linux", nc.GetString("os"))
+		require.False(t, nc.IsSet("vmss"))
 	})
 
 	t.Run("DeleteClusterNode", func(t *testing.T) {
@@ -125,6 +147,84 @@ func TestClusterConfig(t *testing.T) {
 		require.Contains(t, clusters, "brand-new")
 	})
 
+	t.Run("ClusterSysctlBaseline", func(t *testing.T) {
+		t.Parallel()
+		cfg := createAndReadClusterConfig(t)
+
+		require.Nil(t, cfg.GetClusterSysctlBaseline("test-cluster"))
+
+		err := cfg.SetClusterSysctlBaseline("non-existent", []string{"vm.max_map_count = 262144"})
+		require.Error(t, err)
+
+		lines := []string{"vm.max_map_count = 262144", "net.core.somaxconn = 16384"}
+		err = cfg.SetClusterSysctlBaseline("test-cluster", lines)
+		require.NoError(t, err)
+		require.Equal(t, lines, cfg.GetClusterSysctlBaseline("test-cluster"))
+		require.Nil(t, cfg.GetClusterSysctlBaseline("another-cluster"))
+	})
+
+	t.Run("ClusterDebugPodProfile", func(t *testing.T) {
+		t.Parallel()
+		cfg := createAndReadClusterConfig(t)
+
+		require.Equal(t, "", cfg.GetClusterDebugPodProfile("test-cluster"))
+
+		err := cfg.SetClusterDebugPodProfile("non-existent", "metadata: {}")
+		require.Error(t, err)
+
+		profile := "metadata:\n  namespace: aks-debug\n"
+		err = cfg.SetClusterDebugPodProfile("test-cluster", profile)
+		require.NoError(t, err)
+		require.Equal(t, profile, cfg.GetClusterDebugPodProfile("test-cluster"))
+		require.Equal(t, "", cfg.GetClusterDebugPodProfile("another-cluster"))
+	})
+
+	t.Run("ClusterMetadata", func(t *testing.T) {
+		t.Parallel()
+		cfg := createAndReadClusterConfig(t)
+
+		err := cfg.SetClusterMetadata("brand-new", "sub1", "rg1")
+		require.NoError(t, err)
+		subscriptionID, resourceGroup := cfg.GetClusterMetadata("brand-new")
+		require.Equal(t, "sub1", subscriptionID)
+		require.Equal(t, "rg1", resourceGroup)
+
+		subscriptionID, resourceGroup = cfg.GetClusterMetadata("non-existent")
+		require.Empty(t, subscriptionID)
+		require.Empty(t, resourceGroup)
+	})
+
+	t.Run("ClusterRunCommandBackend", func(t *testing.T) {
+		t.Parallel()
+		cfg := createAndReadClusterConfig(t)
+
+		require.Equal(t, "", cfg.GetClusterRunCommandBackend("test-cluster"))
+
+		err := cfg.SetClusterRunCommandBackend("non-existent", "managed")
+		require.Error(t, err)
+
+		err = cfg.SetClusterRunCommandBackend("test-cluster", "managed")
+		require.NoError(t, err)
+		require.Equal(t, "managed", cfg.GetClusterRunCommandBackend("test-cluster"))
+		require.Equal(t, "", cfg.GetClusterRunCommandBackend("another-cluster"))
+	})
+
+	t.Run("ClusterRunCommandOutputContainer", func(t *testing.T) {
+		t.Parallel()
+		cfg := createAndReadClusterConfig(t)
+
+		const url = "https://mystorage.blob.core.windows.net/runcommand?sv=2022-11-02&sig=abc"
+		require.Equal(t, "", cfg.GetClusterRunCommandOutputContainer("test-cluster"))
+
+		err := cfg.SetClusterRunCommandOutputContainer("non-existent", url)
+		require.Error(t, err)
+
+		err = cfg.SetClusterRunCommandOutputContainer("test-cluster", url)
+		require.NoError(t, err)
+		require.Equal(t, url, cfg.GetClusterRunCommandOutputContainer("test-cluster"))
+		require.Equal(t, "", cfg.GetClusterRunCommandOutputContainer("another-cluster"))
+	})
+
 	t.Run("UnsetCurrentClusterConfig", func(t *testing.T) {
 		t.Parallel()
 		cfg := createAndReadClusterConfig(t)
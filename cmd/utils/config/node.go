@@ -96,7 +96,8 @@ func (c *Config) SetNodeConfigWithVMSSInfoFlag(nodeName, subscriptionIDFlag, nod
 }
 
 // SetClusterNodeConfigWithVMSSInfo stores a node under a specific cluster.
-func (c *Config) SetClusterNodeConfigWithVMSSInfo(clusterName, nodeName, subscriptionID, nodeResourceGroup, vmssName, instanceID string) error {
+// The node OS is only stored when known.
+func (c *Config) SetClusterNodeConfigWithVMSSInfo(clusterName, nodeName, subscriptionID, nodeResourceGroup, vmssName, instanceID, nodeOS string) error {
 	if err := os.MkdirAll(Dir(), 0o700); err != nil {
 		return fmt.Errorf("creating config directory: %w", err)
 	}
@@ -108,6 +109,31 @@ func (c *Config) SetClusterNodeConfigWithVMSSInfo(clusterName, nodeName, subscri
 	c.Set(prefix+".node-resource-group", nodeResourceGroup)
 	c.Set(prefix+".vmss", vmssName)
 	c.Set(prefix+".instance-id", instanceID)
+	if nodeOS != "" {
+		c.Set(prefix+".os", nodeOS)
+	}
+	if err := c.WriteConfig(); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	return nil
+}
+
+// SetClusterNodeConfigWithVMInfo stores a node backed by a standalone VM
+// under a specific cluster. The node OS is only stored when known.
+func (c *Config) SetClusterNodeConfigWithVMInfo(clusterName, nodeName, subscriptionID, nodeResourceGroup, vmName, nodeOS string) error {
+	if err := os.MkdirAll(Dir(), 0o700); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+	if err := c.ReadInConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	prefix := clustersKey + "." + clusterName + ".nodes." + nodeName
+	c.Set(prefix+".subscription", subscriptionID)
+	c.Set(prefix+".node-resource-group", nodeResourceGroup)
+	c.Set(prefix+".vm-name", vmName)
+	if nodeOS != "" {
+		c.Set(prefix+".os", nodeOS)
+	}
 	if err := c.WriteConfig(); err != nil {
 		return fmt.Errorf("writing config: %w", err)
 	}
@@ -21,7 +21,9 @@ const (
 	NodeResourceGroupKey = "node-resource-group"
 	VMSSKey              = "vmss"
 	VMSSInstanceIDKey    = "instance-id"
+	VMNameKey            = "vm-name"
 	ResourceIDKey        = "id"
+	OSKey                = "os"
 )
 
 // We need package level variables to ensure that the viper flag binding works correctly.
@@ -64,7 +66,8 @@ func AddCommonFlags(command *cobra.Command, flags *CommonFlags) {
 // Every command that allows user to specify the node name has three options:
 // (1) Provide the kubernetes node name
 // (2) Provide the VMMS instance information (--subscription, --node-resource-group, --vmss and --instance-id)
-// (3) Provide Resource ID (/subscriptions/mySubID/resourceGroups/myRG/providers/myProvider/virtualMachineScaleSets/myVMSS/virtualMachines/myInsID)
+// (3) Provide Resource ID (/subscriptions/mySubID/resourceGroups/myRG/providers/myProvider/virtualMachineScaleSets/myVMSS/virtualMachines/myInsID
+// or /subscriptions/mySubID/resourceGroups/myRG/providers/myProvider/virtualMachines/myVM)
 func AddNodeFlags(command *cobra.Command) {
 	addNodeFlags(command, false)
 }
@@ -115,8 +118,9 @@ func addNodeFlags(command *cobra.Command, useFlagsOnly bool) {
 		&resourceID,
 		ResourceIDKey, "",
 		"",
-		`Resource ID containing all information of the VMSS instance using format:
-		e.g. /subscriptions/mySubID/resourceGroups/myRG/providers/myProvider/virtualMachineScaleSets/myVMSS/virtualMachines/myInsID.
+		`Resource ID containing all information of the VMSS instance or VM using format:
+		e.g. /subscriptions/mySubID/resourceGroups/myRG/providers/myProvider/virtualMachineScaleSets/myVMSS/virtualMachines/myInsID
+		or /subscriptions/mySubID/resourceGroups/myRG/providers/myProvider/virtualMachines/myVM.
 		Notice it is not case sensitive.`,
 	)
 
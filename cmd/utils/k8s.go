@@ -8,8 +8,11 @@ import (
 	"strings"
 
 	"github.com/kinvolk/inspektor-gadget/pkg/k8sutil"
+	coreV1 "k8s.io/api/core/v1"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
 )
 
 var KubernetesConfigFlags = genericclioptions.NewConfigFlags(false)
@@ -25,18 +28,36 @@ func DetectKubeconfigClusterName() string {
 }
 
 // GetNodeResourceID retrieve the Azure resource ID of a given node. In other
-// words, the resource ID of the VM scale set instance. It returns format:
+// words, the resource ID of the VM scale set instance or VM. It returns format:
 // /subscriptions/mySubID/resourceGroups/myRG/providers/myProvider/virtualMachineScaleSets/myVMSS/virtualMachines/myInsID
+// or /subscriptions/mySubID/resourceGroups/myRG/providers/myProvider/virtualMachines/myVM
 func GetNodeResourceID(ctx context.Context, nodeName string) (string, error) {
-	client, err := k8sutil.NewClientsetFromConfigFlags(KubernetesConfigFlags)
+	node, err := getNode(ctx, nodeName)
 	if err != nil {
 		return "", err
 	}
+	return nodeResourceID(node), nil
+}
 
-	nodeRes, err := client.CoreV1().Nodes().Get(ctx, nodeName, metaV1.GetOptions{})
+// getNode retrieves a node from the API server.
+func getNode(ctx context.Context, nodeName string) (*coreV1.Node, error) {
+	client, err := k8sutil.NewClientsetFromConfigFlags(KubernetesConfigFlags)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return client.CoreV1().Nodes().Get(ctx, nodeName, metaV1.GetOptions{})
+}
+
+// nodeResourceID returns the Azure resource ID of the VM of a node.
+func nodeResourceID(node *coreV1.Node) string {
+	return strings.TrimPrefix(node.Spec.ProviderID, "azure://")
+}
 
-	return strings.TrimPrefix(nodeRes.Spec.ProviderID, "azure://"), nil
+// nodeOS returns the operating system of a node from its kubernetes.io/os
+// label, falling back to the one reported by the kubelet.
+func nodeOS(node *coreV1.Node) pkgruntime.OS {
+	if os := node.Labels[coreV1.LabelOSStable]; os != "" {
+		return pkgruntime.OS(os)
+	}
+	return pkgruntime.OS(node.Status.NodeInfo.OperatingSystem)
 }
@@ -0,0 +1,474 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/Azure/go-autorest/autorest/to"
+	log "github.com/sirupsen/logrus"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+// RunCommandBackend is the Azure API used to run commands on VMs.
+type RunCommandBackend string
+
+const (
+	// RunCommandBackendAction uses the runCommand action of the VM, which
+	// allows a single execution at a time and can't be cancelled.
+	RunCommandBackendAction RunCommandBackend = "action"
+	// RunCommandBackendManaged creates a runCommands resource of the VM per
+	// execution. Deleting the resource cancels the execution.
+	RunCommandBackendManaged RunCommandBackend = "managed"
+)
+
+const (
+	managedPollingFreq = 2 * time.Second
+	// managedTimeoutMargin is added to the command timeout for the API
+	// timeout: the command stops itself so its output is still reported.
+	managedTimeoutMargin = 60
+	// managedDeleteTimeout bounds the deletion of a run command, which also
+	// happens after the command context is cancelled.
+	managedDeleteTimeout = 30 * time.Second
+)
+
+// ErrManagedRunCommandUnavailable is returned when the creation of a managed
+// run command was rejected, so the command didn't run.
+var ErrManagedRunCommandUnavailable = errors.New("managed run command unavailable")
+
+// ParseRunCommandBackend validates a RunCommand backend name.
+func ParseRunCommandBackend(s string) (RunCommandBackend, error) {
+	switch b := RunCommandBackend(s); b {
+	case RunCommandBackendAction, RunCommandBackendManaged:
+		return b, nil
+	default:
+		return "", fmt.Errorf("unsupported RunCommand backend %q: use %q or %q",
+			s, RunCommandBackendAction, RunCommandBackendManaged)
+	}
+}
+
+// managedRunCommandClient manages the run commands of a VM.
+type managedRunCommandClient interface {
+	location(ctx context.Context) (string, error)
+	// create submits the creation of a run command and returns a function
+	// waiting for it to complete.
+	create(ctx context.Context, name string, rc armcompute.VirtualMachineRunCommand) (wait func(context.Context) error, err error)
+	get(ctx context.Context, name string) (*armcompute.VirtualMachineRunCommandInstanceView, error)
+	delete(ctx context.Context, name string) error
+}
+
+type scaleSetVMRunCommands struct {
+	vm       *VirtualMachine
+	vms      *armcompute.VirtualMachineScaleSetVMsClient
+	commands *armcompute.VirtualMachineScaleSetVMRunCommandsClient
+}
+
+func (c *scaleSetVMRunCommands) location(ctx context.Context) (string, error) {
+	res, err := c.vms.Get(ctx, c.vm.NodeResourceGroup, c.vm.VMScaleSet, c.vm.InstanceID, nil)
+	if err != nil {
+		return "", err
+	}
+	return to.String(res.Location), nil
+}
+
+func (c *scaleSetVMRunCommands) create(ctx context.Context, name string, rc armcompute.VirtualMachineRunCommand) (func(context.Context) error, error) {
+	poller, err := c.commands.BeginCreateOrUpdate(ctx, c.vm.NodeResourceGroup, c.vm.VMScaleSet, c.vm.InstanceID, name, rc, nil)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		_, err := poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: managedPollingFreq})
+		return err
+	}, nil
+}
+
+func (c *scaleSetVMRunCommands) get(ctx context.Context, name string) (*armcompute.VirtualMachineRunCommandInstanceView, error) {
+	res, err := c.commands.Get(ctx, c.vm.NodeResourceGroup, c.vm.VMScaleSet, c.vm.InstanceID, name,
+		&armcompute.VirtualMachineScaleSetVMRunCommandsClientGetOptions{Expand: to.StringPtr("instanceView")})
+	if err != nil {
+		return nil, err
+	}
+	if res.Properties == nil {
+		return nil, nil
+	}
+	return res.Properties.InstanceView, nil
+}
+
+func (c *scaleSetVMRunCommands) delete(ctx context.Context, name string) error {
+	_, err := c.commands.BeginDelete(ctx, c.vm.NodeResourceGroup, c.vm.VMScaleSet, c.vm.InstanceID, name, nil)
+	return err
+}
+
+type vmRunCommands struct {
+	vm       *VirtualMachine
+	vms      *armcompute.VirtualMachinesClient
+	commands *armcompute.VirtualMachineRunCommandsClient
+}
+
+func (c *vmRunCommands) location(ctx context.Context) (string, error) {
+	res, err := c.vms.Get(ctx, c.vm.NodeResourceGroup, c.vm.Name, nil)
+	if err != nil {
+		return "", err
+	}
+	return to.String(res.Location), nil
+}
+
+func (c *vmRunCommands) create(ctx context.Context, name string, rc armcompute.VirtualMachineRunCommand) (func(context.Context) error, error) {
+	poller, err := c.commands.BeginCreateOrUpdate(ctx, c.vm.NodeResourceGroup, c.vm.Name, name, rc, nil)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		_, err := poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: managedPollingFreq})
+		return err
+	}, nil
+}
+
+func (c *vmRunCommands) get(ctx context.Context, name string) (*armcompute.VirtualMachineRunCommandInstanceView, error) {
+	res, err := c.commands.GetByVirtualMachine(ctx, c.vm.NodeResourceGroup, c.vm.Name, name,
+		&armcompute.VirtualMachineRunCommandsClientGetByVirtualMachineOptions{Expand: to.StringPtr("instanceView")})
+	if err != nil {
+		return nil, err
+	}
+	if res.Properties == nil {
+		return nil, nil
+	}
+	return res.Properties.InstanceView, nil
+}
+
+func (c *vmRunCommands) delete(ctx context.Context, name string) error {
+	_, err := c.commands.BeginDelete(ctx, c.vm.NodeResourceGroup, c.vm.Name, name, nil)
+	return err
+}
+
+func newManagedRunCommandClient(cred azcore.TokenCredential, armOpts *arm.ClientOptions, vm *VirtualMachine) (managedRunCommandClient, error) {
+	if !vm.IsScaleSetVM() {
+		vms, err := armcompute.NewVirtualMachinesClient(vm.SubscriptionID, cred, armOpts)
+		if err != nil {
+			return nil, fmt.Errorf("creating VMs client: %w", err)
+		}
+		commands, err := armcompute.NewVirtualMachineRunCommandsClient(vm.SubscriptionID, cred, armOpts)
+		if err != nil {
+			return nil, fmt.Errorf("creating VM run commands client: %w", err)
+		}
+		return &vmRunCommands{vm: vm, vms: vms, commands: commands}, nil
+	}
+
+	vms, err := armcompute.NewVirtualMachineScaleSetVMsClient(vm.SubscriptionID, cred, armOpts)
+	if err != nil {
+		return nil, fmt.Errorf("creating VMSS VMs client: %w", err)
+	}
+	commands, err := armcompute.NewVirtualMachineScaleSetVMRunCommandsClient(vm.SubscriptionID, cred, armOpts)
+	if err != nil {
+		return nil, fmt.Errorf("creating VMSS VM run commands client: %w", err)
+	}
+	return &scaleSetVMRunCommands{vm: vm, vms: vms, commands: commands}, nil
+}
+
+// managedRunCommandName returns a unique run command name, so that several
+// commands can run on the same VM at the same time.
+func managedRunCommandName() string {
+	return fmt.Sprintf("kubectl-aks-%d", time.Now().UnixNano())
+}
+
+// isExecutionDone returns whether a run command has finished.
+func isExecutionDone(view *armcompute.VirtualMachineRunCommandInstanceView) bool {
+	if view == nil || view.ExecutionState == nil {
+		return false
+	}
+	switch *view.ExecutionState {
+	case armcompute.ExecutionStateSucceeded, armcompute.ExecutionStateFailed,
+		armcompute.ExecutionStateTimedOut, armcompute.ExecutionStateCanceled:
+		return true
+	default:
+		return false
+	}
+}
+
+// outputBlobs are the append blobs a managed run command writes its stdout
+// and stderr to. Unlike the instance view, they aren't limited to 4,096 bytes.
+// Their URLs contain a SAS token, so they must not be logged.
+type outputBlobs struct {
+	stdout string
+	stderr string
+}
+
+// ParseRunCommandOutputContainer validates the URL of the blob container the
+// managed run commands write their output to. Its SAS token must allow to
+// create, write, read and delete blobs. The URL isn't part of the error, as
+// the token is a secret.
+func ParseRunCommandOutputContainer(containerURL string) (*url.URL, error) {
+	u, err := url.Parse(containerURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" || strings.Trim(u.Path, "/") == "" || u.RawQuery == "" {
+		return nil, errors.New("invalid run command output container URL: expected https://<account>.blob.<suffix>/<container>?<SAS token>")
+	}
+	return u, nil
+}
+
+// newOutputBlobs returns the output blobs of the run command name in the
+// container at containerURL.
+func newOutputBlobs(containerURL, name string) (*outputBlobs, error) {
+	u, err := ParseRunCommandOutputContainer(containerURL)
+	if err != nil {
+		return nil, err
+	}
+	blob := func(suffix string) string {
+		b := *u
+		b.Path = strings.TrimSuffix(u.Path, "/") + "/" + name + suffix
+		b.RawPath = ""
+		return b.String()
+	}
+	return &outputBlobs{stdout: blob(".stdout"), stderr: blob(".stderr")}, nil
+}
+
+// blobRequest sends a request for a blob. Its errors don't contain the URL.
+func blobRequest(ctx context.Context, method, blobURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, blobURL, nil)
+	if err != nil {
+		return nil, errors.New("invalid blob URL")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return nil, uerr.Err
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// readBlob returns the content of a blob, or "" if it doesn't exist, e.g.
+// when the command didn't write to stderr.
+func readBlob(ctx context.Context, blobURL string) (string, error) {
+	res, err := blobRequest(ctx, http.MethodGet, blobURL)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", nil
+	default:
+		return "", fmt.Errorf("unexpected HTTP status %q", res.Status)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(string(b), "\r\n", "\n"), nil
+}
+
+// read returns the stdout and stderr written to the blobs.
+func (b *outputBlobs) read(ctx context.Context) (stdout, stderr string, err error) {
+	if stdout, err = readBlob(ctx, b.stdout); err != nil {
+		return "", "", fmt.Errorf("reading stdout blob: %w", err)
+	}
+	if stderr, err = readBlob(ctx, b.stderr); err != nil {
+		return "", "", fmt.Errorf("reading stderr blob: %w", err)
+	}
+	return stdout, stderr, nil
+}
+
+// delete deletes the blobs, which may not exist.
+func (b *outputBlobs) delete(ctx context.Context) error {
+	for _, blobURL := range []string{b.stdout, b.stderr} {
+		res, err := blobRequest(ctx, http.MethodDelete, blobURL)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusAccepted && res.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("unexpected HTTP status %q", res.Status)
+		}
+	}
+	return nil
+}
+
+// runManagedCommand creates the run command name executing script, waits for
+// it to finish and deletes it. If ctx is cancelled, the run command is deleted
+// right away, which stops the script on the VM. The output is written to blobs
+// if given, otherwise it's only in the instance view.
+func runManagedCommand(ctx context.Context, client managedRunCommandClient, name, script string, blobs *outputBlobs,
+	timeout int, pollingFreq time.Duration,
+) (*armcompute.VirtualMachineRunCommandInstanceView, error) {
+	location, err := client.location(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: getting VM location: %w", ErrManagedRunCommandUnavailable, err)
+	}
+	rc := armcompute.VirtualMachineRunCommand{
+		Location: to.StringPtr(location),
+		Properties: &armcompute.VirtualMachineRunCommandProperties{
+			AsyncExecution:   to.BoolPtr(true),
+			TimeoutInSeconds: to.Int32Ptr(int32(timeout)),
+			Source:           &armcompute.VirtualMachineRunCommandScriptSource{Script: to.StringPtr(script)},
+		},
+	}
+	if blobs != nil {
+		rc.Properties.OutputBlobURI = to.StringPtr(blobs.stdout)
+		rc.Properties.ErrorBlobURI = to.StringPtr(blobs.stderr)
+	}
+
+	// The run command may exist even if its creation failed, e.g. when ctx
+	// was cancelled while waiting for it.
+	defer func() {
+		dctx, cancel := context.WithTimeout(context.Background(), managedDeleteTimeout)
+		defer cancel()
+		if err := client.delete(dctx, name); err != nil {
+			log.Warnf("Couldn't delete run command %s, it may keep running on the node: %s", name, err)
+		}
+	}()
+
+	wait, err := client.create(ctx, name, rc)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("command cancelled: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("%w: creating run command: %w", ErrManagedRunCommandUnavailable, err)
+	}
+	// Once the creation was accepted, the command may run, so errors must not
+	// make the caller run it again with the action.
+	if err := wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("command cancelled: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("creating run command: %w", err)
+	}
+
+	for {
+		view, err := client.get(ctx, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("command cancelled: %w", ctx.Err())
+			}
+			return nil, fmt.Errorf("getting run command status: %w", err)
+		}
+		if isExecutionDone(view) {
+			return view, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("command cancelled: %w", ctx.Err())
+		case <-time.After(pollingFreq):
+		}
+	}
+}
+
+// managedRunCommandResult returns the output of a finished run command. A
+// failed execution with an exit code is a command that exited with a non-zero
+// status, whose output is reported as with the action.
+func managedRunCommandResult(view *armcompute.VirtualMachineRunCommandInstanceView, timeout int) (*RunCommandResult, error) {
+	state := *view.ExecutionState
+	switch {
+	case state == armcompute.ExecutionStateSucceeded,
+		state == armcompute.ExecutionStateFailed && view.ExitCode != nil:
+		exitCode := int(to.Int32(view.ExitCode))
+		return &RunCommandResult{
+			Stdout:   strings.ReplaceAll(to.String(view.Output), "\r\n", "\n"),
+			Stderr:   strings.ReplaceAll(to.String(view.Error), "\r\n", "\n"),
+			ExitCode: &exitCode,
+		}, nil
+	case state == armcompute.ExecutionStateTimedOut:
+		return nil, fmt.Errorf("%w after %d seconds", pkgruntime.ErrTimeout, timeout)
+	default:
+		return nil, fmt.Errorf("command execution didn't succeed: %s: %s", state, to.String(view.ExecutionMessage))
+	}
+}
+
+// RunManagedCommand runs a command on a VM via a managed run command. It
+// returns an error wrapping ErrManagedRunCommandUnavailable when the run
+// command couldn't be created, so the caller can fall back to RunCommand.
+// With an outputContainer URL, the output is written to blobs of that
+// container and isn't truncated.
+func RunManagedCommand(
+	ctx context.Context,
+	cred azcore.TokenCredential,
+	vm *VirtualMachine,
+	command *string,
+	timeout *int,
+	outputTruncate OutputTruncate,
+	outputContainer string,
+) (
+	*RunCommandResult,
+	error,
+) {
+	if timeout == nil {
+		timeout = to.IntPtr(DefaultRunCommandTimeoutInSeconds)
+	}
+
+	cloudCfg := GetCloudConfiguration()
+	armOpts := ARMClientOptions(cloudCfg)
+
+	client, err := newManagedRunCommandClient(cred, armOpts, vm)
+	if err != nil {
+		return nil, err
+	}
+
+	name := managedRunCommandName()
+	b, _ := json.MarshalIndent(vm, "", "  ")
+	log.Debugf("Command: %s\nRun command: %s\nVirtual Machine:\n%s\n\n", *command, name, string(b))
+
+	var blobs *outputBlobs
+	if outputContainer != "" {
+		if blobs, err = newOutputBlobs(outputContainer, name); err != nil {
+			return nil, err
+		}
+		// The blobs hold the whole output, so the command isn't truncated:
+		// OutputTruncateHead leaves it as is.
+		outputTruncate = OutputTruncateHead
+		defer func() {
+			dctx, cancel := context.WithTimeout(context.Background(), managedDeleteTimeout)
+			defer cancel()
+			if err := blobs.delete(dctx); err != nil {
+				log.Warnf("Couldn't delete the output blobs of run command %s: %s", name, err)
+			}
+		}()
+	}
+
+	// Unlike the action, the execution can be stopped: cancelling ctx, e.g.
+	// with an interrupt, deletes the run command.
+	DefaultSpinner.Start()
+	DefaultSpinner.Suffix = " Running..."
+	defer DefaultSpinner.Stop()
+
+	// Without blobs, the output is read from the instance view, which is
+	// limited to the last 4,096 bytes as with the action.
+	script := wrapCommand(*command, *timeout, outputTruncate, vm.OS)
+	view, err := runManagedCommand(ctx, client, name, script, blobs, *timeout+managedTimeoutMargin, managedPollingFreq)
+	if err != nil {
+		return nil, err
+	}
+
+	b, _ = json.MarshalIndent(view, "", "  ")
+	log.Debugf("\nResponse:\n%s\n", string(b))
+	result, err := managedRunCommandResult(view, *timeout)
+	if err != nil {
+		return nil, err
+	}
+	if blobs != nil {
+		if result.Stdout, result.Stderr, err = blobs.read(ctx); err != nil {
+			return nil, fmt.Errorf("reading run command output: %w", err)
+		}
+	}
+	if outputTruncate == OutputTruncateTail && result.isTruncated() {
+		result.Stdout = fmt.Sprintf("%s... (truncated)\n", result.Stdout)
+	}
+	// The script exits with the exit code of the command too.
+	result.Stderr, _ = extractExitCode(result.Stderr)
+	return result, nil
+}
@@ -0,0 +1,243 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// fakeRunCommands reports the given states, one per get call, repeating the
+// last one.
+type fakeRunCommands struct {
+	createErr error
+	waitErr   error
+	states    []armcompute.ExecutionState
+	gets      int
+	created   *armcompute.VirtualMachineRunCommand
+	deleted   []string
+}
+
+func (f *fakeRunCommands) location(ctx context.Context) (string, error) {
+	return "westeurope", nil
+}
+
+func (f *fakeRunCommands) create(ctx context.Context, name string, rc armcompute.VirtualMachineRunCommand) (func(context.Context) error, error) {
+	f.created = &rc
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	return func(context.Context) error { return f.waitErr }, nil
+}
+
+func (f *fakeRunCommands) get(ctx context.Context, name string) (*armcompute.VirtualMachineRunCommandInstanceView, error) {
+	state := f.states[min(f.gets, len(f.states)-1)]
+	f.gets++
+	return &armcompute.VirtualMachineRunCommandInstanceView{
+		ExecutionState: &state,
+		Output:         to.StringPtr("out\r\n"),
+		ExitCode:       to.Int32Ptr(0),
+	}, nil
+}
+
+func (f *fakeRunCommands) delete(ctx context.Context, name string) error {
+	f.deleted = append(f.deleted, name)
+	return nil
+}
+
+func TestRunManagedCommand(t *testing.T) {
+	t.Run("succeeded", func(t *testing.T) {
+		f := &fakeRunCommands{states: []armcompute.ExecutionState{
+			armcompute.ExecutionStatePending, armcompute.ExecutionStateRunning, armcompute.ExecutionStateSucceeded,
+		}}
+		view, err := runManagedCommand(context.Background(), f, "rc", "uptime", nil, 60, time.Millisecond)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if *view.ExecutionState != armcompute.ExecutionStateSucceeded || f.gets != 3 {
+			t.Fatalf("expected Succeeded after 3 polls, got %s after %d", *view.ExecutionState, f.gets)
+		}
+		p := f.created.Properties
+		if to.String(f.created.Location) != "westeurope" || !*p.AsyncExecution || *p.TimeoutInSeconds != 60 || to.String(p.Source.Script) != "uptime" {
+			t.Fatalf("unexpected run command: %+v %+v", f.created, p)
+		}
+		if p.OutputBlobURI != nil || p.ErrorBlobURI != nil {
+			t.Fatalf("expected no output blobs, got %v %v", p.OutputBlobURI, p.ErrorBlobURI)
+		}
+		if len(f.deleted) != 1 || f.deleted[0] != "rc" {
+			t.Fatalf("expected run command to be deleted, got %v", f.deleted)
+		}
+	})
+
+	t.Run("output blobs", func(t *testing.T) {
+		f := &fakeRunCommands{states: []armcompute.ExecutionState{armcompute.ExecutionStateSucceeded}}
+		blobs := &outputBlobs{stdout: "https://s.blob.core.windows.net/c/rc.stdout?sig=a", stderr: "https://s.blob.core.windows.net/c/rc.stderr?sig=a"}
+		if _, err := runManagedCommand(context.Background(), f, "rc", "uptime", blobs, 60, time.Millisecond); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		p := f.created.Properties
+		if to.String(p.OutputBlobURI) != blobs.stdout || to.String(p.ErrorBlobURI) != blobs.stderr {
+			t.Fatalf("unexpected output blobs %v %v", p.OutputBlobURI, p.ErrorBlobURI)
+		}
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		f := &fakeRunCommands{createErr: errors.New("OperationNotAllowed")}
+		_, err := runManagedCommand(context.Background(), f, "rc", "uptime", nil, 60, time.Millisecond)
+		if !errors.Is(err, ErrManagedRunCommandUnavailable) {
+			t.Fatalf("expected ErrManagedRunCommandUnavailable, got %v", err)
+		}
+		if f.gets != 0 {
+			t.Fatalf("expected no polling, got %d", f.gets)
+		}
+	})
+
+	t.Run("creation failed after being accepted", func(t *testing.T) {
+		f := &fakeRunCommands{waitErr: errors.New("connection reset by peer")}
+		_, err := runManagedCommand(context.Background(), f, "rc", "uptime", nil, 60, time.Millisecond)
+		if err == nil || errors.Is(err, ErrManagedRunCommandUnavailable) {
+			t.Fatalf("expected error not allowing a fallback, got %v", err)
+		}
+		if len(f.deleted) != 1 {
+			t.Fatalf("expected run command to be deleted, got %v", f.deleted)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		f := &fakeRunCommands{states: []armcompute.ExecutionState{armcompute.ExecutionStateRunning}}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := runManagedCommand(ctx, f, "rc", "sleep 600", nil, 60, time.Millisecond)
+		if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrManagedRunCommandUnavailable) {
+			t.Fatalf("expected cancellation error, got %v", err)
+		}
+		if len(f.deleted) != 1 {
+			t.Fatalf("expected run command to be deleted, got %v", f.deleted)
+		}
+	})
+}
+
+func TestManagedRunCommandResult(t *testing.T) {
+	view := func(state armcompute.ExecutionState, exitCode *int32) *armcompute.VirtualMachineRunCommandInstanceView {
+		return &armcompute.VirtualMachineRunCommandInstanceView{
+			ExecutionState:   &state,
+			ExitCode:         exitCode,
+			Output:           to.StringPtr("line\r\n"),
+			Error:            to.StringPtr("warning\n"),
+			ExecutionMessage: to.StringPtr("extension failed"),
+		}
+	}
+
+	for _, tc := range []struct {
+		description string
+		view        *armcompute.VirtualMachineRunCommandInstanceView
+		expectedErr bool
+	}{
+		{"succeeded", view(armcompute.ExecutionStateSucceeded, to.Int32Ptr(0)), false},
+		{"non-zero exit code", view(armcompute.ExecutionStateFailed, to.Int32Ptr(1)), false},
+		{"failed without exit code", view(armcompute.ExecutionStateFailed, nil), true},
+		{"timed out", view(armcompute.ExecutionStateTimedOut, nil), true},
+		{"canceled", view(armcompute.ExecutionStateCanceled, nil), true},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			res, err := managedRunCommandResult(tc.view, 60)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if res.Stdout != "line\n" || res.Stderr != "warning\n" {
+				t.Fatalf("unexpected result: %+v", res)
+			}
+			if res.ExitCode == nil || int32(*res.ExitCode) != *tc.view.ExitCode {
+				t.Fatalf("unexpected exit code: %v", res.ExitCode)
+			}
+		})
+	}
+}
+
+func TestParseRunCommandBackend(t *testing.T) {
+	for _, s := range []string{"action", "managed"} {
+		if b, err := ParseRunCommandBackend(s); err != nil || string(b) != s {
+			t.Fatalf("expected %q, got %q, %v", s, b, err)
+		}
+	}
+	if _, err := ParseRunCommandBackend("v2"); err == nil {
+		t.Fatal("expected error for unsupported backend")
+	}
+}
+
+func TestNewOutputBlobs(t *testing.T) {
+	blobs, err := newOutputBlobs("https://mystorage.blob.core.windows.net/runcommand/?sv=2022-11-02&sp=racwd&sig=abc%3D", "kubectl-aks-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := "https://mystorage.blob.core.windows.net/runcommand/kubectl-aks-1.stdout?sv=2022-11-02&sp=racwd&sig=abc%3D"; blobs.stdout != expected {
+		t.Fatalf("stdout blob is %q, want %q", blobs.stdout, expected)
+	}
+	if expected := "https://mystorage.blob.core.windows.net/runcommand/kubectl-aks-1.stderr?sv=2022-11-02&sp=racwd&sig=abc%3D"; blobs.stderr != expected {
+		t.Fatalf("stderr blob is %q, want %q", blobs.stderr, expected)
+	}
+
+	for _, containerURL := range []string{
+		"http://mystorage.blob.core.windows.net/runcommand?sv=2022-11-02&sig=abc",
+		"https://mystorage.blob.core.windows.net/?sv=2022-11-02&sig=abc",
+		"https://mystorage.blob.core.windows.net/runcommand",
+	} {
+		if _, err := newOutputBlobs(containerURL, "kubectl-aks-1"); err == nil {
+			t.Fatalf("expected an error for %q", containerURL)
+		}
+	}
+}
+
+func TestOutputBlobs(t *testing.T) {
+	stored := map[string]string{"/c/rc.stdout": "line\r\n" + strings.Repeat("x", 2*BytesLimit)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != "abc" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		content, ok := stored[r.URL.Path]
+		switch {
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodDelete:
+			delete(stored, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			io.WriteString(w, content)
+		}
+	}))
+	defer srv.Close()
+
+	blobs := &outputBlobs{stdout: srv.URL + "/c/rc.stdout?sig=abc", stderr: srv.URL + "/c/rc.stderr?sig=abc"}
+	stdout, stderr, err := blobs.read(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if stdout != "line\n"+strings.Repeat("x", 2*BytesLimit) || stderr != "" {
+		t.Fatalf("unexpected output of %d bytes, %q", len(stdout), stderr)
+	}
+	if err := blobs.delete(context.Background()); err != nil || len(stored) != 0 {
+		t.Fatalf("expected blobs to be deleted, got %v, %v", err, stored)
+	}
+
+	denied := &outputBlobs{stdout: srv.URL + "/c/rc.stdout?sig=wrong", stderr: srv.URL + "/c/rc.stderr?sig=wrong"}
+	if _, _, err := denied.read(context.Background()); err == nil || strings.Contains(err.Error(), "sig=") {
+		t.Fatalf("expected an error without the SAS token, got %v", err)
+	}
+}
@@ -8,19 +8,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
-	"os/signal"
 	"regexp"
+	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/Azure/kubectl-aks/cmd/utils/config"
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
 	"github.com/kinvolk/inspektor-gadget/pkg/k8sutil"
 	log "github.com/sirupsen/logrus"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -36,23 +36,46 @@ const (
 	DefaultRunCommandTimeoutInSeconds = 300
 )
 
-type VirtualMachineScaleSetVM struct {
+// VirtualMachine is the Azure VM of a node: either a VMSS instance or a
+// standalone VM, as used by VirtualMachines node pools and availability sets.
+type VirtualMachine struct {
 	SubscriptionID    string
 	NodeResourceGroup string
-	VMScaleSet        string
-	InstanceID        string
+	// VMScaleSet and InstanceID identify a VMSS instance.
+	VMScaleSet string
+	InstanceID string
+	// Name identifies a standalone VM.
+	Name string
+	// OS is the operating system of the VM. Empty means unknown.
+	OS pkgruntime.OS
+}
+
+// IsScaleSetVM returns whether the VM is a VMSS instance.
+func (vm *VirtualMachine) IsScaleSetVM() bool {
+	return vm.Name == ""
+}
+
+// ResourceID returns the Azure resource ID of the VM.
+func (vm *VirtualMachine) ResourceID() string {
+	if vm.IsScaleSetVM() {
+		return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachineScaleSets/%s/virtualMachines/%s",
+			vm.SubscriptionID, vm.NodeResourceGroup, vm.VMScaleSet, vm.InstanceID)
+	}
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+		vm.SubscriptionID, vm.NodeResourceGroup, vm.Name)
 }
 
 type RunCommandResult struct {
 	Stdout string
 	Stderr string
+	// ExitCode is nil when unknown, e.g. on Windows nodes with the action.
+	ExitCode *int
 }
 
-// ParseVMSSResourceID extracts elements from a given VMSS resource ID with format:
+// ParseResourceID extracts elements from a given VMSS instance or VM resource ID with format:
 // /subscriptions/mySubID/resourceGroups/myRG/providers/myProvider/virtualMachineScaleSets/myVMSS/virtualMachines/myInsID
-func ParseVMSSResourceID(id string, vm *VirtualMachineScaleSetVM) error {
-	const expectedItems int = 5
-
+// /subscriptions/mySubID/resourceGroups/myRG/providers/myProvider/virtualMachines/myVM
+func ParseResourceID(id string, vm *VirtualMachine) error {
 	// This allows us to make resource ID (--id) option not case sentitive
 	id = strings.ToLower(id)
 
@@ -63,8 +86,17 @@ func ParseVMSSResourceID(id string, vm *VirtualMachineScaleSetVM) error {
 	// to read but prevent conversion. Therefore, read it and don't use it.
 	var provider string
 
-	n, err := fmt.Sscanf(idWithSpaces, "subscriptions %s resourcegroups %s providers %s virtualmachinescalesets %s virtualmachines %s",
-		&vm.SubscriptionID, &vm.NodeResourceGroup, &provider, &vm.VMScaleSet, &vm.InstanceID)
+	var n, expectedItems int
+	var err error
+	if strings.Contains(id, "/virtualmachines/") && !strings.Contains(id, "/virtualmachinescalesets/") {
+		expectedItems = 4
+		n, err = fmt.Sscanf(idWithSpaces, "subscriptions %s resourcegroups %s providers %s virtualmachines %s",
+			&vm.SubscriptionID, &vm.NodeResourceGroup, &provider, &vm.Name)
+	} else {
+		expectedItems = 5
+		n, err = fmt.Sscanf(idWithSpaces, "subscriptions %s resourcegroups %s providers %s virtualmachinescalesets %s virtualmachines %s",
+			&vm.SubscriptionID, &vm.NodeResourceGroup, &provider, &vm.VMScaleSet, &vm.InstanceID)
+	}
 	if err != nil {
 		return fmt.Errorf("error parsing provider ID %q: %w", id, err)
 	}
@@ -76,35 +108,50 @@ func ParseVMSSResourceID(id string, vm *VirtualMachineScaleSetVM) error {
 	return nil
 }
 
-// VirtualMachineScaleSetVMFromConfig returns a VirtualMachineScaleSetVM object
+// VirtualMachineFromNodeConfig returns the VM stored in a node config, either
+// as its resource ID or as separate settings.
+func VirtualMachineFromNodeConfig(nc *config.Config) (*VirtualMachine, error) {
+	var vm VirtualMachine
+	if id := nc.GetString(ResourceIDKey); id != "" {
+		if err := ParseResourceID(id, &vm); err != nil {
+			return nil, fmt.Errorf("parsing Azure resource ID %s: %w", id, err)
+		}
+	} else {
+		vm.SubscriptionID = nc.GetString(SubscriptionIDKey)
+		vm.NodeResourceGroup = nc.GetString(NodeResourceGroupKey)
+		vm.VMScaleSet = nc.GetString(VMSSKey)
+		vm.InstanceID = nc.GetString(VMSSInstanceIDKey)
+		vm.Name = nc.GetString(VMNameKey)
+	}
+	vm.OS = pkgruntime.OS(nc.GetString(OSKey))
+	return &vm, nil
+}
+
+// VirtualMachineFromConfig returns a VirtualMachine object
 // it assumes that the config is set and valid
-func VirtualMachineScaleSetVMFromConfig() (*VirtualMachineScaleSetVM, error) {
-	var vm VirtualMachineScaleSetVM
+func VirtualMachineFromConfig() (*VirtualMachine, error) {
+	var vm VirtualMachine
 	if node != "" {
 		// Before trying to get the resource ID from the API server, verify if
-		// the VMSS information of that node is already in the config file.
+		// the VM information of that node is already in the config file.
 		config := config.New()
-		if cc, ok := config.GetNodeConfig(node); ok {
-			log.Debugf("Using VMSS information from config for node %s", node)
-
-			vm.SubscriptionID = cc.GetString(SubscriptionIDKey)
-			vm.NodeResourceGroup = cc.GetString(NodeResourceGroupKey)
-			vm.VMScaleSet = cc.GetString(VMSSKey)
-			vm.InstanceID = cc.GetString(VMSSInstanceIDKey)
-			return &vm, nil
+		if cc, ok := config.GetNodeConfig(node); ok && (cc.IsSet(VMSSKey) || cc.IsSet(VMNameKey) || cc.IsSet(ResourceIDKey)) {
+			log.Debugf("Using VM information from config for node %s", node)
+			return VirtualMachineFromNodeConfig(cc)
 		}
 
-		var err error
-		resourceID, err = GetNodeResourceID(context.TODO(), node)
+		n, err := getNode(context.TODO(), node)
 		if err != nil {
 			return nil, fmt.Errorf("retrieving Azure resource ID of node %s from API server: %w",
 				node, err)
 		}
-		if err = ParseVMSSResourceID(resourceID, &vm); err != nil {
+		resourceID = nodeResourceID(n)
+		if err = ParseResourceID(resourceID, &vm); err != nil {
 			return nil, fmt.Errorf("parsing Azure resource ID %s: %w", resourceID, err)
 		}
+		vm.OS = nodeOS(n)
 	} else if resourceID != "" {
-		if err := ParseVMSSResourceID(resourceID, &vm); err != nil {
+		if err := ParseResourceID(resourceID, &vm); err != nil {
 			return nil, fmt.Errorf("parsing Azure resource ID %s: %w", resourceID, err)
 		}
 	} else {
@@ -117,7 +164,7 @@ func VirtualMachineScaleSetVMFromConfig() (*VirtualMachineScaleSetVM, error) {
 	return &vm, nil
 }
 
-func VirtualMachineScaleSetVMsViaKubeconfig() (map[string]*VirtualMachineScaleSetVM, error) {
+func VirtualMachinesViaKubeconfig() (map[string]*VirtualMachine, error) {
 	clientset, err := k8sutil.NewClientsetFromConfigFlags(KubernetesConfigFlags)
 	if err != nil {
 		return nil, fmt.Errorf("creating Kubernetes client: %w", err)
@@ -128,23 +175,24 @@ func VirtualMachineScaleSetVMsViaKubeconfig() (map[string]*VirtualMachineScaleSe
 		return nil, fmt.Errorf("listing nodes: %w", err)
 	}
 
-	vmssVMs := make(map[string]*VirtualMachineScaleSetVM)
+	vms := make(map[string]*VirtualMachine)
 	if len(nodes.Items) > 0 {
 		for _, n := range nodes.Items {
-			var vm VirtualMachineScaleSetVM
+			var vm VirtualMachine
 			if !strings.HasPrefix(n.Spec.ProviderID, "azure://") {
-				return nil, fmt.Errorf("node=%q doesn't seem to be an Azure VMSS VM", n.Name)
+				return nil, fmt.Errorf("node=%q doesn't seem to be an Azure VM", n.Name)
 			}
-			if err = ParseVMSSResourceID(strings.TrimPrefix(n.Spec.ProviderID, "azure://"), &vm); err != nil {
+			if err = ParseResourceID(strings.TrimPrefix(n.Spec.ProviderID, "azure://"), &vm); err != nil {
 				return nil, fmt.Errorf("parsing Azure resource ID %q: %w", n.Spec.ProviderID, err)
 			}
-			vmssVMs[n.Name] = &vm
+			vm.OS = nodeOS(&n)
+			vms[n.Name] = &vm
 		}
 	}
-	return vmssVMs, nil
+	return vms, nil
 }
 
-func VirtualMachineScaleSetVMsViaAzureAPI(subID, rg, clusterName string) (map[string]*VirtualMachineScaleSetVM, error) {
+func VirtualMachinesViaAzureAPI(subID, rg, clusterName string) (map[string]*VirtualMachine, error) {
 	creds, err := GetCredentials()
 	if err != nil {
 		return nil, fmt.Errorf("getting credentials: %w", err)
@@ -177,7 +225,7 @@ func VirtualMachineScaleSetVMsViaAzureAPI(subID, rg, clusterName string) (map[st
 			nodePools = append(nodePools, to.String(np.Name))
 		}
 	}
-	vmssVMs := make(map[string]*VirtualMachineScaleSetVM)
+	vms := make(map[string]*VirtualMachine)
 	vmClient, err := armcompute.NewVirtualMachineScaleSetVMsClient(subID, creds, armOpts)
 	if err != nil {
 		return nil, fmt.Errorf("creating VMSS VMs client: %w", err)
@@ -188,16 +236,84 @@ func VirtualMachineScaleSetVMsViaAzureAPI(subID, rg, clusterName string) (map[st
 			return nil, fmt.Errorf("getting instances for node pool %q: %w", np, err)
 		}
 		for _, instance := range instances {
-			vmssVMs[instanceName(instance)] = &VirtualMachineScaleSetVM{
+			vms[instanceName(instance)] = &VirtualMachine{
 				SubscriptionID:    subID,
 				VMScaleSet:        np,
 				NodeResourceGroup: strings.ToLower(to.String(cluster.Properties.NodeResourceGroup)),
 				InstanceID:        to.String(instance.InstanceID),
+				OS:                instanceOS(instance),
 			}
 		}
 	}
 
-	return vmssVMs, nil
+	// VirtualMachines node pools and availability sets use standalone VMs,
+	// which are told apart from other VMs of the resource group by their tag.
+	standaloneClient, err := armcompute.NewVirtualMachinesClient(subID, creds, armOpts)
+	if err != nil {
+		return nil, fmt.Errorf("creating VMs client: %w", err)
+	}
+	standaloneVMs, err := standaloneVMsInResourceGroup(ctx, standaloneClient, to.String(cluster.Properties.NodeResourceGroup))
+	if err != nil {
+		return nil, fmt.Errorf("getting VMs: %w", err)
+	}
+	for _, v := range standaloneVMs {
+		if !isNodePoolVM(v) {
+			log.Debugf("Skipping VM %s, which isn't in a node pool", to.String(v.Name))
+			continue
+		}
+		vms[standaloneVMName(v)] = &VirtualMachine{
+			SubscriptionID:    subID,
+			NodeResourceGroup: strings.ToLower(to.String(cluster.Properties.NodeResourceGroup)),
+			Name:              to.String(v.Name),
+			OS:                standaloneVMOS(v),
+		}
+	}
+
+	return vms, nil
+}
+
+func standaloneVMsInResourceGroup(ctx context.Context, client *armcompute.VirtualMachinesClient, resourceGroup string) ([]*armcompute.VirtualMachine, error) {
+	var vms []*armcompute.VirtualMachine
+	pager := client.NewListPager(resourceGroup, nil)
+	for pager.More() {
+		nextPage, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		vms = append(vms, nextPage.Value...)
+	}
+	return vms, nil
+}
+
+// poolNameTags are the tags AKS sets on the VMs of a node pool, the second
+// one on older availability set clusters.
+var poolNameTags = []string{"aks-managed-poolName", "poolName"}
+
+// isNodePoolVM returns whether a standalone VM belongs to a node pool, as
+// opposed to e.g. jump boxes or build agents in the node resource group.
+func isNodePoolVM(vm *armcompute.VirtualMachine) bool {
+	for _, tag := range poolNameTags {
+		if to.String(vm.Tags[tag]) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// standaloneVMName returns the name of the VM formatted as Kubernetes node name.
+func standaloneVMName(vm *armcompute.VirtualMachine) string {
+	if vm.Properties == nil || vm.Properties.OSProfile == nil || vm.Properties.OSProfile.ComputerName == nil {
+		return strings.ToLower(to.String(vm.Name))
+	}
+	return strings.ToLower(to.String(vm.Properties.OSProfile.ComputerName))
+}
+
+// standaloneVMOS returns the operating system of a VM, or "" if unknown.
+func standaloneVMOS(vm *armcompute.VirtualMachine) pkgruntime.OS {
+	if vm.Properties == nil {
+		return ""
+	}
+	return osFromProfile(vm.Properties.OSProfile)
 }
 
 func instancesForNodePool(ctx context.Context, vmClient *armcompute.VirtualMachineScaleSetVMsClient, pool, resourceGroup string) ([]*armcompute.VirtualMachineScaleSetVM, error) {
@@ -221,10 +337,87 @@ func instanceName(vm *armcompute.VirtualMachineScaleSetVM) string {
 	return strings.ToLower(to.String(vm.Properties.OSProfile.ComputerName))
 }
 
+// instanceOS returns the operating system of a VMSS VM, or "" if unknown.
+func instanceOS(vm *armcompute.VirtualMachineScaleSetVM) pkgruntime.OS {
+	if vm.Properties == nil {
+		return ""
+	}
+	return osFromProfile(vm.Properties.OSProfile)
+}
+
+// osFromProfile returns the operating system configured in the OS profile of
+// a VM, or "" if unknown.
+func osFromProfile(p *armcompute.OSProfile) pkgruntime.OS {
+	switch {
+	case p == nil:
+		return ""
+	case p.WindowsConfiguration != nil:
+		return pkgruntime.OSWindows
+	default:
+		return pkgruntime.OSLinux
+	}
+}
+
+// wrapCommand returns the script that runs command on a node with the given
+// OS, stopping it after timeout seconds. When the output is truncated at the
+// tail, only its first BytesLimit bytes are returned.
+func wrapCommand(command string, timeout int, outputTruncate OutputTruncate, nodeOS pkgruntime.OS) string {
+	if nodeOS == pkgruntime.OSWindows {
+		// The job runs in a separate process, so it can be stopped.
+		output := "Receive-Job $job"
+		if outputTruncate == OutputTruncateTail {
+			output = fmt.Sprintf(`$out = Receive-Job $job | Out-String -Width 4096
+if ($out.Length -gt %[1]d) { $out = $out.Substring(0, %[1]d) }
+$out`, BytesLimit)
+		}
+		return fmt.Sprintf(`$job = Start-Job -ScriptBlock {
+%[1]s
+}
+if (Wait-Job $job -Timeout %[2]d) {
+%[3]s
+} else {
+Stop-Job $job
+Write-Error "command timed out after %[2]d seconds"
+}`, command, timeout, output)
+	}
+
+	// The action doesn't report the exit code, so it's written at the end of
+	// stderr, which keeps its end when truncated.
+	run := fmt.Sprintf("timeout %d sh -c %s", timeout, pkgruntime.Quote(command))
+	if outputTruncate == OutputTruncateTail {
+		// The exit code of a pipeline is the one of its last command, so the
+		// one of the command is passed through fd 4. The rest of the output
+		// is read so the command doesn't get SIGPIPE.
+		run = fmt.Sprintf(`exec 3>&1
+rc=$( { { %s 3>&- 4>&-; echo $? >&4; } | { head -c %d >&3; cat >/dev/null; }; } 4>&1 )`, run, BytesLimit)
+	} else {
+		run += "\nrc=$?"
+	}
+	return fmt.Sprintf("%s\necho \"%s$rc\" >&2\nexit $rc", run, exitCodeMarker)
+}
+
+// exitCodeMarker starts the line of stderr with the exit code of the
+// command, see wrapCommand.
+const exitCodeMarker = "__kubectl_aks_exit_code="
+
+// extractExitCode removes the exit code written by wrapCommand from stderr
+// and returns it, or nil if it isn't there, e.g. on Windows nodes.
+func extractExitCode(stderr string) (string, *int) {
+	i := strings.LastIndex(stderr, exitCodeMarker)
+	if i < 0 || (i > 0 && stderr[i-1] != '\n') {
+		return stderr, nil
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(stderr[i+len(exitCodeMarker):]))
+	if err != nil {
+		return stderr, nil
+	}
+	return stderr[:i], &code
+}
+
 func RunCommand(
 	ctx context.Context,
 	cred azcore.TokenCredential,
-	vm *VirtualMachineScaleSetVM,
+	vm *VirtualMachine,
 	command *string,
 	timeout *int,
 	outputTruncate OutputTruncate,
@@ -232,11 +425,7 @@ func RunCommand(
 	*RunCommandResult,
 	error,
 ) {
-	const (
-		commandID    = "RunShellScript"
-		initialDelay = 15 * time.Second
-		pollingFreq  = 2 * time.Second
-	)
+	const initialDelay = 15 * time.Second
 
 	if timeout == nil {
 		timeout = to.IntPtr(DefaultRunCommandTimeoutInSeconds)
@@ -245,79 +434,217 @@ func RunCommand(
 	cloudCfg := GetCloudConfiguration()
 	armOpts := ARMClientOptions(cloudCfg)
 
-	client, err := armcompute.NewVirtualMachineScaleSetVMsClient(vm.SubscriptionID, cred, armOpts)
-	if err != nil {
-		return nil, fmt.Errorf("creating VMSS VMs client: %w", err)
+	commandID := "RunShellScript"
+	if vm.OS == pkgruntime.OSWindows {
+		commandID = "RunPowerShellScript"
 	}
 
 	// By default, the Azure API limits the output to the last 4,096 bytes. See
 	// https://learn.microsoft.com/en-us/azure/virtual-machines/linux/run-command#restrictions.
-	if outputTruncate == OutputTruncateTail {
-		*command = fmt.Sprintf("%s | head -c %d", *command, BytesLimit)
-	}
-
-	script := []*string{to.StringPtr(fmt.Sprintf("timeout %d sh -c '%s'", *timeout, *command))}
+	script := []*string{to.StringPtr(wrapCommand(*command, *timeout, outputTruncate, vm.OS))}
 	runCommand := armcompute.RunCommandInput{
 		CommandID: to.StringPtr(commandID),
 		Script:    script,
 	}
 
 	b, _ := json.MarshalIndent(vm, "", "  ")
-	log.Debugf("Command: %s\nVirtual Machine Scale Set VM:\n%s\n\n", *command, string(b))
-	s := make(chan os.Signal, 1)
-	signal.Notify(s, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-s
-		log.Warn("The requested command hasn't finished yet, hit 'Ctrl+C' again to exit anyway.")
-		log.Warn("However, please notice the command will continue running in the node anyway, " +
-			"and you will be unable to see the output or run another command until it finishes.")
-		<-s
-		os.Exit(1)
-	}()
-
+	log.Debugf("Command: %s\nVirtual Machine:\n%s\n\n", *command, string(b))
 	DefaultSpinner.Start()
 	DefaultSpinner.Suffix = " Running..."
 
-	poller, err := client.BeginRunCommand(ctx, vm.NodeResourceGroup,
-		vm.VMScaleSet, vm.InstanceID, runCommand, nil)
+	res, err := runCommandOnVM(ctx, cred, armOpts, vm, runCommand)
 	if err != nil {
 		DefaultSpinner.Stop()
-		return nil, fmt.Errorf("begin running command: %w", err)
+		if ctx.Err() != nil {
+			log.Warn("The command will continue running in the node anyway, " +
+				"and you will be unable to run another command until it finishes.")
+			return nil, fmt.Errorf("command cancelled: %w", ctx.Err())
+		}
+		return nil, err
+	}
+
+	b, _ = json.MarshalIndent(res, "", "  ")
+	log.Debugf("\nResponse:\n%s\n", string(b))
+	var result *RunCommandResult
+	if vm.OS == pkgruntime.OSWindows {
+		result, err = parseWindowsRunCommandValues(res.Value)
+		if err != nil {
+			DefaultSpinner.Stop()
+			return nil, err
+		}
+	} else {
+		// TODO: Is it possible to have multiple values after using PollUntilDone()?
+		if len(res.Value) == 0 || res.Value[0] == nil {
+			DefaultSpinner.Stop()
+			return nil, errors.New("no response received after command execution")
+		}
+		val := res.Value[0]
+
+		// TODO: Isn't there a constant in the SDK to compare this?
+		if to.String(val.Code) != "ProvisioningState/succeeded" {
+			DefaultSpinner.Stop()
+			b, _ := json.MarshalIndent(res, "", "  ")
+			return nil, fmt.Errorf("command execution didn't succeed:\n%s", string(b))
+		}
+
+		result, err = parseRunCommandMessage(to.String(val.Message))
+		if err != nil {
+			DefaultSpinner.Stop()
+			return nil, err
+		}
+	}
+	if outputTruncate == OutputTruncateTail && result.isTruncated() {
+		result.Stdout = fmt.Sprintf("%s... (truncated)\n", result.Stdout)
+	}
+	result.Stderr, result.ExitCode = extractExitCode(result.Stderr)
+
+	DefaultSpinner.Stop()
+	return result, nil
+}
+
+// runCommandOnVM runs a command on a VMSS instance or a standalone VM and
+// waits for its result.
+func runCommandOnVM(ctx context.Context, cred azcore.TokenCredential, armOpts *arm.ClientOptions,
+	vm *VirtualMachine, input armcompute.RunCommandInput,
+) (*armcompute.RunCommandResult, error) {
+	const pollingFreq = 2 * time.Second
+
+	if !vm.IsScaleSetVM() {
+		client, err := armcompute.NewVirtualMachinesClient(vm.SubscriptionID, cred, armOpts)
+		if err != nil {
+			return nil, fmt.Errorf("creating VMs client: %w", err)
+		}
+		poller, err := client.BeginRunCommand(ctx, vm.NodeResourceGroup, vm.Name, input, nil)
+		if err != nil {
+			return nil, pkgruntime.NotStarted(fmt.Errorf("begin running command: %w", err))
+		}
+		res, err := poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: pollingFreq})
+		if err != nil {
+			return nil, fmt.Errorf("polling command response: %w", err)
+		}
+		return &res.RunCommandResult, nil
 	}
 
+	client, err := armcompute.NewVirtualMachineScaleSetVMsClient(vm.SubscriptionID, cred, armOpts)
+	if err != nil {
+		return nil, fmt.Errorf("creating VMSS VMs client: %w", err)
+	}
+	poller, err := client.BeginRunCommand(ctx, vm.NodeResourceGroup,
+		vm.VMScaleSet, vm.InstanceID, input, nil)
+	if err != nil {
+		return nil, pkgruntime.NotStarted(fmt.Errorf("begin running command: %w", err))
+	}
 	res, err := poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: pollingFreq})
 	if err != nil {
-		DefaultSpinner.Stop()
 		return nil, fmt.Errorf("polling command response: %w", err)
 	}
+	return &res.RunCommandResult, nil
+}
 
-	b, _ = json.MarshalIndent(res, "", "  ")
-	log.Debugf("\nResponse:\n%s\n", string(b))
-	// TODO: Is it possible to have multiple values after using PollUntilDone()?
-	if len(res.Value) == 0 || res.Value[0] == nil {
-		DefaultSpinner.Stop()
-		return nil, errors.New("no response received after command execution")
-	}
-	val := res.Value[0]
+// InstanceStatus is the state of a VM that matters to RunCommand, as
+// reported by its instance view.
+type InstanceStatus struct {
+	// PowerState is e.g. "running" or "deallocated".
+	PowerState string
+	// AgentStatus is the status of the VM agent, which runs the RunCommand
+	// extension, e.g. "Ready" or "Not Ready", or "" if it isn't reported.
+	AgentStatus string
+}
 
-	// TODO: Isn't there a constant in the SDK to compare this?
-	if to.String(val.Code) != "ProvisioningState/succeeded" {
-		DefaultSpinner.Stop()
-		b, _ := json.MarshalIndent(res, "", "  ")
-		return nil, fmt.Errorf("command execution didn't succeed:\n%s", string(b))
+// GetInstanceStatus returns the power state and VM agent status of a VMSS
+// instance or a standalone VM.
+func GetInstanceStatus(ctx context.Context, cred azcore.TokenCredential, vm *VirtualMachine) (*InstanceStatus, error) {
+	armOpts := ARMClientOptions(GetCloudConfiguration())
+
+	if vm.IsScaleSetVM() {
+		client, err := armcompute.NewVirtualMachineScaleSetVMsClient(vm.SubscriptionID, cred, armOpts)
+		if err != nil {
+			return nil, fmt.Errorf("creating VMSS VMs client: %w", err)
+		}
+		res, err := client.GetInstanceView(ctx, vm.NodeResourceGroup, vm.VMScaleSet, vm.InstanceID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("getting instance view: %w", err)
+		}
+		return instanceStatus(res.Statuses, res.VMAgent)
 	}
 
-	result, err := parseRunCommandMessage(to.String(val.Message))
+	client, err := armcompute.NewVirtualMachinesClient(vm.SubscriptionID, cred, armOpts)
 	if err != nil {
-		DefaultSpinner.Stop()
-		return nil, err
+		return nil, fmt.Errorf("creating VMs client: %w", err)
 	}
-	if outputTruncate == OutputTruncateTail && result.isTruncated() {
-		result.Stdout = fmt.Sprintf("%s... (truncated)\n", result.Stdout)
+	res, err := client.InstanceView(ctx, vm.NodeResourceGroup, vm.Name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting instance view: %w", err)
 	}
+	return instanceStatus(res.Statuses, res.VMAgent)
+}
 
-	DefaultSpinner.Stop()
-	return result, nil
+// GetVMOS returns the OS of a VMSS instance or a standalone VM from the OS
+// type of its OS disk.
+func GetVMOS(ctx context.Context, cred azcore.TokenCredential, vm *VirtualMachine) (pkgruntime.OS, error) {
+	armOpts := ARMClientOptions(GetCloudConfiguration())
+
+	var profile *armcompute.StorageProfile
+	if vm.IsScaleSetVM() {
+		client, err := armcompute.NewVirtualMachineScaleSetVMsClient(vm.SubscriptionID, cred, armOpts)
+		if err != nil {
+			return "", fmt.Errorf("creating VMSS VMs client: %w", err)
+		}
+		res, err := client.Get(ctx, vm.NodeResourceGroup, vm.VMScaleSet, vm.InstanceID, nil)
+		if err != nil {
+			return "", fmt.Errorf("getting VMSS instance: %w", err)
+		}
+		if res.Properties != nil {
+			profile = res.Properties.StorageProfile
+		}
+	} else {
+		client, err := armcompute.NewVirtualMachinesClient(vm.SubscriptionID, cred, armOpts)
+		if err != nil {
+			return "", fmt.Errorf("creating VMs client: %w", err)
+		}
+		res, err := client.Get(ctx, vm.NodeResourceGroup, vm.Name, nil)
+		if err != nil {
+			return "", fmt.Errorf("getting VM: %w", err)
+		}
+		if res.Properties != nil {
+			profile = res.Properties.StorageProfile
+		}
+	}
+	return storageProfileOS(profile)
+}
+
+func storageProfileOS(profile *armcompute.StorageProfile) (pkgruntime.OS, error) {
+	if profile == nil || profile.OSDisk == nil || profile.OSDisk.OSType == nil {
+		return "", errors.New("no OS type in the VM storage profile")
+	}
+	switch *profile.OSDisk.OSType {
+	case armcompute.OperatingSystemTypesLinux:
+		return pkgruntime.OSLinux, nil
+	case armcompute.OperatingSystemTypesWindows:
+		return pkgruntime.OSWindows, nil
+	default:
+		return "", fmt.Errorf("unsupported OS type %q", *profile.OSDisk.OSType)
+	}
+}
+
+func instanceStatus(statuses []*armcompute.InstanceViewStatus, agent *armcompute.VirtualMachineAgentInstanceView) (*InstanceStatus, error) {
+	var status InstanceStatus
+	for _, s := range statuses {
+		if code := to.String(s.Code); strings.HasPrefix(code, "PowerState/") {
+			status.PowerState = strings.TrimPrefix(code, "PowerState/")
+		}
+	}
+	if status.PowerState == "" {
+		return nil, errors.New("no power state in instance view")
+	}
+	if agent != nil {
+		for _, s := range agent.Statuses {
+			if s != nil && s.DisplayStatus != nil {
+				status.AgentStatus = *s.DisplayStatus
+			}
+		}
+	}
+	return &status, nil
 }
 
 func parseRunCommandMessage(msg string) (*RunCommandResult, error) {
@@ -336,6 +663,31 @@ func parseRunCommandMessage(msg string) (*RunCommandResult, error) {
 	}, nil
 }
 
+// parseWindowsRunCommandValues extracts stdout and stderr from the response
+// of RunPowerShellScript, which reports each of them as a separate value with
+// codes "ComponentStatus/StdOut/succeeded" and "ComponentStatus/StdErr/succeeded".
+func parseWindowsRunCommandValues(values []*armcompute.InstanceViewStatus) (*RunCommandResult, error) {
+	var result RunCommandResult
+	var found bool
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		switch code := to.String(v.Code); {
+		case strings.HasPrefix(code, "ComponentStatus/StdOut/"):
+			result.Stdout = strings.ReplaceAll(to.String(v.Message), "\r\n", "\n")
+			found = true
+		case strings.HasPrefix(code, "ComponentStatus/StdErr/"):
+			result.Stderr = strings.ReplaceAll(to.String(v.Message), "\r\n", "\n")
+		}
+	}
+	if !found {
+		b, _ := json.MarshalIndent(values, "", "  ")
+		return nil, fmt.Errorf("command execution didn't succeed:\n%s", string(b))
+	}
+	return &result, nil
+}
+
 func (r *RunCommandResult) isTruncated() bool {
 	return len(r.Stdout)+len(r.Stderr) >= BytesLimit
 }
@@ -4,14 +4,31 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+	"github.com/Azure/kubectl-aks/pkg/runtime/auto"
 )
 
-func TestParseVMSSResourceID(t *testing.T) {
+func TestParseResourceID(t *testing.T) {
 	type test struct {
 		description    string
 		id             string
-		expectedResult VirtualMachineScaleSetVM
+		expectedResult VirtualMachine
 		expectedError  bool
 	}
 
@@ -20,25 +37,25 @@ func TestParseVMSSResourceID(t *testing.T) {
 		{
 			description:    "From empty id",
 			id:             "",
-			expectedResult: VirtualMachineScaleSetVM{},
+			expectedResult: VirtualMachine{},
 			expectedError:  true,
 		},
 		{
 			description:    "Unexpected format",
 			id:             "subscriptionsmysubid",
-			expectedResult: VirtualMachineScaleSetVM{},
+			expectedResult: VirtualMachine{},
 			expectedError:  true,
 		},
 		{
 			description:    "Unexpected separator",
 			id:             "subscriptions-mysubid",
-			expectedResult: VirtualMachineScaleSetVM{},
+			expectedResult: VirtualMachine{},
 			expectedError:  true,
 		},
 		{
 			description: "Incomplete format",
 			id:          "/subscriptions/mysubid",
-			expectedResult: VirtualMachineScaleSetVM{
+			expectedResult: VirtualMachine{
 				SubscriptionID: "mysubid",
 			},
 			expectedError: true,
@@ -47,7 +64,7 @@ func TestParseVMSSResourceID(t *testing.T) {
 		{
 			description: "Correct format",
 			id:          "/subscriptions/mysubid/resourcegroups/myrd/providers/myprovider/virtualmachinescalesets/myvmss/virtualmachines/myinsid",
-			expectedResult: VirtualMachineScaleSetVM{
+			expectedResult: VirtualMachine{
 				SubscriptionID:    "mysubid",
 				NodeResourceGroup: "myrd",
 				VMScaleSet:        "myvmss",
@@ -55,11 +72,21 @@ func TestParseVMSSResourceID(t *testing.T) {
 			},
 			expectedError: false,
 		},
+		{
+			description: "Correct VM format",
+			id:          "/subscriptions/mysubid/resourceGroups/myrd/providers/Microsoft.Compute/virtualMachines/aks-vmpool-12345678-0",
+			expectedResult: VirtualMachine{
+				SubscriptionID:    "mysubid",
+				NodeResourceGroup: "myrd",
+				Name:              "aks-vmpool-12345678-0",
+			},
+			expectedError: false,
+		},
 	}
 
 	for _, entry := range table {
-		result := VirtualMachineScaleSetVM{}
-		err := ParseVMSSResourceID(entry.id, &result)
+		result := VirtualMachine{}
+		err := ParseResourceID(entry.id, &result)
 		errorOcurred := err != nil
 		if errorOcurred != entry.expectedError || entry.expectedResult != result {
 			t.Fatalf("Failed test %q: result %+v (error %t - %s) vs expected %+v (error %t)",
@@ -67,3 +94,238 @@ func TestParseVMSSResourceID(t *testing.T) {
 		}
 	}
 }
+
+func TestVirtualMachineResourceID(t *testing.T) {
+	for _, id := range []string{
+		"/subscriptions/mysubid/resourceGroups/myrd/providers/Microsoft.Compute/virtualMachineScaleSets/myvmss/virtualMachines/3",
+		"/subscriptions/mysubid/resourceGroups/myrd/providers/Microsoft.Compute/virtualMachines/myvm",
+	} {
+		var vm VirtualMachine
+		if err := ParseResourceID(id, &vm); err != nil {
+			t.Fatalf("ParseResourceID(%q) = %v, want nil", id, err)
+		}
+		if !strings.EqualFold(vm.ResourceID(), id) {
+			t.Fatalf("ResourceID() = %q, want %q", vm.ResourceID(), id)
+		}
+	}
+}
+
+func TestWrapCommand(t *testing.T) {
+	type test struct {
+		description    string
+		os             pkgruntime.OS
+		outputTruncate OutputTruncate
+		expected       []string
+	}
+
+	table := []test{
+		{
+			description:    "Linux truncating the tail",
+			os:             pkgruntime.OSLinux,
+			outputTruncate: OutputTruncateTail,
+			expected:       []string{"{ timeout 30 sh -c 'hostname' 3>&- 4>&-; echo $? >&4; } | { head -c 4096 >&3;", "exit $rc"},
+		},
+		{
+			description:    "Linux truncating the head",
+			os:             "",
+			outputTruncate: OutputTruncateHead,
+			expected:       []string{"timeout 30 sh -c 'hostname'\nrc=$?\necho \"__kubectl_aks_exit_code=$rc\" >&2\nexit $rc"},
+		},
+		{
+			description:    "Windows truncating the tail",
+			os:             pkgruntime.OSWindows,
+			outputTruncate: OutputTruncateTail,
+			expected:       []string{"Start-Job -ScriptBlock {\nhostname\n}", "Wait-Job $job -Timeout 30", "Substring(0, 4096)"},
+		},
+	}
+
+	for _, entry := range table {
+		script := wrapCommand("hostname", 30, entry.outputTruncate, entry.os)
+		for _, e := range entry.expected {
+			if !strings.Contains(script, e) {
+				t.Fatalf("Failed test %q: script %q doesn't contain %q", entry.description, script, e)
+			}
+		}
+	}
+
+	// Single quotes in the command don't end the script.
+	script := wrapCommand("echo 'it works'", 30, OutputTruncateHead, pkgruntime.OSLinux)
+	if expected := `timeout 30 sh -c 'echo '\''it works'\'''`; !strings.HasPrefix(script, expected) {
+		t.Fatalf("Failed test quoting: script %q doesn't start with %q", script, expected)
+	}
+}
+
+func TestWrapCommandExitCode(t *testing.T) {
+	if _, err := exec.LookPath("timeout"); err != nil {
+		t.Skip("timeout isn't installed")
+	}
+	for _, tc := range []struct {
+		description    string
+		command        string
+		outputTruncate OutputTruncate
+		stdout         string
+		exitCode       int
+	}{
+		{"succeeded", "echo ok", OutputTruncateHead, "ok\n", 0},
+		{"failed", "echo ok; exit 3", OutputTruncateHead, "ok\n", 3},
+		{"failed truncating the tail", "echo ok; exit 3", OutputTruncateTail, "ok\n", 3},
+		{"truncated output", "yes | head -c 10000", OutputTruncateTail, strings.Repeat("y\n", 2048), 0},
+		{"timed out", "sleep 5", OutputTruncateTail, "", 124},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			cmd := exec.Command("sh", "-c", wrapCommand(tc.command, 1, tc.outputTruncate, pkgruntime.OSLinux))
+			cmd.Stdout, cmd.Stderr = &stdout, &stderr
+			err := cmd.Run()
+
+			var exitErr *exec.ExitError
+			if tc.exitCode == 0 && err != nil || tc.exitCode != 0 && (!errors.As(err, &exitErr) || exitErr.ExitCode() != tc.exitCode) {
+				t.Fatalf("expected exit code %d, got %v", tc.exitCode, err)
+			}
+			if stdout.String() != tc.stdout {
+				t.Fatalf("unexpected stdout %q", stdout.String())
+			}
+			rest, exitCode := extractExitCode(stderr.String())
+			if exitCode == nil || *exitCode != tc.exitCode || rest != "" {
+				t.Fatalf("unexpected stderr %q", stderr.String())
+			}
+		})
+	}
+}
+
+func TestExtractExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		stderr, rest string
+		exitCode     *int
+	}{
+		{"warning\n__kubectl_aks_exit_code=1\n", "warning\n", to.IntPtr(1)},
+		{"__kubectl_aks_exit_code=0", "", to.IntPtr(0)},
+		{"warning\n", "warning\n", nil},
+		{"echo __kubectl_aks_exit_code=1\n", "echo __kubectl_aks_exit_code=1\n", nil},
+	} {
+		rest, exitCode := extractExitCode(tc.stderr)
+		if rest != tc.rest || (exitCode == nil) != (tc.exitCode == nil) || exitCode != nil && *exitCode != *tc.exitCode {
+			t.Fatalf("extractExitCode(%q) = %q, %v", tc.stderr, rest, exitCode)
+		}
+	}
+}
+
+func TestIsNodePoolVM(t *testing.T) {
+	node := &armcompute.VirtualMachine{Name: to.StringPtr("aks-vmpool-12345678-vms1"), Tags: map[string]*string{
+		"aks-managed-poolName": to.StringPtr("vmpool"),
+	}}
+	if !isNodePoolVM(node) {
+		t.Fatalf("isNodePoolVM(%s) = false, want true", *node.Name)
+	}
+	jumpbox := &armcompute.VirtualMachine{Name: to.StringPtr("jumpbox"), Tags: map[string]*string{"owner": to.StringPtr("ops")}}
+	if isNodePoolVM(jumpbox) {
+		t.Fatalf("isNodePoolVM(%s) = true, want false", *jumpbox.Name)
+	}
+}
+
+func TestParseWindowsRunCommandValues(t *testing.T) {
+	res, err := parseWindowsRunCommandValues([]*armcompute.InstanceViewStatus{
+		{Code: to.StringPtr("ComponentStatus/StdOut/succeeded"), Message: to.StringPtr("kubelet:active\r\ncontainerd:active\r\n")},
+		{Code: to.StringPtr("ComponentStatus/StdErr/succeeded"), Message: to.StringPtr("")},
+	})
+	if err != nil {
+		t.Fatalf("parseWindowsRunCommandValues() = %v, want nil", err)
+	}
+	if res.Stdout != "kubelet:active\ncontainerd:active\n" {
+		t.Fatalf("unexpected stdout %q", res.Stdout)
+	}
+
+	if _, err := parseWindowsRunCommandValues([]*armcompute.InstanceViewStatus{
+		{Code: to.StringPtr("ProvisioningState/failed"), Message: to.StringPtr("timeout")},
+	}); err == nil {
+		t.Fatalf("parseWindowsRunCommandValues() = nil, want error")
+	}
+}
+
+func TestInstanceStatus(t *testing.T) {
+	statuses := []*armcompute.InstanceViewStatus{
+		{Code: to.StringPtr("ProvisioningState/succeeded")},
+		{Code: to.StringPtr("PowerState/running")},
+	}
+	agent := &armcompute.VirtualMachineAgentInstanceView{Statuses: []*armcompute.InstanceViewStatus{
+		{Code: to.StringPtr("ProvisioningState/succeeded"), DisplayStatus: to.StringPtr("Ready")},
+	}}
+	status, err := instanceStatus(statuses, agent)
+	if err != nil {
+		t.Fatalf("instanceStatus() = %v, want nil", err)
+	}
+	if status.PowerState != "running" || status.AgentStatus != "Ready" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	if status, err := instanceStatus(statuses, nil); err != nil || status.AgentStatus != "" {
+		t.Fatalf("instanceStatus() = %+v, %v, want no agent status", status, err)
+	}
+	if _, err := instanceStatus(statuses[:1], agent); err == nil {
+		t.Fatalf("instanceStatus() = nil, want error without power state")
+	}
+}
+
+// fakeCredential is a credential returning a static token.
+type fakeCredential struct{}
+
+func (fakeCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// statusTransport answers every request with an ARM error of status code.
+type statusTransport struct {
+	code int
+}
+
+func (t *statusTransport) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: t.code,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"error": {"code": "Conflict", "message": "Run command extension execution is in progress."}}`)),
+		Request:    req,
+	}, nil
+}
+
+func TestRunCommandOnVMRejected(t *testing.T) {
+	armOpts := ARMClientOptions(cloud.AzurePublic)
+	armOpts.Transport = &statusTransport{code: http.StatusConflict}
+	armOpts.Retry.MaxRetries = -1
+
+	for _, tc := range []struct {
+		description string
+		vm          *VirtualMachine
+	}{
+		{"standalone VM", &VirtualMachine{SubscriptionID: "mySubID", NodeResourceGroup: "myRG", Name: "aks-vmpool-12345678-vms1"}},
+		{"VMSS instance", &VirtualMachine{SubscriptionID: "mySubID", NodeResourceGroup: "myRG", VMScaleSet: "aks-agentpool-12345678-vmss", InstanceID: "0"}},
+	} {
+		_, err := runCommandOnVM(context.Background(), fakeCredential{}, armOpts, tc.vm, armcompute.RunCommandInput{
+			CommandID: to.StringPtr("RunShellScript"),
+		})
+		if !errors.Is(err, pkgruntime.ErrNotStarted) || !auto.IsRetryable(err) {
+			t.Fatalf("Failed test %q: error %v can't fall back to another runtime", tc.description, err)
+		}
+	}
+}
+
+func TestStorageProfileOS(t *testing.T) {
+	osType := func(t armcompute.OperatingSystemTypes) *armcompute.StorageProfile {
+		return &armcompute.StorageProfile{OSDisk: &armcompute.OSDisk{OSType: &t}}
+	}
+	for _, tc := range []struct {
+		description string
+		profile     *armcompute.StorageProfile
+		expected    pkgruntime.OS
+		expectedErr bool
+	}{
+		{"Linux", osType(armcompute.OperatingSystemTypesLinux), pkgruntime.OSLinux, false},
+		{"Windows", osType(armcompute.OperatingSystemTypesWindows), pkgruntime.OSWindows, false},
+		{"no OS disk", &armcompute.StorageProfile{}, "", true},
+		{"no storage profile", nil, "", true},
+	} {
+		os, err := storageProfileOS(tc.profile)
+		if os != tc.expected || (err != nil) != tc.expectedErr {
+			t.Fatalf("Failed test %q: got %q, %v", tc.description, os, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"fmt"
+	"strings"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newAzurePlatform())
+}
+
+// imdsFields are the IMDS compute metadata fields queried on the node. They
+// are fetched one by one in text format because the full JSON document
+// doesn't fit in the RunCommand output limit.
+var imdsFields = []string{"name", "vmScaleSetName", "resourceGroupName", "subscriptionId", "location", "vmId"}
+
+type azurePlatform struct {
+	// lookup returns the VM stored in the config for a node.
+	lookup func(nodeName string) (*NodeVM, bool)
+}
+
+func newAzurePlatform() *azurePlatform {
+	return &azurePlatform{
+		lookup: func(string) (*NodeVM, bool) { return nil, false },
+	}
+}
+
+func (c *azurePlatform) SetEnvironment(env *Environment) {
+	if env.NodeVM != nil {
+		c.lookup = env.NodeVM
+	}
+}
+
+func (c *azurePlatform) Name() string { return "azure-platform" }
+func (c *azurePlatform) Description() string {
+	return "Check that the node can reach Azure IMDS and the wireserver, and that IMDS matches the stored node config"
+}
+func (c *azurePlatform) Mode() Mode { return ModeVerify }
+
+func (c *azurePlatform) Command() string {
+	var b strings.Builder
+	b.WriteString(`imds=http://169.254.169.254/metadata/instance/compute
+echo "imds_status:$(curl -s -m 5 -o /dev/null -w '%{http_code}' -H Metadata:true "$imds?api-version=2021-02-01")"
+`)
+	for _, f := range imdsFields {
+		fmt.Fprintf(&b, "echo \"imds_%s:$(curl -s -m 5 -H Metadata:true \"$imds/%s?api-version=2021-02-01&format=text\")\"\n", f, f)
+	}
+	b.WriteString(`versions=$(curl -s -m 5 -w '\n%{http_code}' "http://168.63.129.16/?comp=versions")
+echo "wireserver_status:$(echo "$versions" | tail -1)"
+echo "$versions" | grep -q "<Versions>" && echo "wireserver_versions:ok"
+nslookup -timeout=3 mcr.microsoft.com 168.63.129.16 >/dev/null 2>&1 && echo "azure_dns:ok" || echo "azure_dns:fail"`)
+	return b.String()
+}
+
+func (c *azurePlatform) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	return c.ParseNode("", res)
+}
+
+func (c *azurePlatform) ParseNode(nodeName string, res *pkgruntime.RunResult) (*Result, error) {
+	values := parseKeyValues(res.Stdout)
+	if _, ok := values["imds_status"]; !ok {
+		return nil, fmt.Errorf("couldn't parse azure-platform output: %q", res.Stdout)
+	}
+
+	var issues []string
+	var details []string
+
+	// IMDS
+	if values["imds_status"] != "200" {
+		issues = append(issues, fmt.Sprintf("IMDS (169.254.169.254) unreachable (HTTP status %q)", values["imds_status"]))
+	} else {
+		details = append(details, fmt.Sprintf("IMDS: ok (vm %s, scale set %q, resource group %s, location %s)",
+			values["imds_name"], values["imds_vmScaleSetName"], values["imds_resourceGroupName"], values["imds_location"]))
+		if values["imds_vmId"] == "" || values["imds_subscriptionId"] == "" {
+			issues = append(issues, "IMDS returned incomplete compute metadata")
+		}
+	}
+
+	// Wireserver
+	switch {
+	case values["wireserver_status"] != "200":
+		issues = append(issues, fmt.Sprintf("wireserver (168.63.129.16) unreachable (HTTP status %q)", values["wireserver_status"]))
+	case values["wireserver_versions"] != "ok":
+		issues = append(issues, "wireserver (168.63.129.16) returned an unexpected response")
+	default:
+		details = append(details, "wireserver: ok")
+	}
+	if values["azure_dns"] == "ok" {
+		details = append(details, "Azure DNS (168.63.129.16:53): ok")
+	} else {
+		issues = append(issues, "Azure DNS (168.63.129.16:53) not resolving")
+	}
+
+	// Stored config
+	if values["imds_status"] == "200" {
+		if vm, ok := c.lookup(nodeName); ok {
+			if mismatches := compareIMDS(values, vm); len(mismatches) > 0 {
+				issues = append(issues, "stored config is stale ("+strings.Join(mismatches, ", ")+"), re-run 'kubectl aks config import'")
+			} else {
+				details = append(details, "stored config: matches IMDS")
+			}
+		} else {
+			details = append(details, "stored config: no VM information for this node")
+		}
+	}
+
+	if len(issues) > 0 {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("Azure platform: %s", strings.Join(issues, "; ")),
+			Details: strings.Join(details, "\n"),
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: "Azure platform: IMDS and wireserver reachable",
+		Details: strings.Join(details, "\n"),
+	}, nil
+}
+
+// compareIMDS returns the differences between the VM reported by IMDS and the
+// one stored in the config. IMDS names VMSS instances
+// "<scale set>_<instance ID>".
+func compareIMDS(values map[string]string, vm *NodeVM) []string {
+	var mismatches []string
+	compare := func(what, stored, actual string) {
+		if !strings.EqualFold(stored, actual) {
+			mismatches = append(mismatches, fmt.Sprintf("%s %q in config, %q on node", what, stored, actual))
+		}
+	}
+	compare("subscription", vm.SubscriptionID, values["imds_subscriptionId"])
+	compare("node resource group", vm.NodeResourceGroup, values["imds_resourceGroupName"])
+	compare("scale set", vm.VMScaleSet, values["imds_vmScaleSetName"])
+	if vm.Name != "" {
+		compare("VM name", vm.Name, values["imds_name"])
+		return mismatches
+	}
+	instanceID := ""
+	if i := strings.LastIndex(values["imds_name"], "_"); i >= 0 {
+		instanceID = values["imds_name"][i+1:]
+	}
+	compare("instance ID", vm.InstanceID, instanceID)
+	return mismatches
+}
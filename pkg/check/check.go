@@ -4,6 +4,9 @@
 package check
 
 import (
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/spf13/pflag"
+
 	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
 )
 
@@ -22,6 +25,10 @@ type Result struct {
 	Success bool
 	Message string // human-readable one-line summary
 	Details string // optional verbose output
+	// Facts are optional settings observed on the node. When a check runs on
+	// several nodes, the nodes whose facts differ from the majority of their
+	// node pool are reported as failed.
+	Facts map[string]string
 }
 
 // Check is the interface every check must implement.
@@ -34,10 +41,60 @@ type Check interface {
 	Description() string
 	// Mode returns whether this is a verify (point-in-time) or trace (duration) check.
 	Mode() Mode
-	// Command returns the shell command(s) to execute on the node.
+	// Command returns the shell command(s) to execute on Linux nodes.
 	// For trace checks, the framework substitutes {{.Duration}} with the
 	// user-specified --duration value in seconds.
 	Command() string
 	// Parse interprets the runtime result and returns a check Result.
 	Parse(res *pkgruntime.RunResult) (*Result, error)
 }
+
+// Configurable is an optional interface for checks that expose additional
+// flags (e.g. thresholds) on their subcommand. The framework calls AddFlags
+// once when the subcommand is created.
+type Configurable interface {
+	AddFlags(fs *pflag.FlagSet)
+}
+
+// NodeAware is an optional interface for checks whose interpretation depends
+// on the target node, e.g. to compare what the node reports with the stored
+// config. When implemented, the framework calls ParseNode instead of Parse.
+type NodeAware interface {
+	ParseNode(nodeName string, res *pkgruntime.RunResult) (*Result, error)
+}
+
+// OSAware is an optional interface for checks that also support nodes running
+// another OS, e.g. Windows. CommandForOS returns the command to execute on
+// such nodes, printing the same output as Command so that Parse applies to
+// both, or "" if the OS isn't supported.
+type OSAware interface {
+	CommandForOS(os pkgruntime.OS) string
+}
+
+// Environment holds what checks need to know about the target cluster that
+// can't be read from the node. It's provided by the caller, e.g. from the
+// CLI configuration.
+type Environment struct {
+	// Cloud is the Azure cloud of the cluster.
+	Cloud cloud.Configuration
+	// NodeVM returns the VM stored in the config for a node, if any.
+	NodeVM func(nodeName string) (*NodeVM, bool)
+	// SysctlBaseline contains the sysctl.conf lines stored for the cluster.
+	SysctlBaseline []string
+}
+
+// NodeVM is the Azure VM of a node as stored in the config: a VMSS instance,
+// or a standalone VM if Name is set.
+type NodeVM struct {
+	SubscriptionID    string
+	NodeResourceGroup string
+	VMScaleSet        string
+	InstanceID        string
+	Name              string
+}
+
+// EnvironmentAware is an optional interface for checks depending on the
+// Environment. The framework calls SetEnvironment before running the check.
+type EnvironmentAware interface {
+	SetEnvironment(env *Environment)
+}
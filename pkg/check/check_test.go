@@ -4,8 +4,16 @@
 package check
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -100,6 +108,13 @@ func TestDiskPressureParse(t *testing.T) {
 		assert.False(t, res.Success)
 		assert.Contains(t, res.Message, "inode")
 	})
+
+	t.Run("windows", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: "disk:42\n"})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Equal(t, "Disk OK: root 42% used", res.Message)
+	})
 }
 
 func TestProcessHealthParse(t *testing.T) {
@@ -117,6 +132,13 @@ func TestProcessHealthParse(t *testing.T) {
 		assert.False(t, res.Success)
 		assert.Contains(t, res.Message, "kubelet")
 	})
+
+	t.Run("windows service stopped", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: "kubelet:active\ncontainerd:stopped\n"})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "containerd (stopped)")
+	})
 }
 
 func TestDNSTraceParse(t *testing.T) {
@@ -211,6 +233,897 @@ func TestTCPRetransTraceParse(t *testing.T) {
 	})
 }
 
+func TestConntrackParse(t *testing.T) {
+	c := newConntrack()
+
+	sample := func(n, count, insertFailed, topSockets string) string {
+		return "sample:" + n + "\nconntrack_count:" + count + "\nconntrack_max:1000\n" +
+			"port_min:32768\nport_max:60999\ntcp_sockets:300\ntimewait:120\n" +
+			"top_destination:20.1.2.3:443\ntop_destination_sockets:" + topSockets + "\n" +
+			"insert_failed:" + insertFailed + "\ndrop:0\nearly_drop:0\n"
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: sample("1", "100", "0", "50") + sample("2", "110", "0", "50")})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Contains(t, res.Message, "table 11% used")
+		assert.Contains(t, res.Details, "conntrack_count +10")
+		assert.Contains(t, res.Details, "time-wait: 120")
+	})
+
+	t.Run("table exhausted", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: sample("1", "950", "0", "50")})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "conntrack table 95% used")
+	})
+
+	t.Run("ports exhausted", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: sample("1", "100", "0", "25000")})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "ephemeral ports to 20.1.2.3:443 88% used")
+	})
+
+	t.Run("insert_failed growing", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: sample("1", "100", "3", "50") + sample("2", "100", "10", "50")})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "insert_failed +7")
+	})
+
+	t.Run("empty output", func(t *testing.T) {
+		_, err := c.Parse(&pkgruntime.RunResult{Stdout: ""})
+		assert.Error(t, err)
+	})
+}
+
+func TestResourcePressureParse(t *testing.T) {
+	c := newResourcePressure()
+
+	const meminfo = "memtotal:8388608\nmemavailable:4194304\n" // 8Gi total, 4Gi available
+	const psiIdle = "psi_cpu_some:avg10=1.00 avg60=0.50\npsi_memory_some:avg10=0.00 avg60=0.00\n" +
+		"psi_memory_full:avg10=0.00 avg60=0.00\npsi_io_some:avg10=0.10 avg60=0.20\n"
+
+	t.Run("healthy", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: psiIdle + meminfo +
+				`eviction_hard_config:{"memory.available":"750Mi","nodefs.available":"10%"}` + "\n" +
+				"pod_memory:1073741824 kube-system_coredns-abc_1234-5678\npod_memory:52428800 0a1b-2c3d\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Contains(t, res.Message, "3346Mi above eviction threshold")
+		assert.Contains(t, res.Details, "750Mi (kubelet config)")
+		assert.Contains(t, res.Details, "kube-system/coredns-abc")
+		assert.Contains(t, res.Details, "0a1b-2c3d")
+		assert.Contains(t, res.Details, "cpu some: avg10=1.00")
+	})
+
+	t.Run("flag overrides config", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: meminfo + `eviction_hard_config:{"memory.available":"750Mi"}` + "\n" +
+				"eviction_hard_flag:memory.available<50%,nodefs.available<10%\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "below kubelet eviction threshold")
+		assert.Contains(t, res.Details, "50% (--eviction-hard)")
+	})
+
+	t.Run("close to eviction", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "memtotal:8388608\nmemavailable:409600\n", // 400Mi available
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "only 300Mi above kubelet eviction threshold")
+		assert.Contains(t, res.Details, "100Mi (kubelet default)")
+		assert.Contains(t, res.Details, "PSI: not available")
+	})
+
+	t.Run("memory pressure", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "psi_memory_some:avg10=42.50 avg60=30.00\n" + meminfo,
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "memory pressure 42.5%")
+	})
+
+	t.Run("missing meminfo", func(t *testing.T) {
+		_, err := c.Parse(&pkgruntime.RunResult{Stdout: psiIdle})
+		assert.Error(t, err)
+	})
+}
+
+func TestContainerRuntimeParse(t *testing.T) {
+	c := newContainerRuntime()
+
+	const ready = `runtime_conditions:[ { "type": "RuntimeReady", "status": true, "reason": "", "message": "" }, { "type": "NetworkReady", "status": true, "reason": "", "message": "" } ]`
+
+	t.Run("healthy", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: ready + "\ncontainers_total:12\ncontainers_running:12\nimages:20\nimagefs_mount:/var/lib/containerd\nimagefs_usage:40\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Contains(t, res.Message, "12/12 containers running")
+		assert.Contains(t, res.Details, "RuntimeReady=true, NetworkReady=true")
+	})
+
+	t.Run("network not ready", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: `runtime_conditions:[ { "type": "RuntimeReady", "status": true }, { "type": "NetworkReady", "status": false, "reason": "NetworkPluginNotReady", "message": "cni plugin not initialized" } ]` +
+				"\ncontainers_total:3\ncontainers_running:1\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "not ready: NetworkReady (NetworkPluginNotReady cni plugin not initialized)")
+	})
+
+	t.Run("stuck and restarting containers", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: ready + "\ncontainer:CONTAINER_RUNNING 7 kube-system/coredns-abc coredns\n" +
+				"container:CONTAINER_CREATED 0 default/web-1 app\ncontainers_total:3\ncontainers_running:2\n" +
+				"imagefs_mount:/var/lib/containerd\nimagefs_usage:91\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "1 container(s) stuck in Created/Unknown")
+		assert.Contains(t, res.Message, "1 container(s) restarted 5+ times")
+		assert.Contains(t, res.Message, "image filesystem 91% used")
+		assert.Contains(t, res.Details, "default/web-1")
+		assert.Contains(t, res.Details, "kube-system/coredns-abc")
+	})
+
+	t.Run("crictl missing", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: "crictl:missing\n"})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "crictl not found")
+	})
+}
+
+func TestCrictlPsAwkProgram(t *testing.T) {
+	awk, err := exec.LookPath("awk")
+	if err != nil {
+		t.Skip("awk not available")
+	}
+	// coredns runs its attempt 5 and crictl also lists its exited attempt 4.
+	out, err := exec.Command(awk, "-F", `"`, "-v", "restarts=4", crictlPsAwkProgram,
+		filepath.Join("testdata", "crictl", "ps.json")).Output()
+	require.NoError(t, err)
+
+	var containers []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if v, ok := strings.CutPrefix(line, "container:"); ok {
+			containers = append(containers, v)
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"CONTAINER_RUNNING 5 kube-system/coredns-789789675-abcde coredns",
+		"CONTAINER_CREATED 0 default/web-1 app",
+	}, containers)
+	assert.Contains(t, string(out), "containers_total:3\ncontainers_running:2\n")
+}
+
+func TestEgressEndpoints(t *testing.T) {
+	hosts := func(cfg cloud.Configuration) []string {
+		var out []string
+		for _, ep := range egressEndpoints(cfg) {
+			out = append(out, ep.Host)
+		}
+		return out
+	}
+
+	public := hosts(cloud.AzurePublic)
+	assert.Contains(t, public, "mcr.microsoft.com")
+	assert.Contains(t, public, "${region}.data.mcr.microsoft.com")
+	assert.Contains(t, public, "login.microsoftonline.com")
+	assert.Contains(t, public, "management.azure.com")
+	assert.Contains(t, public, "packages.aks.azure.com")
+	assert.Contains(t, public, "ntp.ubuntu.com")
+
+	gov := hosts(cloud.AzureGovernment)
+	assert.Contains(t, gov, "login.microsoftonline.us")
+	assert.Contains(t, gov, "management.usgovcloudapi.net")
+
+	china := hosts(cloud.AzureChina)
+	assert.Contains(t, china, "mcr.azure.cn")
+	assert.Contains(t, china, "login.chinacloudapi.cn")
+	assert.NotContains(t, china, "packages.aks.azure.com")
+
+	custom := hosts(cloud.Configuration{
+		ActiveDirectoryAuthorityHost: "https://login.microsoftonline.com/",
+		Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {Endpoint: "https://management.custom.example/"},
+		},
+	})
+	assert.Contains(t, custom, "management.custom.example")
+}
+
+func TestEgressParse(t *testing.T) {
+	c := newEgress()
+
+	const ok = "endpoint:mcr|mcr.microsoft.com|443/tcp|0|0.004512|0.021337|C=US; O=Microsoft Corporation; CN=Microsoft Azure RSA TLS Issuing CA 07\n" +
+		"endpoint:apiserver|myaks-dns-12345.hcp.eastus.azmk8s.io|443/tcp|0|0.002|0.010|CN=ca\n" +
+		"endpoint:ntp|ntp.ubuntu.com|123/udp|0|||\n"
+
+	t.Run("all reachable", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: ok})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Contains(t, res.Message, "all 3 required endpoints reachable")
+		assert.Contains(t, res.Details, "4ms")
+		assert.Contains(t, res.Details, "21ms")
+	})
+
+	t.Run("timeout and blocked", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: ok + "endpoint:login|login.microsoftonline.com|443/tcp|28|0.000000|0.000000|\n" +
+				"endpoint:packages-aks|packages.aks.azure.com|443/tcp|7|0.000000|0.000000|\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "2/5")
+		assert.Contains(t, res.Details, "timeout — Microsoft Entra authentication")
+		assert.Contains(t, res.Details, "connection refused or blocked")
+	})
+
+	t.Run("proxy interception", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "endpoint:mcr|mcr.microsoft.com|443/tcp|0|0.004|0.020|CN=Contoso Inspection CA\n" +
+				"endpoint:arm|management.azure.com|443/tcp|60|0.004|0.000|CN=Contoso Inspection CA\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Details, `unexpected issuer "CN=Contoso Inspection CA"`)
+		assert.Contains(t, res.Details, "certificate verification failed")
+	})
+
+	t.Run("empty output", func(t *testing.T) {
+		_, err := c.Parse(&pkgruntime.RunResult{Stdout: ""})
+		assert.Error(t, err)
+	})
+}
+
+func TestAzurePlatformParse(t *testing.T) {
+	c := newAzurePlatform()
+	c.SetEnvironment(&Environment{NodeVM: func(nodeName string) (*NodeVM, bool) {
+		switch nodeName {
+		case "aks-nodepool1-12345678-vmss000003":
+			return &NodeVM{
+				SubscriptionID:    "mysubid",
+				NodeResourceGroup: "mc_myrg_myaks_eastus",
+				VMScaleSet:        "aks-nodepool1-12345678-vmss",
+				InstanceID:        "3",
+			}, true
+		case "aks-vmpool-12345678-0":
+			return &NodeVM{
+				SubscriptionID:    "mysubid",
+				NodeResourceGroup: "mc_myrg_myaks_eastus",
+				Name:              "aks-vmpool-12345678-0",
+			}, true
+		}
+		return nil, false
+	}})
+
+	const healthy = "imds_status:200\nimds_name:aks-nodepool1-12345678-vmss_3\n" +
+		"imds_vmScaleSetName:aks-nodepool1-12345678-vmss\nimds_resourceGroupName:MC_myRG_myAKS_eastus\n" +
+		"imds_subscriptionId:mySubID\nimds_location:eastus\nimds_vmId:0b1c2d3e\n" +
+		"wireserver_status:200\nwireserver_versions:ok\nazure_dns:ok\n"
+
+	t.Run("healthy and config matches", func(t *testing.T) {
+		res, err := c.ParseNode("aks-nodepool1-12345678-vmss000003", &pkgruntime.RunResult{Stdout: healthy})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Contains(t, res.Details, "stored config: matches IMDS")
+	})
+
+	t.Run("node not in config", func(t *testing.T) {
+		res, err := c.ParseNode("other-node", &pkgruntime.RunResult{Stdout: healthy})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Contains(t, res.Details, "no VM information")
+	})
+
+	t.Run("standalone VM", func(t *testing.T) {
+		vm := "imds_status:200\nimds_name:aks-vmpool-12345678-0\nimds_vmScaleSetName:\n" +
+			"imds_resourceGroupName:MC_myRG_myAKS_eastus\nimds_subscriptionId:mySubID\nimds_location:eastus\n" +
+			"imds_vmId:0b1c2d3e\nwireserver_status:200\nwireserver_versions:ok\nazure_dns:ok\n"
+		res, err := c.ParseNode("aks-vmpool-12345678-0", &pkgruntime.RunResult{Stdout: vm})
+		require.NoError(t, err)
+		assert.True(t, res.Success, res.Message)
+		assert.Contains(t, res.Details, "stored config: matches IMDS")
+
+		res, err = c.ParseNode("aks-vmpool-12345678-0", &pkgruntime.RunResult{
+			Stdout: strings.Replace(vm, "12345678-0", "12345678-1", 1),
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, `VM name "aks-vmpool-12345678-0" in config, "aks-vmpool-12345678-1" on node`)
+	})
+
+	t.Run("stale config", func(t *testing.T) {
+		stale := strings.Replace(healthy, "vmss_3", "vmss_7", 1)
+		res, err := c.ParseNode("aks-nodepool1-12345678-vmss000003", &pkgruntime.RunResult{Stdout: stale})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, `instance ID "3" in config, "7" on node`)
+	})
+
+	t.Run("imds and wireserver unreachable", func(t *testing.T) {
+		res, err := c.ParseNode("aks-nodepool1-12345678-vmss000003", &pkgruntime.RunResult{
+			Stdout: "imds_status:000\nwireserver_status:000\nazure_dns:fail\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "IMDS (169.254.169.254) unreachable")
+		assert.Contains(t, res.Message, "wireserver (168.63.129.16) unreachable")
+		assert.Contains(t, res.Message, "Azure DNS")
+	})
+
+	t.Run("empty output", func(t *testing.T) {
+		_, err := c.Parse(&pkgruntime.RunResult{Stdout: ""})
+		assert.Error(t, err)
+	})
+}
+
+func TestImageRegistry(t *testing.T) {
+	assert.Equal(t, "mcr.microsoft.com", imageRegistry("mcr.microsoft.com/oss/kubernetes/pause:3.6"))
+	assert.Equal(t, "myacr.azurecr.io", imageRegistry("myacr.azurecr.io/app@sha256:abc"))
+	assert.Equal(t, "localhost:5000", imageRegistry("localhost:5000/app"))
+	assert.Equal(t, "docker.io", imageRegistry("busybox:latest"))
+	assert.Equal(t, "docker.io", imageRegistry("library/busybox"))
+}
+
+func TestImagePullCommand(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	c := newImagePull()
+	c.image = "reg.io$(echo pwned)`echo pwned`\"/app:1"
+
+	// The image, registry and endpoint are assigned before anything runs.
+	lines := strings.SplitN(c.Command(), "\n", 4)
+	script := strings.Join(lines[:3], "\n") + "\nprintf '%s\\n' \"$image\" \"$registry\" \"$endpoint\""
+	out, err := exec.Command(sh, "-c", script).Output()
+	require.NoError(t, err)
+	assert.Equal(t, c.image+"\nreg.io$(echo pwned)`echo pwned`\"\nreg.io$(echo pwned)`echo pwned`\"\n", string(out))
+}
+
+func TestImagePullParse(t *testing.T) {
+	c := newImagePull()
+
+	t.Run("success", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "registry:mcr.microsoft.com\nregistry_probe:200|0.042\nclient:crictl pull\npull_rc:0\npull_ms:812\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Contains(t, res.Message, "812ms")
+		assert.Contains(t, res.Details, "time to first byte 42ms")
+	})
+
+	tests := []struct {
+		name  string
+		err   string
+		cause string
+	}{
+		{"throttling", "failed to pull: 429 Too Many Requests - Server message: toomanyrequests", "registry throttling"},
+		{"auth", "failed to authorize: failed to fetch anonymous token: 401 Unauthorized", "authentication failed"},
+		{"tls", "tls: failed to verify certificate: x509: certificate signed by unknown authority", "TLS error"},
+		{"dns", "dial tcp: lookup myacr.azurecr.io: no such host", "DNS resolution failed"},
+		{"timeout", "dial tcp 20.1.2.3:443: i/o timeout", "timeout"},
+		{"not found", "myacr.azurecr.io/app:v9: not found", "image not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := c.Parse(&pkgruntime.RunResult{
+				Stdout: "registry:myacr.azurecr.io\nmirror:https://mirror.example\nregistry_probe:000|0\npull_rc:1\npull_ms:3000\npull_error:" + tt.err + "\n",
+			})
+			require.NoError(t, err)
+			assert.False(t, res.Success)
+			assert.Contains(t, res.Message, tt.cause)
+			assert.Contains(t, res.Details, "mirror https://mirror.example")
+			assert.Contains(t, res.Details, "unreachable")
+		})
+	}
+
+	t.Run("empty output", func(t *testing.T) {
+		_, err := c.Parse(&pkgruntime.RunResult{Stdout: ""})
+		assert.Error(t, err)
+	})
+}
+
+func TestNetworkInterfaceCommand(t *testing.T) {
+	c := newNetworkInterface()
+	assert.NotContains(t, c.Command(), "probe peer")
+
+	c.peer = `10.1.0.4"; touch /tmp/pwned; echo "`
+	assert.Contains(t, c.Command(), `probe peer '10.1.0.4"; touch /tmp/pwned; echo "'`)
+	c.peer = "$(id)'"
+	assert.Contains(t, c.Command(), `probe peer '$(id)'\'''`)
+}
+
+func TestNetworkInterfaceParse(t *testing.T) {
+	c := newNetworkInterface()
+
+	t.Run("healthy", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "sample:1\niface_eth0:1500 up 0 0 12 0\niface_azv1234:1500 up 0 0 3 0\n" +
+				"ping:apiserver|myaks.hcp.eastus.azmk8s.io|eth0|1500|1500:ok 1450:ok 1400:ok 1280:ok\n" +
+				"sample:2\niface_eth0:1500 up 0 0 15 0\niface_azv1234:1500 up 0 0 3 0\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Contains(t, res.Message, "2 interface(s)")
+		assert.Contains(t, res.Details, "15 (+3)")
+		assert.Contains(t, res.Details, "path MTU to apiserver (myaks.hcp.eastus.azmk8s.io): 1500 via eth0 (MTU 1500)")
+	})
+
+	t.Run("rising errors", func(t *testing.T) {
+		// The pod interface is only printed once its counters are non-zero.
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "sample:1\niface_eth0:1500 up 4 0 0 0\n" +
+				"sample:2\niface_eth0:1500 up 9 0 0 0\niface_azv1234:1500 up 0 2 0 0\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "eth0 rx_errors rising")
+		assert.Contains(t, res.Message, "azv1234 tx_errors rising")
+	})
+
+	t.Run("path MTU lower than interface MTU", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "sample:1\niface_eth0:1500 up 0 0 0 0\n" +
+				"ping:peer|10.1.0.4|eth0|1500|1500:fail 1450:fail 1400:ok 1280:ok\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "path MTU to peer is 1400, lower than eth0 MTU 1500")
+		assert.Contains(t, res.Details, "failed sizes: 1500,1450")
+	})
+
+	t.Run("icmp blocked", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "sample:1\niface_eth0:1500 up 0 0 0 0\n" +
+				"ping:apiserver|myaks.hcp.eastus.azmk8s.io|eth0|1500|1500:fail 1450:fail 1400:fail 1280:fail\n" +
+				"ping:peer|nowhere.example|||unresolved\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Contains(t, res.Details, "unknown, no ICMP reply")
+		assert.Contains(t, res.Details, "path MTU to peer (nowhere.example): unresolved")
+	})
+
+	t.Run("empty output", func(t *testing.T) {
+		_, err := c.Parse(&pkgruntime.RunResult{Stdout: ""})
+		assert.Error(t, err)
+	})
+}
+
+func TestCNIConfigParse(t *testing.T) {
+	c := newCNIConfig()
+
+	const kubenet = `{ "cniVersion": "0.3.1", "name": "kubenet", "plugins": [{"type": "bridge", "ipam": {"type": "host-local", "ranges": [[{"subnet": "10.244.1.0/24"}]]}}, {"type": "portmap"}]}`
+	const azure = `{ "cniVersion": "0.3.0", "name": "azure", "plugins": [{"type": "azure-vnet", "mode": "transparent", "ipam": {"type": "azure-vnet-ipam"}}, {"type": "portmap"}]}`
+	const overlay = `{ "cniVersion": "0.3.0", "name": "azure", "plugins": [{"type": "azure-vnet", "ipam": {"type": "azure-cns", "mode": "v4overlay"}}]}`
+	const cilium = `{ "cniVersion": "0.3.1", "name": "cilium", "plugins": [{"type": "cilium-cni", "ipam": {"type": "azure-cns"}}]}`
+
+	t.Run("kubenet healthy", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "cni_files:10-containerd-net.conflist\ncni_conf:" + kubenet + "\n" +
+				"cni_bin:bridge host-local loopback portmap \nipam_hostlocal:kubenet 12\npod_sandboxes:15\nnode_pod_cidr:10.244.1.0/24\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, res.Success, res.Message)
+		assert.Equal(t, "CNI config OK: kubenet", res.Message)
+		assert.Contains(t, res.Details, "IP pool: 12/253 used (host-local 10.244.1.0/24)")
+	})
+
+	t.Run("kubenet drifted", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "cni_files:10-containerd-net.conflist 99-old.conf\ncni_conf:" + kubenet + "\n" +
+				"cni_bin:bridge loopback \nipam_hostlocal:kubenet 240\npod_sandboxes:20\nnode_pod_cidr:10.244.7.0/24\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "missing binaries in /opt/cni/bin: host-local, portmap")
+		assert.Contains(t, res.Message, "CNI subnet 10.244.1.0/24 doesn't match node podCIDR 10.244.7.0/24")
+		assert.Contains(t, res.Message, "IP pool exhaustion: 240/253")
+		assert.Contains(t, res.Message, "220 IP(s) allocated without a pod sandbox")
+		assert.Contains(t, res.Details, "ignored: 99-old.conf")
+	})
+
+	t.Run("azure cni pool exhausted", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "cni_files:10-azure.conflist\ncni_conf:" + azure + "\n" +
+				"cni_bin:azure-vnet azure-vnet-ipam loopback portmap \nipam_azure:30 30\npod_sandboxes:30\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "CNI config (azure-cni): IP pool exhaustion: 30/30 secondary IPs in use")
+	})
+
+	t.Run("overlay healthy", func(t *testing.T) {
+		// azure-cns is handled by azure-vnet, it has no binary.
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "cni_files:15-azure-swift-overlay.conflist\ncni_conf:" + overlay + "\n" +
+				"cni_bin:azure-vnet azure-vnet-telemetry loopback portmap \npod_sandboxes:12\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, res.Success, res.Message)
+		assert.Equal(t, "CNI config OK: azure-cni-overlay", res.Message)
+	})
+
+	t.Run("cilium binaries", func(t *testing.T) {
+		cfg, err := parseCNIConfig(cilium)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"loopback", "cilium-cni"}, requiredCNIBinaries(cfg))
+	})
+
+	t.Run("plugin detection", func(t *testing.T) {
+		for conf, plugin := range map[string]string{overlay: "azure-cni-overlay", cilium: "cilium", azure: "azure-cni", kubenet: "kubenet"} {
+			cfg, err := parseCNIConfig(conf)
+			require.NoError(t, err)
+			assert.Equal(t, plugin, detectCNIPlugin(cfg))
+		}
+		cfg, err := parseCNIConfig(`{"cniVersion": "0.3.1", "name": "single", "type": "bridge", "ipam": {"type": "host-local", "subnet": "10.0.0.0/24"}}`)
+		require.NoError(t, err)
+		assert.Equal(t, "kubenet", detectCNIPlugin(cfg))
+		assert.Equal(t, []string{"loopback", "bridge", "host-local"}, requiredCNIBinaries(cfg))
+		assert.Equal(t, []string{"10.0.0.0/24"}, hostLocalSubnets(cfg))
+	})
+
+	t.Run("missing config", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: "cni_files:\ncni_bin:\n"})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "no network configuration")
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: "cni_files:10-azure.conflist\ncni_conf:{\"name\": \n"})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "10-azure.conflist is invalid")
+	})
+
+	t.Run("empty output", func(t *testing.T) {
+		_, err := c.Parse(&pkgruntime.RunResult{Stdout: ""})
+		assert.Error(t, err)
+	})
+}
+
+func TestKernelHealthSince(t *testing.T) {
+	c := newKernelHealth()
+	fs := pflag.NewFlagSet("kernel-health", pflag.ContinueOnError)
+	c.AddFlags(fs)
+
+	for _, since := range []string{"1 hour ago", "-2h", "2024-05-01 10:00:00", "yesterday"} {
+		require.NoError(t, fs.Set("since", since))
+		assert.Contains(t, c.Command(), "--since '"+since+"' ")
+	}
+	for _, since := range []string{"$(reboot)", "`reboot`", `1 hour ago"; reboot; "`, "1h'"} {
+		assert.Error(t, fs.Set("since", since), since)
+	}
+	assert.Equal(t, "yesterday", c.since)
+
+	// Values set without the flag are quoted too.
+	c.since = "$(reboot)"
+	assert.Contains(t, c.Command(), "--since '$(reboot)' ")
+}
+
+func TestKernelHealthParse(t *testing.T) {
+	c := newKernelHealth()
+
+	t.Run("healthy", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "source:journal\ncount_mce:0\ncount_hung_task:0\ncount_soft_lockup:0\ncount_hard_lockup:0\n" +
+				"count_fs_error:0\ncount_io_error:0\ncount_nic_reset:0\ncount_segfault:0\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Equal(t, "Kernel healthy: no known problems logged since 24 hours ago", res.Message)
+	})
+
+	t.Run("critical errors", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "source:journal\n" +
+				"kmsg:io_error|2024-05-01T11:02:13+0000 node kernel: blk_update_request: I/O error, dev sdc, sector 2048\n" +
+				"kmsg:hung_task|2024-05-01T11:05:10+0000 node kernel: INFO: task containerd:1234 blocked for more than 241 seconds.\n" +
+				"kmsg:segfault|2024-05-01T12:03:05+0000 node kernel: python3[9911]: segfault at 0 ip 00007f3a2b1c0d2e\n" +
+				"count_mce:0\ncount_hung_task:2\ncount_io_error:5\ncount_segfault:1\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Equal(t, "Kernel errors since 24 hours ago: 2 hung_task, 5 io_error, 1 segfault", res.Message)
+		assert.Contains(t, res.Details, "[io_error] 2024-05-01T11:02:13+0000 node kernel: blk_update_request")
+		assert.Contains(t, res.Details, "block device I/O errors")
+	})
+
+	t.Run("only non-critical", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "source:dmesg\nkmsg:nic_reset|[  812.1] hv_netvsc 000d3a eth0: VF slot 1 removed\ncount_nic_reset:1\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Equal(t, "Kernel healthy: no critical errors in the kernel ring buffer (1 nic_reset)", res.Message)
+	})
+
+	t.Run("empty output", func(t *testing.T) {
+		_, err := c.Parse(&pkgruntime.RunResult{Stdout: ""})
+		assert.Error(t, err)
+	})
+}
+
+func TestSysctlComplianceParse(t *testing.T) {
+	const output = "fs.inotify.max_user_watches = 65536\nnet.core.somaxconn = 4096\nnet.ipv4.ip_local_port_range = 1024\t65000\n"
+
+	t.Run("matches stored baseline", func(t *testing.T) {
+		c := newSysctlCompliance()
+		c.SetEnvironment(&Environment{
+			SysctlBaseline: []string{"# platform baseline", "fs.inotify.max_user_watches = 65536", "net/ipv4/ip_local_port_range = 1024 65000"},
+		})
+		assert.Equal(t, "sysctl -e fs.inotify.max_user_watches net.ipv4.ip_local_port_range 2>/dev/null", c.Command())
+
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: output})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Equal(t, "All 2 kernel parameter(s) match the baseline", res.Message)
+		assert.Equal(t, "1024 65000", res.Facts["net.ipv4.ip_local_port_range"])
+	})
+
+	t.Run("drift from baseline file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "sysctl.conf")
+		require.NoError(t, os.WriteFile(file, []byte("net.core.somaxconn=16384\nvm.max_map_count = 262144\n"), 0o600))
+		c := newSysctlCompliance()
+		c.storedBaseline = []string{"net.core.somaxconn = 4096"}
+		c.baselineFile = file
+
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: output})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Equal(t, "2/2 kernel parameter(s) differ from the baseline: net.core.somaxconn, vm.max_map_count", res.Message)
+		assert.Contains(t, res.Details, "vm.max_map_count")
+		assert.Contains(t, res.Details, "(missing)")
+	})
+
+	t.Run("no baseline", func(t *testing.T) {
+		c := newSysctlCompliance()
+		assert.Contains(t, c.Command(), "vm.max_map_count")
+
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: output})
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		assert.Contains(t, res.Message, "no baseline")
+		assert.Len(t, res.Facts, 3)
+	})
+
+	t.Run("invalid baseline", func(t *testing.T) {
+		_, err := ParseSysctlBaseline([]string{"net.core.somaxconn 4096"})
+		assert.Error(t, err)
+		_, err = ParseSysctlBaseline([]string{"$(reboot) = 1"})
+		assert.Error(t, err)
+	})
+
+	t.Run("empty output", func(t *testing.T) {
+		c := newSysctlCompliance()
+		_, err := c.Parse(&pkgruntime.RunResult{Stdout: ""})
+		assert.Error(t, err)
+	})
+}
+
+func TestKernelLimitsParse(t *testing.T) {
+	c := newKernelLimits()
+
+	t.Run("healthy", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "file_nr:4320 9223372036854775807\n" +
+				"proc_fd:812 1048576 1234 - containerd\n" +
+				"pids:1520 4194304 255434\nkubelet_pod_pids_limit:-1\n" +
+				"pod_pids:12 max kube-system_coredns-abc_1111\n" +
+				"inotify_limits:1024 1048576\ninotify_user:0 210 30541\n" +
+				"inotify_proc:8191 4 2345 - kubelet\nzombies:0\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, res.Success, res.Message)
+		assert.Contains(t, res.Details, "pids: 1520/255434 (0%), kubelet podPidsLimit: -1")
+		assert.Contains(t, res.Details, "containerd")
+		assert.Contains(t, res.Details, "kube-system/coredns-abc")
+	})
+
+	t.Run("exhaustion", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "file_nr:900 1000\n" +
+				"proc_fd:1020 1024 4242 default_java-app-7d9f_2222 java -jar app.jar\n" +
+				"pids:30000 32768 255434\nkubelet_pod_pids_limit:1024\n" +
+				"pod_pids:1000 1024 default_forkbomb_3333\n" +
+				"inotify_limits:128 8192\ninotify_user:1000 127 512\n" +
+				"inotify_proc:512 127 5555 monitoring_promtail-x_4444 promtail\n" +
+				"zombies:250\nzombie_parent:250 6666 default_shell-runner_5555 bash\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "file descriptors 900/1000")
+		assert.Contains(t, res.Message, "java -jar app.jar (pid 4242, pod default/java-app-7d9f) has 1020/1024 open files")
+		assert.Contains(t, res.Message, "pids 30000/32768")
+		assert.Contains(t, res.Message, "pod default/forkbomb uses 1000/1024 pids")
+		assert.Contains(t, res.Message, "uid 1000 uses 127/128 inotify instances")
+		assert.NotContains(t, res.Message, "inotify watches")
+		assert.Contains(t, res.Message, "250 zombie processes")
+		assert.Contains(t, res.Details, "monitoring/promtail-x")
+		assert.Contains(t, res.Details, "default/shell-runner")
+	})
+
+	t.Run("empty output", func(t *testing.T) {
+		_, err := c.Parse(&pkgruntime.RunResult{Stdout: ""})
+		assert.Error(t, err)
+	})
+}
+
+func TestKubeletSince(t *testing.T) {
+	c := newKubelet()
+	fs := pflag.NewFlagSet("kubelet", pflag.ContinueOnError)
+	c.AddFlags(fs)
+
+	require.NoError(t, fs.Set("since", "30 min ago"))
+	assert.Contains(t, c.Command(), "journalctl -u kubelet --since '30 min ago' ")
+	assert.Error(t, fs.Set("since", "`reboot`"))
+
+	c.since = `"; reboot; "`
+	assert.Contains(t, c.Command(), `journalctl -u kubelet --since '"; reboot; "' `)
+}
+
+func TestKubeletParse(t *testing.T) {
+	c := newKubelet()
+
+	t.Run("healthy from configz", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "version:Kubernetes v1.29.2\nhealthz:ok\n" +
+				"flags:--config=/etc/default/kubeletconfig.json --max-pods=30\n" +
+				"flag_max-pods:30\nconfig_source:configz\n" +
+				"config_maxPods:110\nconfig_cgroupDriver:systemd\nconfig_serializeImagePulls:false\n" +
+				`config_evictionHard:{"nodefs.available":"10%","memory.available":"750Mi"}` + "\n" +
+				"count_pleg:0\ncount_certificate:0\ncount_eviction:0\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, res.Success, res.Message)
+		assert.Equal(t, "Kubelet v1.29.2 healthy", res.Message)
+		assert.Equal(t, map[string]string{
+			"maxPods":             "110",
+			"evictionHard":        "memory.available<750Mi,nodefs.available<10%",
+			"cgroupDriver":        "systemd",
+			"serializeImagePulls": "false",
+		}, res.Facts)
+		assert.Contains(t, res.Details, "--max-pods=30")
+	})
+
+	t.Run("flags override config file", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "version:Kubernetes v1.29.2\nhealthz:ok\n" +
+				"flag_max-pods:30\nflag_eviction-hard:nodefs.available<10%,memory.available<750Mi\n" +
+				"config_source:/etc/default/kubeletconfig.json\nconfig_maxPods:110\n" +
+				"count_pleg:0\ncount_certificate:0\ncount_eviction:0\n",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "30", res.Facts["maxPods"])
+		assert.Equal(t, "memory.available<750Mi,nodefs.available<10%", res.Facts["evictionHard"])
+		assert.Contains(t, res.Details, "--max-pods")
+	})
+
+	t.Run("unhealthy", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "version:Kubernetes v1.29.2\nhealthz:[-]syncloop failed\nconfig_source:none\n" +
+				"kmsg:pleg|PLEG is not healthy: pleg was last seen active 3m0s ago\n" +
+				"count_pleg:4\ncount_certificate:0\ncount_eviction:1\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "healthz: [-]syncloop failed")
+		assert.Contains(t, res.Message, "4 pleg error(s)")
+		assert.Contains(t, res.Message, "1 eviction error(s)")
+		assert.Contains(t, res.Details, "[pleg] PLEG is not healthy")
+	})
+
+	t.Run("not running", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: "kubelet:not-running\n"})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+	})
+
+	t.Run("empty output", func(t *testing.T) {
+		_, err := c.Parse(&pkgruntime.RunResult{Stdout: ""})
+		assert.Error(t, err)
+	})
+}
+
+func TestClassifyKubeletMessages(t *testing.T) {
+	for line, want := range map[string]string{
+		`"Skipping pod synchronization" err="PLEG is not healthy: pleg was last seen active 3m2s ago"`:                          "pleg",
+		`"Failed while requesting a signed certificate from the control plane" err="cannot create certificate signing request"`: "certificate",
+		`"Unable to authenticate the request" err="x509: certificate has expired or is not yet valid"`:                          "certificate",
+		`"Eviction manager: attempting to reclaim" resourceName="memory"`:                                                       "eviction",
+		`"Eviction manager: pods evicted, waiting for pod to be cleaned up" pods=["default/app"]`:                               "eviction",
+		`"Eviction manager: no resources are starved"`:                                                                          "",
+		`"SyncLoop (PLEG): event for pod" pod="default/app"`:                                                                    "",
+	} {
+		assert.Equal(t, want, classifyLogMessage(kubeletCategories, line), line)
+	}
+}
+
+func TestDataplaneParse(t *testing.T) {
+	c := newDataplane()
+
+	t.Run("kube-proxy healthy", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "iptables_mode:nf_tables\n" +
+				"no_endpoints:default/web:http\n" +
+				"rules_filter:40\nrules_nat:1200\nrules_mangle:2\n" +
+				"kube_services:150\nkube_svc_chains:148\nkube_sep_chains:310\nno_endpoints_count:1\n" +
+				"other_backend:legacy 0\n" +
+				"kube_proxy:running\n" +
+				`kube_proxy_healthz:{"lastUpdated": "2024-05-01 10:00:00 +0000 UTC"} 200` + "\n" +
+				"kube_proxy_last_sync:1.71455757e+09\nkube_proxy_restore_failures:0\nnow:1714557600\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, res.Success, res.Message)
+		assert.Equal(t, "Dataplane OK (kube-proxy): 1242 iptables rules, 1 services without endpoints", res.Message)
+		assert.Contains(t, res.Details, "KUBE-SERVICES: 150 rules, 148 service chains, 310 endpoint chains")
+		assert.Contains(t, res.Details, "default/web:http")
+		assert.Contains(t, res.Details, "last rules sync: 30s ago")
+	})
+
+	t.Run("kube-proxy stale", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "iptables_mode:nf_tables\nrules_nat:1200\nkube_services:150\nno_endpoints_count:0\n" +
+				"other_backend:legacy 512\n" +
+				"kube_proxy:running\n" +
+				`kube_proxy_healthz:{"lastUpdated": "2024-05-01 09:00:00 +0000 UTC"} 503` + "\n" +
+				"kube_proxy_last_sync:1714554000\nkube_proxy_restore_failures:3\nnow:1714557600\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "512 stale KUBE-* rules in iptables-legacy while the node uses nf_tables")
+		assert.Contains(t, res.Message, "kube-proxy healthz:")
+		assert.Contains(t, res.Message, "kube-proxy last synced rules 3600s ago")
+		assert.Contains(t, res.Details, "iptables-restore failures since start: 3")
+	})
+
+	t.Run("cilium", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{
+			Stdout: "iptables_mode:nf_tables\nrules_filter:20\nkube_services:0\nno_endpoints_count:0\n" +
+				"cilium:running\n" +
+				"cilium_status:KVStore: Ok Disabled\n" +
+				"cilium_status:Kubernetes: Ok 1.29 (v1.29.2) [linux/amd64]\n" +
+				"cilium_status:KubeProxyReplacement: True [eth0 10.224.0.4]\n" +
+				"cilium_status:Cilium: Ok 1.14.10 (v1.14.10-1)\n" +
+				"cilium_status:Controller Status: 38/40 healthy\n" +
+				"cilium_status:Cluster health: 3/3 reachable (2024-05-01T10:00:00Z)\n",
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Equal(t, "Dataplane (Cilium): cilium controller status: 38/40 healthy", res.Message)
+		assert.Contains(t, res.Details, "KubeProxyReplacement: True")
+	})
+
+	t.Run("no proxy", func(t *testing.T) {
+		res, err := c.Parse(&pkgruntime.RunResult{Stdout: "iptables_mode:unknown\nno_endpoints_count:0\n"})
+		require.NoError(t, err)
+		assert.False(t, res.Success)
+		assert.Contains(t, res.Message, "no kube-proxy or Cilium agent running")
+	})
+
+	t.Run("empty output", func(t *testing.T) {
+		_, err := c.Parse(&pkgruntime.RunResult{Stdout: ""})
+		assert.Error(t, err)
+	})
+}
+
 func TestRegistry(t *testing.T) {
 	all := All()
 	assert.NotEmpty(t, all)
@@ -233,6 +1146,63 @@ func TestRegistry(t *testing.T) {
 	assert.True(t, names["dns-slow"])
 	assert.True(t, names["tcp-drops"])
 	assert.True(t, names["tcp-retrans"])
+	assert.True(t, names["conntrack"])
+	assert.True(t, names["resource-pressure"])
+	assert.True(t, names["container-runtime"])
+	assert.True(t, names["egress"])
+	assert.True(t, names["azure-platform"])
+	assert.True(t, names["image-pull"])
+	assert.True(t, names["network-interface"])
+	assert.True(t, names["cni-config"])
+	assert.True(t, names["kernel-health"])
+	assert.True(t, names["sysctl-compliance"])
+	assert.True(t, names["kernel-limits"])
+	assert.True(t, names["kubelet"])
+	assert.True(t, names["dataplane"])
+}
+
+// fakeRuntime records the command it runs and returns a fixed output.
+type fakeRuntime struct {
+	os      pkgruntime.OS
+	stdout  string
+	command string
+}
+
+func (r *fakeRuntime) RunCommand(ctx context.Context, opts *pkgruntime.RunOptions) (*pkgruntime.RunResult, error) {
+	r.command = opts.Command
+	return &pkgruntime.RunResult{Stdout: r.stdout}, nil
+}
+
+func (r *fakeRuntime) NodeOS(ctx context.Context, nodeName string) (pkgruntime.OS, error) {
+	return r.os, nil
+}
+
+func TestRunOnNodeOS(t *testing.T) {
+	t.Run("linux", func(t *testing.T) {
+		rt := &fakeRuntime{os: pkgruntime.OSLinux, stdout: "kubelet:active\ncontainerd:active\n"}
+		nr, err := RunOnNode(context.Background(), &processHealth{}, rt, "node1", 60, 0)
+		require.NoError(t, err)
+		require.NoError(t, nr.Err)
+		assert.True(t, nr.Result.Success)
+		assert.Contains(t, rt.command, "systemctl")
+	})
+
+	t.Run("windows", func(t *testing.T) {
+		rt := &fakeRuntime{os: pkgruntime.OSWindows, stdout: "kubelet:active\ncontainerd:active\n"}
+		nr, err := RunOnNode(context.Background(), &processHealth{}, rt, "akswin000000", 60, 0)
+		require.NoError(t, err)
+		require.NoError(t, nr.Err)
+		assert.True(t, nr.Result.Success)
+		assert.Contains(t, rt.command, "Get-Service")
+	})
+
+	t.Run("windows not supported", func(t *testing.T) {
+		rt := &fakeRuntime{os: pkgruntime.OSWindows}
+		nr, err := RunOnNode(context.Background(), newKernelLimits(), rt, "akswin000000", 60, 0)
+		require.NoError(t, err)
+		assert.EqualError(t, nr.Err, `check "kernel-limits" doesn't support windows nodes`)
+		assert.Empty(t, rt.command)
+	})
 }
 
 func TestFormatResults(t *testing.T) {
@@ -258,4 +1228,102 @@ func TestFormatResults(t *testing.T) {
 		assert.NotContains(t, output, "===")
 		assert.Contains(t, output, "✓ ok")
 	})
+
+	t.Run("cancelled node", func(t *testing.T) {
+		results := []NodeResult{
+			{NodeName: "node1", Result: &Result{Success: true, Message: "ok"}},
+			{NodeName: "node2", Err: fmt.Errorf("running check %q: %w", "kubelet", context.Canceled)},
+		}
+		output, hasFailure := FormatResults(results)
+		assert.True(t, hasFailure)
+		assert.Contains(t, output, "=== node2 ===\nCANCELLED\n")
+		assert.NotContains(t, output, "ERROR")
+	})
+}
+
+func TestFormatGroupedResults(t *testing.T) {
+	var results []NodeResult
+	for i := 0; i < 4; i++ {
+		results = append(results, NodeResult{
+			NodeName: fmt.Sprintf("aks-nodepool1-12345678-vmss%06d", i),
+			Result:   &Result{Success: true, Message: "ok"},
+		})
+	}
+	results[2].Result = &Result{Success: false, Message: "fail", Details: "some detail"}
+	results = append(results, NodeResult{NodeName: "akswin000000", Err: assert.AnError})
+
+	output, hasFailure := FormatGroupedResults(results)
+	assert.True(t, hasFailure)
+	assert.Equal(t, `=== aks-nodepool1-12345678-vmss[000000-000001,000003] (3 nodes) ===
+✓ ok
+
+=== aks-nodepool1-12345678-vmss000002 (1 node) ===
+✗ fail
+some detail
+
+=== akswin000000 (1 node) ===
+ERROR: assert.AnError general error for testing
+
+3 nodes ✓, 1 node ✗ (aks-nodepool1-12345678-vmss000002), 1 node ERROR (akswin000000)
+`, output)
+
+	output, hasFailure = FormatGroupedResults(results[:2])
+	assert.False(t, hasFailure)
+	assert.True(t, strings.HasSuffix(output, "\n2 nodes ✓\n"))
+}
+
+func TestNodePool(t *testing.T) {
+	assert.Equal(t, "nodepool1", nodePool("aks-nodepool1-12345678-vmss000000"))
+	assert.Equal(t, "gpupool", nodePool("aks-gpupool-87654321-vmss00000a"))
+	assert.Equal(t, "vmpool", nodePool("aks-vmpool-87654321-vms12"))
+	assert.Equal(t, "aspool", nodePool("aks-aspool-87654321-0"))
+	assert.Equal(t, "win1", nodePool("akswin1000002"))
+	assert.Equal(t, "", nodePool("mynode"))
+}
+
+func TestMarkPoolOutliers(t *testing.T) {
+	facts := func(somaxconn string) map[string]string {
+		return map[string]string{"net.core.somaxconn": somaxconn, "vm.max_map_count": "262144"}
+	}
+	results := []NodeResult{
+		{NodeName: "aks-nodepool1-12345678-vmss000000", Result: &Result{Success: true, Message: "ok", Facts: facts("16384")}},
+		{NodeName: "aks-nodepool1-12345678-vmss000001", Result: &Result{Success: true, Message: "ok", Facts: facts("16384")}},
+		{NodeName: "aks-nodepool1-12345678-vmss000002", Result: &Result{Success: true, Message: "ok", Facts: facts("4096")}},
+		{NodeName: "aks-nodepool1-12345678-vmss000003", Err: assert.AnError},
+		// Pools with less than three nodes have no majority to compare with.
+		{NodeName: "aks-gpupool-87654321-vmss000000", Result: &Result{Success: true, Message: "ok", Facts: facts("1024")}},
+		{NodeName: "aks-gpupool-87654321-vmss000001", Result: &Result{Success: true, Message: "ok", Facts: facts("4096")}},
+	}
+	markPoolOutliers(results)
+
+	assert.True(t, results[0].Result.Success)
+	assert.True(t, results[1].Result.Success)
+	assert.False(t, results[2].Result.Success)
+	assert.Equal(t, "ok; differs from the nodepool1 pool majority on 1 setting(s)", results[2].Result.Message)
+	assert.Equal(t, "net.core.somaxconn: 4096 (pool majority: 16384)", results[2].Result.Details)
+	assert.True(t, results[4].Result.Success)
+	assert.True(t, results[5].Result.Success)
+
+	t.Run("no strict majority", func(t *testing.T) {
+		results := []NodeResult{
+			{NodeName: "aks-np-1-vmss000000", Result: &Result{Success: true, Facts: map[string]string{"k": "a"}}},
+			{NodeName: "aks-np-1-vmss000001", Result: &Result{Success: true, Facts: map[string]string{"k": "b"}}},
+			{NodeName: "aks-np-1-vmss000002", Result: &Result{Success: true, Facts: map[string]string{"k": "c"}}},
+		}
+		markPoolOutliers(results)
+		for _, r := range results {
+			assert.True(t, r.Result.Success)
+		}
+	})
+
+	t.Run("missing fact", func(t *testing.T) {
+		results := []NodeResult{
+			{NodeName: "aks-np-1-vmss000000", Result: &Result{Success: true, Facts: map[string]string{"k": "a"}}},
+			{NodeName: "aks-np-1-vmss000001", Result: &Result{Success: true, Facts: map[string]string{"k": "a"}}},
+			{NodeName: "aks-np-1-vmss000002", Result: &Result{Success: true, Details: "table", Facts: map[string]string{"other": "x"}}},
+		}
+		markPoolOutliers(results)
+		assert.False(t, results[2].Result.Success)
+		assert.Contains(t, results[2].Result.Details, "table\nk: <unset> (pool majority: a)")
+	})
 }
@@ -0,0 +1,297 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newCNIConfig())
+}
+
+type cniConfigCheck struct {
+	// ipUsageThreshold is the IP pool usage (percent) considered as exhaustion.
+	ipUsageThreshold int
+}
+
+func newCNIConfig() *cniConfigCheck {
+	return &cniConfigCheck{ipUsageThreshold: 90}
+}
+
+func (c *cniConfigCheck) Name() string { return "cni-config" }
+func (c *cniConfigCheck) Description() string {
+	return "Check the CNI configuration, plugin binaries and IP pool usage of the node"
+}
+func (c *cniConfigCheck) Mode() Mode { return ModeVerify }
+
+func (c *cniConfigCheck) AddFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&c.ipUsageThreshold, "ip-usage-threshold", c.ipUsageThreshold,
+		"IP pool usage (percent) considered as exhaustion")
+}
+
+func (c *cniConfigCheck) Command() string {
+	// The container runtime only uses the first config file in lexical order,
+	// so only that one is printed, on a single line to fit the output limit.
+	return `dir=/etc/cni/net.d
+files=$(ls "$dir" 2>/dev/null | grep -E '\.(conf|conflist|json)$')
+echo "cni_files:$(echo $files)"
+first=$(echo "$files" | head -1)
+[ -n "$first" ] && echo "cni_conf:$(tr -d '\n\t' < "$dir/$first" | tr -s ' ')"
+echo "cni_bin:$(ls /opt/cni/bin 2>/dev/null | tr '\n' ' ')"
+for d in /var/lib/cni/networks/*/; do
+  [ -d "$d" ] && echo "ipam_hostlocal:$(basename "$d") $(ls "$d" | grep -Ec '^[0-9]+(\.[0-9]+){3}$')"
+done
+f=/var/run/azure-vnet-ipam.json
+[ -r $f ] && echo "ipam_azure:$(grep -o '"InUse": *true' $f | wc -l) $(grep -o '"InUse":' $f | wc -l)"
+command -v crictl >/dev/null 2>&1 && echo "pod_sandboxes:$(crictl pods -q 2>/dev/null | wc -l)"
+command -v kubectl >/dev/null 2>&1 && echo "node_pod_cidr:$(kubectl --kubeconfig /var/lib/kubelet/kubeconfig --request-timeout=5s get node "$(hostname)" -o jsonpath='{.spec.podCIDR}' 2>/dev/null)"`
+}
+
+// cniPlugin is the subset of a CNI plugin configuration used by the check.
+type cniPlugin struct {
+	Type string `json:"type"`
+	IPAM struct {
+		Type   string `json:"type"`
+		Mode   string `json:"mode"`
+		Subnet string `json:"subnet"`
+		Ranges [][]struct {
+			Subnet string `json:"subnet"`
+		} `json:"ranges"`
+	} `json:"ipam"`
+}
+
+// cniConfig is a CNI network configuration list. Single plugin ".conf" files
+// are converted to a list with one plugin.
+type cniConfig struct {
+	CNIVersion string      `json:"cniVersion"`
+	Name       string      `json:"name"`
+	Plugins    []cniPlugin `json:"plugins"`
+}
+
+func parseCNIConfig(raw string) (*cniConfig, error) {
+	var cfg cniConfig
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Plugins) == 0 {
+		var p cniPlugin
+		if err := json.Unmarshal([]byte(raw), &p); err != nil {
+			return nil, err
+		}
+		if p.Type != "" {
+			cfg.Plugins = []cniPlugin{p}
+		}
+	}
+	return &cfg, nil
+}
+
+// detectCNIPlugin returns the AKS network plugin a CNI configuration belongs to.
+func detectCNIPlugin(cfg *cniConfig) string {
+	for _, p := range cfg.Plugins {
+		switch {
+		case p.Type == "cilium-cni":
+			return "cilium"
+		case p.Type == "azure-vnet" && p.IPAM.Mode == "v4overlay":
+			return "azure-cni-overlay"
+		case p.Type == "azure-vnet":
+			return "azure-cni"
+		case p.Type == "bridge" && p.IPAM.Type == "host-local":
+			return "kubenet"
+		}
+	}
+	if len(cfg.Plugins) > 0 {
+		return cfg.Plugins[0].Type
+	}
+	return "unknown"
+}
+
+// inProcessIPAMs are the IPAM types handled by the CNI plugin itself rather
+// than executed as plugins, so there's no binary for them: azure-vnet and
+// cilium-cni request their addresses from the Azure CNS service.
+var inProcessIPAMs = map[string]bool{
+	"azure-cns": true,
+}
+
+// requiredCNIBinaries returns the binaries the container runtime executes for
+// a CNI configuration: every plugin and IPAM plugin, plus loopback which is
+// used for the pod loopback interface.
+func requiredCNIBinaries(cfg *cniConfig) []string {
+	seen := make(map[string]bool)
+	var bins []string
+	add := func(b string) {
+		if b != "" && !seen[b] {
+			seen[b] = true
+			bins = append(bins, b)
+		}
+	}
+	add("loopback")
+	for _, p := range cfg.Plugins {
+		add(p.Type)
+		if !inProcessIPAMs[p.IPAM.Type] {
+			add(p.IPAM.Type)
+		}
+	}
+	return bins
+}
+
+// hostLocalSubnets returns the subnets of the host-local IPAM plugins.
+func hostLocalSubnets(cfg *cniConfig) []string {
+	var subnets []string
+	for _, p := range cfg.Plugins {
+		if p.IPAM.Type != "host-local" {
+			continue
+		}
+		if p.IPAM.Subnet != "" {
+			subnets = append(subnets, p.IPAM.Subnet)
+		}
+		for _, set := range p.IPAM.Ranges {
+			for _, r := range set {
+				subnets = append(subnets, r.Subnet)
+			}
+		}
+	}
+	return subnets
+}
+
+// hostLocalCapacity returns the number of pod IPs host-local can allocate in
+// an IPv4 subnet: all addresses but the network, gateway and broadcast ones.
+func hostLocalCapacity(subnet string) (int64, bool) {
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil || !prefix.Addr().Is4() || prefix.Bits() > 30 {
+		return 0, false
+	}
+	return int64(1)<<(32-prefix.Bits()) - 3, true
+}
+
+func (c *cniConfigCheck) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	values := parseKeyValues(res.Stdout)
+	files, ok := values["cni_files"]
+	if !ok {
+		return nil, fmt.Errorf("couldn't parse cni-config output: %q", res.Stdout)
+	}
+	if files == "" {
+		return &Result{
+			Success: false,
+			Message: "CNI config: no network configuration in /etc/cni/net.d, pods can't get a network",
+		}, nil
+	}
+
+	var issues []string
+	var details []string
+
+	names := strings.Fields(files)
+	cfg, err := parseCNIConfig(values["cni_conf"])
+	if err != nil {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("CNI config: /etc/cni/net.d/%s is invalid: %v", names[0], err),
+		}, nil
+	}
+	plugin := detectCNIPlugin(cfg)
+	details = append(details, fmt.Sprintf("config: /etc/cni/net.d/%s (%s, network %q, cniVersion %s)", names[0], plugin, cfg.Name, cfg.CNIVersion))
+	if len(names) > 1 {
+		details = append(details, "ignored: "+strings.Join(names[1:], ", "))
+	}
+	if len(cfg.Plugins) == 0 {
+		issues = append(issues, fmt.Sprintf("%s has no plugins", names[0]))
+	}
+
+	// Binaries
+	installed := make(map[string]bool)
+	for _, b := range strings.Fields(values["cni_bin"]) {
+		installed[b] = true
+	}
+	var missing []string
+	for _, b := range requiredCNIBinaries(cfg) {
+		if !installed[b] {
+			missing = append(missing, b)
+		}
+	}
+	if len(missing) > 0 {
+		issues = append(issues, "missing binaries in /opt/cni/bin: "+strings.Join(missing, ", "))
+	} else {
+		details = append(details, "binaries: ok")
+	}
+
+	// Pod CIDR and IPAM
+	nodePodCIDR := values["node_pod_cidr"]
+	if nodePodCIDR != "" {
+		details = append(details, "node podCIDR: "+nodePodCIDR)
+	}
+	var allocated int64
+	var haveIPAM bool
+	subnets := hostLocalSubnets(cfg)
+	for _, subnet := range subnets {
+		if nodePodCIDR != "" && subnet != nodePodCIDR {
+			issues = append(issues, fmt.Sprintf("CNI subnet %s doesn't match node podCIDR %s", subnet, nodePodCIDR))
+		}
+	}
+	for _, line := range strings.Split(res.Stdout, "\n") {
+		v, ok := strings.CutPrefix(strings.TrimSpace(line), "ipam_hostlocal:")
+		if !ok {
+			continue
+		}
+		var network string
+		var n int64
+		if _, err := fmt.Sscanf(v, "%s %d", &network, &n); err != nil || network != cfg.Name {
+			continue
+		}
+		haveIPAM = true
+		allocated += n
+		if len(subnets) == 1 {
+			if capacity, ok := hostLocalCapacity(subnets[0]); ok {
+				details = append(details, fmt.Sprintf("IP pool: %d/%d used (host-local %s)", n, capacity, subnets[0]))
+				if percent(n, capacity) >= c.ipUsageThreshold {
+					issues = append(issues, fmt.Sprintf("IP pool exhaustion: %d/%d pod IPs in use", n, capacity))
+				}
+				continue
+			}
+		}
+		details = append(details, fmt.Sprintf("IP pool: %d used (host-local)", n))
+	}
+	if v, ok := values["ipam_azure"]; ok {
+		var inUse, total int64
+		if _, err := fmt.Sscanf(v, "%d %d", &inUse, &total); err == nil {
+			haveIPAM = true
+			allocated += inUse
+			details = append(details, fmt.Sprintf("IP pool: %d/%d used (azure-vnet-ipam)", inUse, total))
+			switch {
+			case total == 0:
+				issues = append(issues, "IP pool is empty, no secondary IPs assigned to the node NIC")
+			case percent(inUse, total) >= c.ipUsageThreshold:
+				issues = append(issues, fmt.Sprintf("IP pool exhaustion: %d/%d secondary IPs in use", inUse, total))
+			}
+		}
+	}
+
+	// Every IP is allocated to a pod sandbox, so more allocations than
+	// sandboxes means the IPAM state leaked addresses.
+	if sandboxes, ok := intValue(values, "pod_sandboxes"); ok {
+		details = append(details, fmt.Sprintf("pod sandboxes: %d", sandboxes))
+		if haveIPAM && allocated > sandboxes {
+			issues = append(issues, fmt.Sprintf("%d IP(s) allocated without a pod sandbox (leaked IPAM state?)", allocated-sandboxes))
+		}
+	}
+
+	if len(issues) > 0 {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("CNI config (%s): %s", plugin, strings.Join(issues, "; ")),
+			Details: strings.Join(details, "\n"),
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: fmt.Sprintf("CNI config OK: %s", plugin),
+		Details: strings.Join(details, "\n"),
+	}, nil
+}
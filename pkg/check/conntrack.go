@@ -0,0 +1,158 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newConntrack())
+}
+
+type conntrack struct {
+	// tableThreshold is the conntrack table utilization (percent) considered exhausted.
+	tableThreshold int
+	// portThreshold is the ephemeral port utilization (percent) considered exhausted.
+	portThreshold int
+	// interval is the delay in seconds between the two samples. Zero disables
+	// the second sample.
+	interval int
+}
+
+func newConntrack() *conntrack {
+	return &conntrack{
+		tableThreshold: 80,
+		portThreshold:  80,
+		interval:       5,
+	}
+}
+
+func (c *conntrack) Name() string { return "conntrack" }
+func (c *conntrack) Description() string {
+	return "Check conntrack table and ephemeral port exhaustion on the node"
+}
+func (c *conntrack) Mode() Mode { return ModeVerify }
+
+func (c *conntrack) AddFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&c.tableThreshold, "conntrack-threshold", c.tableThreshold,
+		"Conntrack table utilization (percent) considered exhausted")
+	fs.IntVar(&c.portThreshold, "port-threshold", c.portThreshold,
+		"Ephemeral port utilization (percent) of the busiest destination considered exhausted")
+	fs.IntVar(&c.interval, "interval", c.interval,
+		"Seconds between the two samples used to detect growing counters (0 to sample once)")
+}
+
+// conntrackCounters are the /proc/net/stat/nf_conntrack columns that indicate
+// packets being dropped because of a full or contended conntrack table.
+var conntrackCounters = []string{"insert_failed", "drop", "early_drop"}
+
+func (c *conntrack) Command() string {
+	// Each sample prints "key:value" lines. The per-CPU conntrack statistics
+	// are hexadecimal, so they are summed on the node to keep the output small.
+	sample := `sample() {
+  echo "sample:$1"
+  echo "conntrack_count:$(cat /proc/sys/net/netfilter/nf_conntrack_count 2>/dev/null)"
+  echo "conntrack_max:$(cat /proc/sys/net/netfilter/nf_conntrack_max 2>/dev/null)"
+  awk '{print "port_min:" $1; print "port_max:" $2}' /proc/sys/net/ipv4/ip_local_port_range
+  ss -s 2>/dev/null | awk '/^TCP:/ {print "tcp_sockets:" $2; for (i = 1; i <= NF; i++) if ($i == "timewait") {v = $(i+1); gsub(/[^0-9]/, "", v); print "timewait:" v}}'
+  ss -Htan 2>/dev/null | awk '$1 != "LISTEN" {print $5}' | sort | uniq -c | sort -rn | head -1 | awk '{print "top_destination:" $2; print "top_destination_sockets:" $1}'
+  [ -r /proc/net/stat/nf_conntrack ] && awk 'function hex(s,  i, n) {n = 0; s = tolower(s); for (i = 1; i <= length(s); i++) n = n * 16 + index("0123456789abcdef", substr(s, i, 1)) - 1; return n}
+    NR == 1 {for (i = 1; i <= NF; i++) col[$i] = i; next}
+    {ins += hex($col["insert_failed"]); drop += hex($col["drop"]); ed += hex($col["early_drop"])}
+    END {printf "insert_failed:%.0f\ndrop:%.0f\nearly_drop:%.0f\n", ins, drop, ed}' /proc/net/stat/nf_conntrack
+}
+sample 1`
+	if c.interval > 0 {
+		sample += fmt.Sprintf("\nsleep %d\nsample 2", c.interval)
+	}
+	return sample
+}
+
+func (c *conntrack) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	samples := parseSamples(res.Stdout)
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("couldn't parse conntrack output: %q", res.Stdout)
+	}
+	last := samples[len(samples)-1]
+
+	var issues []string
+	var details []string
+
+	count, okCount := intValue(last, "conntrack_count")
+	limit, okMax := intValue(last, "conntrack_max")
+	tablePct := 0
+	if okCount && okMax {
+		tablePct = percent(count, limit)
+		details = append(details, fmt.Sprintf("conntrack table: %d/%d (%d%%)", count, limit, tablePct))
+		if tablePct >= c.tableThreshold {
+			issues = append(issues, fmt.Sprintf("conntrack table %d%% used", tablePct))
+		}
+	} else {
+		details = append(details, "conntrack table: not available (nf_conntrack not loaded)")
+	}
+
+	portPct := 0
+	portMin, okMin := intValue(last, "port_min")
+	portMax, okPMax := intValue(last, "port_max")
+	if okMin && okPMax && portMax >= portMin {
+		ports := portMax - portMin + 1
+		details = append(details, fmt.Sprintf("ephemeral ports: %d-%d (%d ports)", portMin, portMax, ports))
+		if sockets, ok := intValue(last, "top_destination_sockets"); ok {
+			portPct = percent(sockets, ports)
+			details = append(details, fmt.Sprintf("busiest destination: %s with %d socket(s) (%d%% of ports)",
+				last["top_destination"], sockets, portPct))
+			if portPct >= c.portThreshold {
+				issues = append(issues, fmt.Sprintf("ephemeral ports to %s %d%% used", last["top_destination"], portPct))
+			}
+		}
+	}
+
+	if tcp, ok := intValue(last, "tcp_sockets"); ok {
+		timewait, _ := intValue(last, "timewait")
+		details = append(details, fmt.Sprintf("tcp sockets: %d, time-wait: %d", tcp, timewait))
+	}
+
+	var counters []string
+	for _, k := range conntrackCounters {
+		if v, ok := intValue(last, k); ok {
+			counters = append(counters, fmt.Sprintf("%s: %d", k, v))
+		}
+	}
+	if len(counters) > 0 {
+		details = append(details, strings.Join(counters, ", "))
+	}
+
+	if len(samples) > 1 {
+		first := samples[0]
+		dropping := growing(first, last, conntrackCounters...)
+		if len(dropping) > 0 {
+			issues = append(issues, fmt.Sprintf("conntrack drops growing (%s)", strings.Join(dropping, ", ")))
+		}
+		all := growing(first, last, append([]string{"conntrack_count", "timewait", "top_destination_sockets"}, conntrackCounters...)...)
+		if len(all) > 0 {
+			details = append(details, fmt.Sprintf("growing over %ds: %s", c.interval, strings.Join(all, ", ")))
+		} else {
+			details = append(details, fmt.Sprintf("growing over %ds: none", c.interval))
+		}
+	}
+
+	if len(issues) > 0 {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("Conntrack/SNAT exhaustion: %s", strings.Join(issues, "; ")),
+			Details: strings.Join(details, "\n"),
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: fmt.Sprintf("Conntrack OK: table %d%% used, ephemeral ports %d%% used", tablePct, portPct),
+		Details: strings.Join(details, "\n"),
+	}, nil
+}
@@ -0,0 +1,232 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newContainerRuntime())
+}
+
+type containerRuntime struct {
+	// restartThreshold is the restart count (container attempt) from which a
+	// container is reported.
+	restartThreshold int
+	// imageFSThreshold is the image filesystem usage (percent) considered as
+	// pressure. Kubelet image GC starts at 85% by default.
+	imageFSThreshold int
+}
+
+func newContainerRuntime() *containerRuntime {
+	return &containerRuntime{
+		restartThreshold: 5,
+		imageFSThreshold: 85,
+	}
+}
+
+func (c *containerRuntime) Name() string { return "container-runtime" }
+func (c *containerRuntime) Description() string {
+	return "Check container runtime health, stuck or restarting containers and image filesystem usage via crictl"
+}
+func (c *containerRuntime) Mode() Mode { return ModeVerify }
+
+func (c *containerRuntime) AddFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&c.restartThreshold, "restart-threshold", c.restartThreshold,
+		"Restart count from which a container is reported")
+	fs.IntVar(&c.imageFSThreshold, "imagefs-threshold", c.imageFSThreshold,
+		"Image filesystem usage (percent) considered as pressure")
+}
+
+func (c *containerRuntime) Command() string {
+	// The JSON output of crictl is summarized on the node: only the runtime
+	// conditions and the containers that are stuck or restarting are printed.
+	return fmt.Sprintf(`command -v crictl >/dev/null 2>&1 || { echo "crictl:missing"; exit 0; }
+crictl info 2>/dev/null | tr -d '\n' | tr -s ' ' | grep -o '"conditions": *\[[^]]*\]' | sed 's/^"conditions": */runtime_conditions:/'
+crictl ps -a -o json 2>/dev/null | awk -F'"' -v restarts=%d '%s'
+echo "images:$(crictl images -q 2>/dev/null | sort -u | wc -l)"
+mount=$(crictl imagefsinfo 2>/dev/null | grep -o '"mountpoint": *"[^"]*"' | head -1 | cut -d'"' -f4)
+[ -d "$mount" ] || mount=/var/lib/containerd
+echo "imagefs_mount:$mount"
+echo "imagefs_usage:$(df -P "$mount" | awk 'NR==2 {gsub(/%%/, ""); print $5}')"`, c.restartThreshold, crictlPsAwkProgram)
+}
+
+// crictlPsAwkProgram summarizes the JSON output of "crictl ps -a", split on
+// double quotes, printing the containers that are stuck or restarted at least
+// restarts times. crictl lists the previous attempts of a container too, so
+// only the latest one of each pod container is considered.
+const crictlPsAwkProgram = `
+  function emit() {
+    if (id == "") return
+    key = ns "/" pod " " name
+    if (!(key in latest) || attempt > latest[key]) {
+      latest[key] = attempt
+      states[key] = state
+    }
+    id = ""; name = ""; state = ""; attempt = 0; pod = ""; ns = ""
+  }
+  $2 == "id" {emit(); id = $4}
+  $2 == "name" && name == "" {name = $4}
+  $2 == "attempt" {attempt = $3; gsub(/[^0-9]/, "", attempt); attempt += 0}
+  $2 == "state" {state = $4}
+  $2 == "io.kubernetes.pod.name" {pod = $4}
+  $2 == "io.kubernetes.pod.namespace" {ns = $4}
+  END {
+    emit()
+    for (key in latest) {
+      state = states[key]
+      total++
+      if (state == "CONTAINER_RUNNING") running++
+      if ((state != "CONTAINER_RUNNING" && state != "CONTAINER_EXITED") || latest[key] >= restarts)
+        print "container:" state " " latest[key] " " key
+    }
+    print "containers_total:" total + 0
+    print "containers_running:" running + 0
+  }`
+
+// runtimeCondition is a CRI runtime condition as reported by "crictl info".
+type runtimeCondition struct {
+	Type    string `json:"type"`
+	Status  bool   `json:"status"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// criContainer is a container summarized by the check command.
+type criContainer struct {
+	state   string
+	attempt int
+	pod     string
+	name    string
+}
+
+// parseCRIContainer parses "<state> <attempt> <namespace>/<pod> <name>".
+func parseCRIContainer(v string) (criContainer, bool) {
+	fields := strings.Fields(v)
+	if len(fields) != 4 {
+		return criContainer{}, false
+	}
+	attempt, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return criContainer{}, false
+	}
+	return criContainer{
+		state:   strings.TrimPrefix(fields[0], "CONTAINER_"),
+		attempt: attempt,
+		pod:     fields[2],
+		name:    fields[3],
+	}, true
+}
+
+func (c *containerRuntime) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	values := parseKeyValues(res.Stdout)
+	if _, ok := values["crictl"]; ok {
+		return &Result{
+			Success: false,
+			Message: "Container runtime: crictl not found on the node",
+		}, nil
+	}
+
+	var issues []string
+	var details []string
+
+	// Runtime conditions
+	raw, ok := values["runtime_conditions"]
+	if !ok {
+		issues = append(issues, "runtime status unavailable (crictl info failed)")
+	} else {
+		var conditions []runtimeCondition
+		if err := json.Unmarshal([]byte(raw), &conditions); err != nil {
+			return nil, fmt.Errorf("parsing runtime conditions %q: %w", raw, err)
+		}
+		var conds []string
+		for _, cond := range conditions {
+			conds = append(conds, fmt.Sprintf("%s=%t", cond.Type, cond.Status))
+			if !cond.Status {
+				msg := cond.Type
+				if cond.Reason != "" || cond.Message != "" {
+					msg = fmt.Sprintf("%s (%s)", cond.Type, strings.TrimSpace(cond.Reason+" "+cond.Message))
+				}
+				issues = append(issues, "not ready: "+msg)
+			}
+		}
+		details = append(details, "runtime conditions: "+strings.Join(conds, ", "))
+	}
+
+	// Containers
+	total, _ := intValue(values, "containers_total")
+	running, _ := intValue(values, "containers_running")
+	details = append(details, fmt.Sprintf("containers: %d total, %d running", total, running))
+
+	var stuck, restarting []criContainer
+	for _, line := range strings.Split(res.Stdout, "\n") {
+		v, ok := strings.CutPrefix(strings.TrimSpace(line), "container:")
+		if !ok {
+			continue
+		}
+		ctr, ok := parseCRIContainer(v)
+		if !ok {
+			continue
+		}
+		if ctr.state != "RUNNING" && ctr.state != "EXITED" {
+			stuck = append(stuck, ctr)
+		} else {
+			restarting = append(restarting, ctr)
+		}
+	}
+	// awk prints the containers in no particular order.
+	for _, ctrs := range [][]criContainer{stuck, restarting} {
+		sort.Slice(ctrs, func(i, j int) bool {
+			return ctrs[i].pod+" "+ctrs[i].name < ctrs[j].pod+" "+ctrs[j].name
+		})
+	}
+	if len(stuck) > 0 {
+		issues = append(issues, fmt.Sprintf("%d container(s) stuck in Created/Unknown", len(stuck)))
+	}
+	if len(restarting) > 0 {
+		issues = append(issues, fmt.Sprintf("%d container(s) restarted %d+ times", len(restarting), c.restartThreshold))
+	}
+	if len(stuck)+len(restarting) > 0 {
+		var b strings.Builder
+		w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "POD\tCONTAINER\tSTATE\tRESTARTS")
+		for _, ctr := range append(stuck, restarting...) {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", ctr.pod, ctr.name, ctr.state, ctr.attempt)
+		}
+		w.Flush()
+		details = append(details, strings.TrimRight(b.String(), "\n"))
+	}
+
+	// Image filesystem
+	images, _ := intValue(values, "images")
+	if usage, ok := intValue(values, "imagefs_usage"); ok {
+		details = append(details, fmt.Sprintf("image filesystem: %s %d%% used, %d image(s)", values["imagefs_mount"], usage, images))
+		if int(usage) >= c.imageFSThreshold {
+			issues = append(issues, fmt.Sprintf("image filesystem %d%% used", usage))
+		}
+	}
+
+	if len(issues) > 0 {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("Container runtime: %s", strings.Join(issues, "; ")),
+			Details: strings.Join(details, "\n"),
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: fmt.Sprintf("Container runtime healthy: %d/%d containers running", running, total),
+		Details: strings.Join(details, "\n"),
+	}, nil
+}
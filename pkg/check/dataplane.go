@@ -0,0 +1,240 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newDataplane())
+}
+
+// ciliumStatusFields are the `cilium-dbg status` lines reported by the check.
+var ciliumStatusFields = []string{
+	"KVStore", "Kubernetes", "KubeProxyReplacement", "Cilium", "Cilium health daemon",
+	"IPAM", "Controller Status", "Cluster health", "Host Routing", "Masquerading",
+}
+
+// ciliumOkFields are the `cilium-dbg status` lines that must report "Ok".
+var ciliumOkFields = map[string]bool{
+	"KVStore":              true,
+	"Kubernetes":           true,
+	"Cilium":               true,
+	"Cilium health daemon": true,
+}
+
+type dataplane struct {
+	// syncThreshold is the maximum age, in seconds, of the last kube-proxy
+	// rules sync.
+	syncThreshold int
+	// samples is the number of services without endpoints listed.
+	samples int
+}
+
+func newDataplane() *dataplane {
+	return &dataplane{
+		syncThreshold: 300,
+		samples:       5,
+	}
+}
+
+func (c *dataplane) Name() string { return "dataplane" }
+func (c *dataplane) Description() string {
+	return "Check iptables/nftables rule counts, services without endpoints, and kube-proxy sync or Cilium agent health"
+}
+func (c *dataplane) Mode() Mode { return ModeVerify }
+
+func (c *dataplane) AddFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&c.syncThreshold, "sync-threshold", c.syncThreshold,
+		"Maximum age in seconds of the last kube-proxy rules sync")
+	fs.IntVar(&c.samples, "samples", c.samples, "Number of services without endpoints listed")
+}
+
+func (c *dataplane) Command() string {
+	// The rules are summarized on the node: a large cluster has tens of
+	// thousands of them. KUBE-* rules left in the iptables backend not used
+	// by the node (legacy vs nf_tables) are a sign of stale rules.
+	return fmt.Sprintf(`mode=$(iptables -V 2>/dev/null | grep -oE 'nf_tables|legacy')
+echo "iptables_mode:${mode:-unknown}"
+iptables-save 2>/dev/null | awk -v samples=%[1]d '
+/^\*/ {t = substr($0, 2)}
+/^:KUBE-SVC-/ {svc++}
+/^:KUBE-SEP-/ {sep++}
+/^-A/ {n[t]++}
+t == "nat" && /^-A KUBE-SERVICES / {ks++}
+t == "filter" && /^-A KUBE-SERVICES .*has no endpoints/ {ne++; if (ne <= samples) {s = $0; sub(/.*--comment "?/, "", s); sub(/ has no endpoints.*/, "", s); print "no_endpoints:" s}}
+END {for (t in n) print "rules_" t ":" n[t]; print "kube_services:" ks + 0; print "kube_svc_chains:" svc + 0; print "kube_sep_chains:" sep + 0; print "no_endpoints_count:" ne + 0}'
+other=legacy; [ "$mode" = legacy ] && other=nft
+command -v iptables-$other-save >/dev/null 2>&1 && echo "other_backend:$other $(iptables-$other-save 2>/dev/null | grep -c '^-A KUBE-')"
+if nft list table ip kube-proxy >/dev/null 2>&1; then
+  echo "nft_kube_proxy_rules:$(nft -a list table ip kube-proxy | grep '# handle' | grep -cvE '^\s*(table|chain|set|map) ')"
+fi
+if pgrep -x kube-proxy >/dev/null; then
+  echo "kube_proxy:running"
+  echo "kube_proxy_healthz:$(curl -s -m 5 -w ' %%{http_code}' http://127.0.0.1:10256/healthz 2>/dev/null | tr -d '\n' | tail -c 300)"
+  curl -s -m 5 http://127.0.0.1:10249/metrics 2>/dev/null | awk '
+/^kubeproxy_sync_proxy_rules_last_timestamp_seconds / {print "kube_proxy_last_sync:" $2}
+/^kubeproxy_sync_proxy_rules_iptables_restore_failures_total / {print "kube_proxy_restore_failures:" $2}'
+  echo "now:$(date +%%s)"
+fi
+if pgrep -x cilium-agent >/dev/null; then
+  echo "cilium:running"
+  id=$(crictl ps -q --name cilium-agent 2>/dev/null | head -1)
+  if command -v cilium-dbg >/dev/null 2>&1; then
+    cilium-dbg status
+  elif [ -n "$id" ]; then
+    crictl exec "$id" sh -c "cilium-dbg status || cilium status"
+  fi 2>/dev/null | grep -E '^(%[2]s):' | sed 's/[[:space:]][[:space:]]*/ /g' | cut -c1-150 | sed 's/^/cilium_status:/'
+fi`, c.samples, strings.Join(ciliumStatusFields, "|"))
+}
+
+// parseCiliumStatus returns the issues reported by "Field: value" lines of
+// `cilium-dbg status`.
+func parseCiliumStatus(lines []string) []string {
+	var issues []string
+	for _, line := range lines {
+		field, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case ciliumOkFields[field]:
+			if !strings.HasPrefix(value, "Ok") && !strings.HasPrefix(value, "Disabled") {
+				issues = append(issues, fmt.Sprintf("cilium %s: %s", field, value))
+			}
+		case field == "Controller Status" || field == "Cluster health":
+			// "38/40 healthy", "2/3 reachable"
+			var ok, total int
+			if _, err := fmt.Sscanf(value, "%d/%d", &ok, &total); err == nil && ok < total {
+				issues = append(issues, fmt.Sprintf("cilium %s: %s", strings.ToLower(field), value))
+			}
+		}
+	}
+	return issues
+}
+
+func (c *dataplane) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	values := parseKeyValues(res.Stdout)
+	mode, ok := values["iptables_mode"]
+	if !ok {
+		return nil, fmt.Errorf("couldn't parse dataplane output: %q", res.Stdout)
+	}
+
+	var issues []string
+	var details []string
+
+	// Rules
+	var tables []string
+	for k := range values {
+		if t, ok := strings.CutPrefix(k, "rules_"); ok {
+			tables = append(tables, t)
+		}
+	}
+	sort.Strings(tables)
+	var total int64
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tRULES")
+	for _, t := range tables {
+		n, _ := intValue(values, "rules_"+t)
+		total += n
+		fmt.Fprintf(w, "%s\t%d\n", t, n)
+	}
+	w.Flush()
+	kubeServices, _ := intValue(values, "kube_services")
+	svcChains, _ := intValue(values, "kube_svc_chains")
+	sepChains, _ := intValue(values, "kube_sep_chains")
+	details = append(details, fmt.Sprintf("iptables (%s): %d rules", mode, total))
+	if len(tables) > 0 {
+		details = append(details, strings.TrimRight(b.String(), "\n"))
+	}
+	details = append(details, fmt.Sprintf("KUBE-SERVICES: %d rules, %d service chains, %d endpoint chains",
+		kubeServices, svcChains, sepChains))
+	if n, ok := intValue(values, "nft_kube_proxy_rules"); ok {
+		details = append(details, fmt.Sprintf("nftables kube-proxy table: %d rules", n))
+	}
+
+	// Stale rules
+	if other, ok := values["other_backend"]; ok {
+		fields := strings.Fields(other)
+		if len(fields) == 2 && fields[1] != "0" && mode != "unknown" {
+			issues = append(issues, fmt.Sprintf("%s stale KUBE-* rules in iptables-%s while the node uses %s",
+				fields[1], fields[0], mode))
+		}
+	}
+	noEndpoints, _ := intValue(values, "no_endpoints_count")
+	if noEndpoints > 0 {
+		details = append(details, fmt.Sprintf("services without endpoints: %d", noEndpoints))
+		for _, svc := range prefixedLines(res.Stdout, "no_endpoints") {
+			details = append(details, "  "+svc)
+		}
+		if n := int64(len(prefixedLines(res.Stdout, "no_endpoints"))); n < noEndpoints {
+			details = append(details, fmt.Sprintf("  ... and %d more", noEndpoints-n))
+		}
+	}
+
+	// Proxy
+	var proxy string
+	switch {
+	case values["kube_proxy"] == "running":
+		proxy = "kube-proxy"
+		// healthz is the response body followed by the HTTP status code.
+		healthz := values["kube_proxy_healthz"]
+		body, code := "", healthz
+		if i := strings.LastIndex(healthz, " "); i >= 0 {
+			body, code = healthz[:i], healthz[i+1:]
+		}
+		if code != "200" {
+			issues = append(issues, fmt.Sprintf("kube-proxy healthz: %s", strings.TrimSpace(healthz)))
+		}
+		if body != "" {
+			details = append(details, "kube-proxy healthz: "+body)
+		}
+		last, okLast := floatValue(values, "kube_proxy_last_sync")
+		now, okNow := floatValue(values, "now")
+		if okLast && okNow && last > 0 {
+			age := int(now - last)
+			details = append(details, fmt.Sprintf("kube-proxy last rules sync: %ds ago", age))
+			if age > c.syncThreshold {
+				issues = append(issues, fmt.Sprintf("kube-proxy last synced rules %ds ago", age))
+			}
+		}
+		if failures, ok := floatValue(values, "kube_proxy_restore_failures"); ok && failures > 0 {
+			details = append(details, fmt.Sprintf("kube-proxy iptables-restore failures since start: %d", int64(failures)))
+		}
+	case values["cilium"] == "running":
+		proxy = "Cilium"
+		status := prefixedLines(res.Stdout, "cilium_status")
+		if len(status) == 0 {
+			issues = append(issues, "cilium-dbg status not available")
+		}
+		issues = append(issues, parseCiliumStatus(status)...)
+		details = append(details, status...)
+	default:
+		proxy = "no proxy"
+		issues = append(issues, "no kube-proxy or Cilium agent running")
+	}
+
+	if len(issues) > 0 {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("Dataplane (%s): %s", proxy, strings.Join(issues, "; ")),
+			Details: strings.Join(details, "\n"),
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: fmt.Sprintf("Dataplane OK (%s): %d iptables rules, %d services without endpoints", proxy, total, noEndpoints),
+		Details: strings.Join(details, "\n"),
+	}, nil
+}
@@ -32,6 +32,16 @@ echo "disk:${root_usage}"
 echo "inode:${inode_usage}"`
 }
 
+func (c *diskPressure) CommandForOS(os pkgruntime.OS) string {
+	switch os {
+	case pkgruntime.OSWindows:
+		// NTFS has no inode limit: only the usage of C: is reported.
+		return `$d = Get-CimInstance Win32_LogicalDisk -Filter "DeviceID='C:'"
+"disk:" + [math]::Ceiling(100 - 100 * $d.FreeSpace / $d.Size)`
+	}
+	return ""
+}
+
 func (c *diskPressure) Parse(res *pkgruntime.RunResult) (*Result, error) {
 	const threshold = 85
 
@@ -66,7 +76,13 @@ func (c *diskPressure) Parse(res *pkgruntime.RunResult) (*Result, error) {
 	}
 
 	diskPct := values["disk"]
-	inodePct := values["inode"]
+	inodePct, ok := values["inode"]
+	if !ok {
+		return &Result{
+			Success: true,
+			Message: fmt.Sprintf("Disk OK: root %d%% used", diskPct),
+		}, nil
+	}
 	return &Result{
 		Success: true,
 		Message: fmt.Sprintf("Disk OK: root %d%% used, inodes %d%% used", diskPct, inodePct),
@@ -43,6 +43,20 @@ func (c *dnsResolution) Command() string {
 done`
 }
 
+func (c *dnsResolution) CommandForOS(os pkgruntime.OS) string {
+	switch os {
+	case pkgruntime.OSWindows:
+		return `foreach ($domain in 'mcr.microsoft.com', 'eastus.data.mcr.microsoft.com', 'login.microsoftonline.com', 'packages.microsoft.com', 'packages.aks.azure.com') {
+  if (Resolve-DnsName $domain -ErrorAction SilentlyContinue) {
+    "${domain}:ok"
+  } else {
+    "${domain}:fail"
+  }
+}`
+	}
+	return ""
+}
+
 func (c *dnsResolution) Parse(res *pkgruntime.RunResult) (*Result, error) {
 	lines := strings.Split(strings.TrimSpace(res.Stdout), "\n")
 	var failures []string
@@ -0,0 +1,283 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/spf13/pflag"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newEgress())
+}
+
+// egressEndpoint is an endpoint the node must reach for AKS to work.
+// See https://learn.microsoft.com/en-us/azure/aks/outbound-rules-control-egress
+type egressEndpoint struct {
+	// Name identifies the endpoint in the command output.
+	Name string
+	// Host may reference the $region shell variable set on the node.
+	Host string
+	Port int
+	// UDP endpoints are probed with an NTP request instead of a TLS handshake.
+	UDP     bool
+	Purpose string
+}
+
+// apiServerEndpoint is the name of the API server endpoint, whose FQDN is
+// read from the kubelet kubeconfig on the node and whose certificate is
+// verified against the cluster CA.
+const apiServerEndpoint = "apiserver"
+
+// cloudHosts holds the hosts that differ between Azure clouds.
+type cloudHosts struct {
+	mcr string
+	arm string
+}
+
+// knownCloudHosts maps the Microsoft Entra authority host of each known cloud
+// to its endpoints.
+var knownCloudHosts = map[string]cloudHosts{
+	"login.microsoftonline.com": {mcr: "mcr.microsoft.com", arm: "management.azure.com"},
+	"login.microsoftonline.us":  {mcr: "mcr.microsoft.com", arm: "management.usgovcloudapi.net"},
+	"login.chinacloudapi.cn":    {mcr: "mcr.azure.cn", arm: "management.chinacloudapi.cn"},
+}
+
+// egressEndpoints returns the required outbound endpoints for the given cloud.
+func egressEndpoints(cfg cloud.Configuration) []egressEndpoint {
+	login := "login.microsoftonline.com"
+	if u, err := url.Parse(cfg.ActiveDirectoryAuthorityHost); err == nil && u.Hostname() != "" {
+		login = u.Hostname()
+	}
+	hosts, ok := knownCloudHosts[login]
+	if !ok {
+		hosts = knownCloudHosts["login.microsoftonline.com"]
+	}
+	if rm, ok := cfg.Services[cloud.ResourceManager]; ok {
+		if u, err := url.Parse(rm.Endpoint); err == nil && u.Hostname() != "" {
+			hosts.arm = u.Hostname()
+		}
+	}
+
+	endpoints := []egressEndpoint{
+		{Name: "mcr", Host: hosts.mcr, Port: 443, Purpose: "Microsoft Container Registry (MCR)"},
+		{Name: "mcr-data", Host: "${region}.data." + hosts.mcr, Port: 443, Purpose: "MCR storage backed by the Azure CDN"},
+		{Name: "login", Host: login, Port: 443, Purpose: "Microsoft Entra authentication"},
+		{Name: "arm", Host: hosts.arm, Port: 443, Purpose: "Azure Resource Manager"},
+		{Name: "packages", Host: "packages.microsoft.com", Port: 443, Purpose: "Packages (Moby, PowerShell, Azure CLI)"},
+	}
+	if login != "login.chinacloudapi.cn" {
+		endpoints = append(endpoints, egressEndpoint{
+			Name: "packages-aks", Host: "packages.aks.azure.com", Port: 443, Purpose: "AKS binaries (kubenet, Azure CNI)",
+		})
+	}
+	return append(endpoints,
+		egressEndpoint{Name: apiServerEndpoint, Host: "${apiserver_host}", Port: 443, Purpose: "Kubernetes API server"},
+		egressEndpoint{Name: "ntp", Host: "ntp.ubuntu.com", Port: 123, UDP: true, Purpose: "NTP time synchronization"},
+	)
+}
+
+type egress struct {
+	// connectTimeout is the per-endpoint connection timeout in seconds.
+	connectTimeout int
+	// expectedIssuers are the substrings one of which must be in the issuer
+	// of every public endpoint certificate. Anything else indicates a
+	// TLS-intercepting proxy.
+	expectedIssuers []string
+	// cloud is the Azure cloud whose endpoints are probed.
+	cloud cloud.Configuration
+}
+
+func newEgress() *egress {
+	return &egress{
+		connectTimeout:  5,
+		expectedIssuers: []string{"Microsoft", "DigiCert"},
+		cloud:           cloud.AzurePublic,
+	}
+}
+
+func (c *egress) Name() string { return "egress" }
+func (c *egress) Description() string {
+	return "Check TCP/TLS connectivity from the node to the required AKS outbound endpoints and NTP"
+}
+func (c *egress) Mode() Mode { return ModeVerify }
+
+func (c *egress) AddFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&c.connectTimeout, "connect-timeout", c.connectTimeout,
+		"Connection timeout in seconds for each endpoint")
+	fs.StringSliceVar(&c.expectedIssuers, "expected-issuers", c.expectedIssuers,
+		"Substrings expected in the certificate issuer of public endpoints, anything else is reported as TLS interception")
+}
+
+func (c *egress) SetEnvironment(env *Environment) {
+	c.cloud = env.Cloud
+}
+
+func (c *egress) endpoints() []egressEndpoint {
+	return egressEndpoints(c.cloud)
+}
+
+func (c *egress) Command() string {
+	// Each probe prints "endpoint:<name>|<host>|<port>/<proto>|<curl exit code>|<connect s>|<tls s>|<issuer>".
+	// The MCR data endpoint is regional, so the region is read from IMDS.
+	var b strings.Builder
+	fmt.Fprintf(&b, `region=$(curl -s -m 3 -H Metadata:true "http://169.254.169.254/metadata/instance/compute/location?api-version=2021-02-01&format=text")
+[ -n "$region" ] || region=eastus
+apiserver=$(awk '/server:/ {print $2; exit}' /var/lib/kubelet/kubeconfig 2>/dev/null)
+apiserver_host=${apiserver#https://}
+apiserver_host=${apiserver_host%%%%:*}
+probe() {
+  out=$(curl -sv -o /dev/null --connect-timeout %[1]d -m %[2]d $4 -w 'timing:%%{time_connect}|%%{time_appconnect}\n' "https://$2:$3/" 2>&1)
+  rc=$?
+  timing=$(echo "$out" | sed -n 's/^timing://p' | tail -1)
+  issuer=$(echo "$out" | sed -n 's/^\*  *issuer: *//p' | head -1)
+  echo "endpoint:$1|$2|$3/tcp|$rc|${timing:-|}|$issuer"
+}
+ntp() {
+  n=$( (printf '\033'; head -c 47 /dev/zero) | timeout %[2]d nc -u -w %[1]d "$2" "$3" 2>/dev/null | head -c 48 | wc -c)
+  rc=0; [ "$n" -ge 48 ] || rc=28
+  echo "endpoint:$1|$2|$3/udp|$rc|||"
+}
+`, c.connectTimeout, c.connectTimeout*2)
+	for _, ep := range c.endpoints() {
+		switch {
+		case ep.UDP:
+			fmt.Fprintf(&b, "ntp %s %s %d\n", ep.Name, ep.Host, ep.Port)
+		case ep.Name == apiServerEndpoint:
+			fmt.Fprintf(&b, "[ -n \"$apiserver_host\" ] && probe %s %s %d \"--cacert /etc/kubernetes/certs/ca.crt\"\n", ep.Name, ep.Host, ep.Port)
+		default:
+			fmt.Fprintf(&b, "probe %s %s %d\n", ep.Name, ep.Host, ep.Port)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// curlErrors maps curl exit codes to the connectivity problem they indicate.
+var curlErrors = map[int]string{
+	5:  "couldn't resolve proxy",
+	6:  "DNS resolution failed",
+	7:  "connection refused or blocked",
+	28: "timeout",
+	35: "TLS handshake failed",
+	52: "empty reply",
+	56: "connection reset",
+	60: "certificate verification failed",
+}
+
+// egressProbe is the result of probing a single endpoint.
+type egressProbe struct {
+	name    string
+	host    string
+	port    string
+	rc      int
+	connect string
+	tls     string
+	issuer  string
+}
+
+func parseEgressProbe(v string) (egressProbe, bool) {
+	fields := strings.SplitN(v, "|", 7)
+	if len(fields) != 7 {
+		return egressProbe{}, false
+	}
+	rc, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return egressProbe{}, false
+	}
+	return egressProbe{
+		name:    fields[0],
+		host:    fields[1],
+		port:    fields[2],
+		rc:      rc,
+		connect: formatSeconds(fields[4]),
+		tls:     formatSeconds(fields[5]),
+		issuer:  fields[6],
+	}, true
+}
+
+// formatSeconds formats a curl timing in seconds (e.g. "0.012345") as milliseconds.
+func formatSeconds(s string) string {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%dms", int(f*1000))
+}
+
+func (c *egress) trustedIssuer(issuer string) bool {
+	for _, e := range c.expectedIssuers {
+		if strings.Contains(issuer, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *egress) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	purposes := make(map[string]string)
+	for _, ep := range c.endpoints() {
+		purposes[ep.Name] = ep.Purpose
+	}
+
+	var probes []egressProbe
+	for _, line := range strings.Split(res.Stdout, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "endpoint:"); ok {
+			if p, ok := parseEgressProbe(v); ok {
+				probes = append(probes, p)
+			}
+		}
+	}
+	if len(probes) == 0 {
+		return nil, fmt.Errorf("couldn't parse egress output: %q", res.Stdout)
+	}
+
+	var failures []string
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ENDPOINT\tPORT\tCONNECT\tTLS\tSTATUS")
+	for _, p := range probes {
+		status := "ok"
+		switch {
+		case p.rc == 60:
+			status = fmt.Sprintf("%s, issuer %q (TLS interception?)", curlErrors[p.rc], p.issuer)
+		case p.rc != 0:
+			status = curlErrors[p.rc]
+			if status == "" {
+				status = fmt.Sprintf("failed (curl exit code %d)", p.rc)
+			}
+		case p.name != apiServerEndpoint && p.issuer != "" && !c.trustedIssuer(p.issuer):
+			status = fmt.Sprintf("unexpected issuer %q (TLS interception?)", p.issuer)
+		}
+		if status != "ok" {
+			failures = append(failures, p.host)
+			status = "✗ " + status
+			if purpose := purposes[p.name]; purpose != "" {
+				status += " — " + purpose
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.host, p.port, p.connect, p.tls, status)
+	}
+	w.Flush()
+
+	if len(failures) > 0 {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("Egress failed for %d/%d required endpoint(s): %s", len(failures), len(probes), strings.Join(failures, ", ")),
+			Details: strings.TrimRight(b.String(), "\n"),
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: fmt.Sprintf("Egress: all %d required endpoints reachable", len(probes)),
+		Details: strings.TrimRight(b.String(), "\n"),
+	}, nil
+}
@@ -0,0 +1,149 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newImagePull())
+}
+
+// defaultPullImage is a small image every AKS node is expected to be able to pull.
+const defaultPullImage = "mcr.microsoft.com/oss/kubernetes/pause:3.6"
+
+type imagePull struct {
+	image string
+}
+
+func newImagePull() *imagePull {
+	return &imagePull{image: defaultPullImage}
+}
+
+func (c *imagePull) Name() string { return "image-pull" }
+func (c *imagePull) Description() string {
+	return "Check that the node can pull a container image"
+}
+func (c *imagePull) Mode() Mode { return ModeVerify }
+
+func (c *imagePull) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.image, "image", c.image, "Image to pull")
+}
+
+// imageRegistry returns the registry host of an image reference, following
+// the same defaulting rules as containerd (no host means Docker Hub).
+func imageRegistry(ref string) string {
+	first, _, found := strings.Cut(ref, "/")
+	if !found || (!strings.ContainsAny(first, ".:") && first != "localhost") {
+		return "docker.io"
+	}
+	return first
+}
+
+func (c *imagePull) Command() string {
+	registry := imageRegistry(c.image)
+	endpoint := registry
+	if registry == "docker.io" {
+		endpoint = "registry-1.docker.io"
+	}
+	// containerd mirrors are configured in hosts.toml; report them because
+	// they change the endpoint the image is actually pulled from.
+	return fmt.Sprintf(`image=%[1]s
+registry=%[2]s
+endpoint=%[3]s
+echo "registry:$registry"
+hosts="/etc/containerd/certs.d/$registry/hosts.toml"
+[ -r "$hosts" ] && echo "mirror:$(grep -o '^\[host\."[^"]*"' "$hosts" | cut -d'"' -f2 | head -1)"
+echo "registry_probe:$(curl -s -o /dev/null -m 10 -w '%%{http_code}|%%{time_starttransfer}' "https://$endpoint/v2/")"
+if command -v crictl >/dev/null 2>&1; then
+  pull="crictl pull"
+else
+  pull="ctr -n k8s.io images pull"
+fi
+echo "client:$pull"
+start=$(date +%%s%%N)
+out=$($pull "$image" 2>&1)
+rc=$?
+end=$(date +%%s%%N)
+echo "pull_rc:$rc"
+echo "pull_ms:$(( (end - start) / 1000000 ))"
+[ $rc -ne 0 ] && echo "pull_error:$(echo "$out" | tail -1)"`,
+		pkgruntime.Quote(c.image), pkgruntime.Quote(registry), pkgruntime.Quote(endpoint))
+}
+
+// pullErrorCauses classifies image pull errors by the substrings found in
+// the error message, in order of precedence.
+var pullErrorCauses = []struct {
+	patterns []string
+	cause    string
+}{
+	{[]string{"429", "toomanyrequests", "too many requests"}, "registry throttling"},
+	{[]string{"401", "403", "unauthorized", "authentication required", "denied"}, "authentication failed"},
+	{[]string{"x509", "certificate", "tls:"}, "TLS error"},
+	{[]string{"no such host", "server misbehaving"}, "DNS resolution failed"},
+	{[]string{"i/o timeout", "deadline exceeded", "timeout"}, "timeout"},
+	{[]string{"connection refused", "connection reset", "network is unreachable"}, "connection failed"},
+	{[]string{"not found", "manifest unknown"}, "image not found"},
+}
+
+func classifyPullError(msg string) string {
+	lower := strings.ToLower(msg)
+	for _, c := range pullErrorCauses {
+		for _, p := range c.patterns {
+			if strings.Contains(lower, p) {
+				return c.cause
+			}
+		}
+	}
+	return "pull failed"
+}
+
+func (c *imagePull) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	values := parseKeyValues(res.Stdout)
+	rc, ok := intValue(values, "pull_rc")
+	if !ok {
+		return nil, fmt.Errorf("couldn't parse image-pull output: %q", res.Stdout)
+	}
+
+	details := []string{fmt.Sprintf("image: %s", c.image)}
+	endpoint := values["registry"]
+	if mirror := values["mirror"]; mirror != "" {
+		endpoint = fmt.Sprintf("%s (mirror %s)", endpoint, mirror)
+	}
+	details = append(details, fmt.Sprintf("registry: %s", endpoint))
+
+	if code, ttfb, found := strings.Cut(values["registry_probe"], "|"); found {
+		if code == "000" {
+			details = append(details, "registry /v2/: unreachable")
+		} else {
+			details = append(details, fmt.Sprintf("registry /v2/: HTTP %s, time to first byte %s", code, formatSeconds(ttfb)))
+		}
+	}
+	details = append(details, fmt.Sprintf("client: %s", values["client"]))
+
+	ms, _ := intValue(values, "pull_ms")
+	duration := strconv.FormatInt(ms, 10) + "ms"
+
+	if rc != 0 {
+		errMsg := values["pull_error"]
+		details = append(details, fmt.Sprintf("error: %s", errMsg))
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("Image pull failed: %s after %s", classifyPullError(errMsg), duration),
+			Details: strings.Join(details, "\n"),
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: fmt.Sprintf("Image pull succeeded in %s", duration),
+		Details: strings.Join(details, "\n"),
+	}, nil
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newKernelHealth())
+}
+
+type kernelHealth struct {
+	// since is the start of the window, in any format journalctl --since accepts.
+	since string
+	// samples is the number of messages shown per category.
+	samples int
+}
+
+func newKernelHealth() *kernelHealth {
+	return &kernelHealth{
+		since:   "24 hours ago",
+		samples: 2,
+	}
+}
+
+func (c *kernelHealth) Name() string { return "kernel-health" }
+func (c *kernelHealth) Description() string {
+	return "Check kernel logs for hung tasks, lockups, filesystem and I/O errors, NIC resets, MCEs and segfaults"
+}
+func (c *kernelHealth) Mode() Mode { return ModeVerify }
+
+func (c *kernelHealth) AddFlags(fs *pflag.FlagSet) {
+	fs.Var((*sinceValue)(&c.since), "since", `Start of the window (e.g. "1 hour ago", "2024-05-01 10:00")`)
+	fs.IntVar(&c.samples, "samples", c.samples, "Number of sample messages shown per category")
+}
+
+// sinceRe matches the times accepted by --since: the relative and absolute
+// times of journalctl, e.g. "1 hour ago", "-2h" or "2024-05-01 10:00:00".
+var sinceRe = regexp.MustCompile(`^[A-Za-z0-9 :.+-]+$`)
+
+// sinceValue is a --since flag value, validated when the flag is parsed.
+type sinceValue string
+
+func (v *sinceValue) String() string { return string(*v) }
+func (v *sinceValue) Type() string   { return "string" }
+
+func (v *sinceValue) Set(s string) error {
+	if !sinceRe.MatchString(s) {
+		return fmt.Errorf("invalid time %q: expected e.g. \"1 hour ago\" or \"2024-05-01 10:00\"", s)
+	}
+	*v = sinceValue(s)
+	return nil
+}
+
+// kernelSampleLen is the maximum length of a sample message, so that all
+// the samples fit in the RunCommand output limit.
+const kernelSampleLen = 200
+
+func (c *kernelHealth) Command() string {
+	// Without journald, dmesg only covers the kernel ring buffer and the
+	// window is ignored.
+	return fmt.Sprintf(`if command -v journalctl >/dev/null 2>&1; then
+  echo "source:journal"
+  journalctl -k --since %s -o short-iso --no-pager 2>/dev/null
+else
+  echo "source:dmesg"
+  dmesg --time-format iso 2>/dev/null
+fi | awk '%s'`, pkgruntime.Quote(c.since), logAwkProgram(kernelCategories, c.samples, kernelSampleLen))
+}
+
+func (c *kernelHealth) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	values := parseKeyValues(res.Stdout)
+	source, ok := values["source"]
+	if !ok {
+		return nil, fmt.Errorf("couldn't parse kernel-health output: %q", res.Stdout)
+	}
+
+	samples := make(map[string][]string)
+	for _, line := range strings.Split(res.Stdout, "\n") {
+		v, ok := strings.CutPrefix(strings.TrimSpace(line), "kmsg:")
+		if !ok {
+			continue
+		}
+		if category, msg, found := strings.Cut(v, "|"); found {
+			samples[category] = append(samples[category], msg)
+		}
+	}
+
+	var critical, other []string
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CATEGORY\tCOUNT\tDESCRIPTION")
+	for _, cat := range kernelCategories {
+		n, _ := intValue(values, "count_"+cat.name)
+		if n == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", cat.name, n, cat.description)
+		if cat.critical {
+			critical = append(critical, fmt.Sprintf("%d %s", n, cat.name))
+		} else {
+			other = append(other, fmt.Sprintf("%d %s", n, cat.name))
+		}
+	}
+	w.Flush()
+
+	window := "since " + c.since
+	if source == "dmesg" {
+		window = "in the kernel ring buffer"
+	}
+	if len(critical)+len(other) == 0 {
+		return &Result{
+			Success: true,
+			Message: fmt.Sprintf("Kernel healthy: no known problems logged %s", window),
+		}, nil
+	}
+
+	details := []string{strings.TrimRight(b.String(), "\n")}
+	for _, cat := range kernelCategories {
+		for _, msg := range samples[cat.name] {
+			details = append(details, fmt.Sprintf("[%s] %s", cat.name, msg))
+		}
+	}
+
+	if len(critical) > 0 {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("Kernel errors %s: %s", window, strings.Join(append(critical, other...), ", ")),
+			Details: strings.Join(details, "\n"),
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: fmt.Sprintf("Kernel healthy: no critical errors %s (%s)", window, strings.Join(other, ", ")),
+		Details: strings.Join(details, "\n"),
+	}, nil
+}
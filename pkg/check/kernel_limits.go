@@ -0,0 +1,288 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newKernelLimits())
+}
+
+type kernelLimits struct {
+	// fdThreshold is the file descriptor usage (percent) considered as
+	// exhaustion, node-wide against file-max and per process against its
+	// RLIMIT_NOFILE.
+	fdThreshold int
+	// pidThreshold is the PID usage (percent) considered as exhaustion,
+	// node-wide against pid_max and per pod against its pids.max.
+	pidThreshold int
+	// inotifyThreshold is the inotify instances or watches usage (percent)
+	// of a user considered as exhaustion.
+	inotifyThreshold int
+	// zombieThreshold is the number of zombie processes considered as a leak.
+	zombieThreshold int
+	// top is the number of top processes and pods reported per metric.
+	top int
+}
+
+func newKernelLimits() *kernelLimits {
+	return &kernelLimits{
+		fdThreshold:      80,
+		pidThreshold:     80,
+		inotifyThreshold: 80,
+		zombieThreshold:  100,
+		top:              3,
+	}
+}
+
+func (c *kernelLimits) Name() string { return "kernel-limits" }
+func (c *kernelLimits) Description() string {
+	return "Check file descriptor, PID and inotify usage against kernel and kubelet limits, and zombie processes"
+}
+func (c *kernelLimits) Mode() Mode { return ModeVerify }
+
+func (c *kernelLimits) AddFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&c.fdThreshold, "fd-threshold", c.fdThreshold,
+		"File descriptor usage (percent of file-max, or of a process's open files limit) considered as exhaustion")
+	fs.IntVar(&c.pidThreshold, "pid-threshold", c.pidThreshold,
+		"PID usage (percent of pid_max, or of a pod's PID limit) considered as exhaustion")
+	fs.IntVar(&c.inotifyThreshold, "inotify-threshold", c.inotifyThreshold,
+		"inotify instances or watches usage (percent of the per-user limits) considered as exhaustion")
+	fs.IntVar(&c.zombieThreshold, "zombie-threshold", c.zombieThreshold, "Number of zombie processes considered as a leak")
+	fs.IntVar(&c.top, "top", c.top, "Number of top processes and pods reported per metric")
+}
+
+func (c *kernelLimits) Command() string {
+	// Process lines are "<metric>:<numbers...> <pid> <pod|-> <comm>", with the
+	// command last because it may contain spaces. Pods are found from the
+	// process cgroup and named through /var/log/pods.
+	return fmt.Sprintf(`podname() {
+  p=$(ls /var/log/pods 2>/dev/null | grep -- "_$1$" | head -1)
+  echo "${p:-$1}"
+}
+podof() {
+  uid=$(grep -oE 'pod[0-9a-f_-]{36}' /proc/$1/cgroup 2>/dev/null | head -1 | cut -c4- | tr _ -)
+  if [ -n "$uid" ]; then podname "$uid"; else echo -; fi
+}
+proc() {
+  echo "$1:$2 $3 $(podof $3) $(cat /proc/$3/comm 2>/dev/null)"
+}
+echo "file_nr:$(awk '{print $1 " " $3}' /proc/sys/fs/file-nr)"
+for p in /proc/[0-9]*; do
+  echo "$(ls $p/fd 2>/dev/null | wc -l) ${p#/proc/}"
+done | sort -rn | head -%[1]d | while read n pid; do
+  limit=$(awk '/^Max open files/ {print ($4 ~ /^[0-9]+$/) ? $4 : 0}' /proc/$pid/limits 2>/dev/null)
+  proc proc_fd "$n ${limit:-0}" $pid
+done
+echo "pids:$(awk '{split($4, a, "/"); print a[2]}' /proc/loadavg) $(cat /proc/sys/kernel/pid_max) $(cat /proc/sys/kernel/threads-max)"
+kubelet_args=$(tr '\0' ' ' < /proc/$(pgrep -o kubelet)/cmdline 2>/dev/null)
+limit=$(echo "$kubelet_args" | grep -o -- '--pod-max-pids=[^ ]*' | head -1 | cut -d= -f2-)
+config=$(echo "$kubelet_args" | grep -o -- '--config=[^ ]*' | head -1 | cut -d= -f2-)
+[ -z "$limit" ] && [ -r "$config" ] && limit=$(tr -d ' \n\t' < "$config" | grep -o '"podPidsLimit":-\?[0-9]*' | cut -d: -f2)
+echo "kubelet_pod_pids_limit:${limit:--1}"
+find /sys/fs/cgroup -maxdepth 6 -path '*kubepods*' -name pids.current 2>/dev/null |
+  grep -E 'pod[0-9a-f_-]+(\.slice)?/pids\.current$' | while read f; do
+  uid=$(echo "$f" | sed -E 's#.*pod([0-9a-f_-]+)(\.slice)?/pids\.current#\1#' | tr _ -)
+  echo "$(cat "$f") $(cat "${f%%current}max") $uid"
+done | sort -rn | head -%[1]d | while read cur max uid; do
+  echo "pod_pids:$cur $max $(podname $uid)"
+done
+echo "inotify_limits:$(cat /proc/sys/fs/inotify/max_user_instances) $(cat /proc/sys/fs/inotify/max_user_watches)"
+inotify=$(find /proc/[0-9]*/fd -lname anon_inode:inotify 2>/dev/null | while read f; do
+  pid=${f#/proc/}; pid=${pid%%%%/*}
+  echo "$pid $(grep -c '^inotify' /proc/$pid/fdinfo/${f##*/} 2>/dev/null) $(awk '/^Uid:/ {print $2}' /proc/$pid/status 2>/dev/null)"
+done)
+echo "$inotify" | awk 'NF == 3 {i[$3]++; w[$3] += $2} END {for (u in i) print "inotify_user:" u " " i[u] " " w[u]}'
+echo "$inotify" | awk 'NF == 3 {i[$1]++; w[$1] += $2} END {for (p in i) print w[p] " " i[p] " " p}' |
+  sort -rn | head -%[1]d | while read w i pid; do
+  proc inotify_proc "$w $i" $pid
+done
+zombies=$(ps -eo stat=,ppid= 2>/dev/null | awk '$1 ~ /^Z/ {print $2}')
+echo "zombies:$(echo "$zombies" | grep -c .)"
+echo "$zombies" | grep . | sort | uniq -c | sort -rn | head -%[1]d | while read n ppid; do
+  proc zombie_parent $n $ppid
+done`, c.top)
+}
+
+// limitProc is a process reported by the command with its metric values.
+type limitProc struct {
+	values []int64
+	pid    string
+	pod    string
+	comm   string
+}
+
+// parseLimitProc parses "<n values...> <pid> <pod|-> <comm>".
+func parseLimitProc(v string, n int) (limitProc, bool) {
+	fields := strings.SplitN(v, " ", n+3)
+	if len(fields) < n+2 {
+		return limitProc{}, false
+	}
+	p := limitProc{pid: fields[n], pod: formatPodLogDir(fields[n+1])}
+	if len(fields) == n+3 {
+		p.comm = fields[n+2]
+	}
+	for _, f := range fields[:n] {
+		v, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return limitProc{}, false
+		}
+		p.values = append(p.values, v)
+	}
+	return p, true
+}
+
+// prefixedLines returns the values of the lines starting with "<prefix>:".
+func prefixedLines(stdout, prefix string) []string {
+	var out []string
+	for _, line := range strings.Split(stdout, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), prefix+":"); ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// int64Fields parses the space separated integers of a value.
+func int64Fields(v string) ([]int64, bool) {
+	var out []int64
+	for _, f := range strings.Fields(v) {
+		n, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		out = append(out, n)
+	}
+	return out, true
+}
+
+func (c *kernelLimits) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	values := parseKeyValues(res.Stdout)
+	fileNr, ok := int64Fields(values["file_nr"])
+	if !ok || len(fileNr) != 2 {
+		return nil, fmt.Errorf("couldn't parse kernel-limits output: %q", res.Stdout)
+	}
+
+	var issues []string
+	var details []string
+	table := func(header string, rows [][]string) {
+		if len(rows) == 0 {
+			return
+		}
+		var b strings.Builder
+		w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, header)
+		for _, r := range rows {
+			fmt.Fprintln(w, strings.Join(r, "\t"))
+		}
+		w.Flush()
+		details = append(details, strings.TrimRight(b.String(), "\n"))
+	}
+
+	// File descriptors
+	details = append(details, fmt.Sprintf("file descriptors: %d/%d (%d%%)", fileNr[0], fileNr[1], percent(fileNr[0], fileNr[1])))
+	if percent(fileNr[0], fileNr[1]) >= c.fdThreshold {
+		issues = append(issues, fmt.Sprintf("file descriptors %d/%d", fileNr[0], fileNr[1]))
+	}
+	var rows [][]string
+	for _, v := range prefixedLines(res.Stdout, "proc_fd") {
+		p, ok := parseLimitProc(v, 2)
+		if !ok {
+			continue
+		}
+		fds, limit := p.values[0], p.values[1]
+		rows = append(rows, []string{p.pid, p.pod, p.comm, strconv.FormatInt(fds, 10), strconv.FormatInt(limit, 10)})
+		if limit > 0 && percent(fds, limit) >= c.fdThreshold {
+			issues = append(issues, fmt.Sprintf("%s (pid %s, pod %s) has %d/%d open files", p.comm, p.pid, p.pod, fds, limit))
+		}
+	}
+	table("PID\tPOD\tCOMMAND\tFDS\tLIMIT", rows)
+
+	// PIDs
+	if pids, ok := int64Fields(values["pids"]); ok && len(pids) == 3 {
+		limit := min(pids[1], pids[2])
+		details = append(details, fmt.Sprintf("pids: %d/%d (%d%%), kubelet podPidsLimit: %s", pids[0], limit, percent(pids[0], limit), values["kubelet_pod_pids_limit"]))
+		if percent(pids[0], limit) >= c.pidThreshold {
+			issues = append(issues, fmt.Sprintf("pids %d/%d", pids[0], limit))
+		}
+	}
+	rows = nil
+	for _, v := range prefixedLines(res.Stdout, "pod_pids") {
+		fields := strings.Fields(v)
+		if len(fields) != 3 {
+			continue
+		}
+		pod := formatPodLogDir(fields[2])
+		rows = append(rows, []string{pod, fields[0], fields[1]})
+		cur, err1 := strconv.ParseInt(fields[0], 10, 64)
+		limit, err2 := strconv.ParseInt(fields[1], 10, 64)
+		if err1 == nil && err2 == nil && percent(cur, limit) >= c.pidThreshold {
+			issues = append(issues, fmt.Sprintf("pod %s uses %d/%d pids", pod, cur, limit))
+		}
+	}
+	table("POD\tPIDS\tLIMIT", rows)
+
+	// inotify
+	if limits, ok := int64Fields(values["inotify_limits"]); ok && len(limits) == 2 {
+		details = append(details, fmt.Sprintf("inotify: per-user limits %d instances, %d watches", limits[0], limits[1]))
+		rows = nil
+		for _, v := range prefixedLines(res.Stdout, "inotify_user") {
+			u, ok := int64Fields(v)
+			if !ok || len(u) != 3 {
+				continue
+			}
+			rows = append(rows, []string{strconv.FormatInt(u[0], 10), strconv.FormatInt(u[1], 10), strconv.FormatInt(u[2], 10)})
+			if percent(u[1], limits[0]) >= c.inotifyThreshold {
+				issues = append(issues, fmt.Sprintf("uid %d uses %d/%d inotify instances", u[0], u[1], limits[0]))
+			}
+			if percent(u[2], limits[1]) >= c.inotifyThreshold {
+				issues = append(issues, fmt.Sprintf("uid %d uses %d/%d inotify watches", u[0], u[2], limits[1]))
+			}
+		}
+		table("UID\tINSTANCES\tWATCHES", rows)
+	}
+	rows = nil
+	for _, v := range prefixedLines(res.Stdout, "inotify_proc") {
+		if p, ok := parseLimitProc(v, 2); ok {
+			rows = append(rows, []string{p.pid, p.pod, p.comm, strconv.FormatInt(p.values[1], 10), strconv.FormatInt(p.values[0], 10)})
+		}
+	}
+	table("PID\tPOD\tCOMMAND\tINSTANCES\tWATCHES", rows)
+
+	// Zombies
+	zombies, _ := intValue(values, "zombies")
+	details = append(details, fmt.Sprintf("zombies: %d", zombies))
+	if zombies >= int64(c.zombieThreshold) {
+		issues = append(issues, fmt.Sprintf("%d zombie processes", zombies))
+	}
+	rows = nil
+	for _, v := range prefixedLines(res.Stdout, "zombie_parent") {
+		if p, ok := parseLimitProc(v, 1); ok {
+			rows = append(rows, []string{p.pid, p.pod, p.comm, strconv.FormatInt(p.values[0], 10)})
+		}
+	}
+	table("PARENT PID\tPOD\tCOMMAND\tZOMBIES", rows)
+
+	if len(issues) > 0 {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("Kernel limits: %s", strings.Join(issues, "; ")),
+			Details: strings.Join(details, "\n"),
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: "Kernel limits OK: file descriptors, PIDs and inotify within limits",
+		Details: strings.Join(details, "\n"),
+	}, nil
+}
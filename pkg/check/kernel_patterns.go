@@ -0,0 +1,81 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+// kernelCategories is the kernel message pattern library, in matching order:
+// a message is counted in the first category it matches.
+var kernelCategories = compileLogCategories([]logCategory{
+	{
+		name:        "mce",
+		description: "machine check / memory hardware errors",
+		critical:    true,
+		patterns: []string{
+			`\[Hardware Error\]`,
+			`Machine check events logged`,
+			`EDAC [A-Za-z0-9]+: [0-9]+ (CE|UE) `,
+		},
+	},
+	{
+		name:        "hung_task",
+		description: "tasks blocked in uninterruptible sleep",
+		critical:    true,
+		patterns: []string{
+			`INFO: task .* blocked for more than [0-9]+ seconds`,
+		},
+	},
+	{
+		name:        "soft_lockup",
+		description: "CPU stuck in kernel mode",
+		critical:    true,
+		patterns: []string{
+			`BUG: soft lockup - CPU#[0-9]+ stuck`,
+			`rcu_(sched|preempt) (self-)?detected stalls`,
+		},
+	},
+	{
+		name:        "hard_lockup",
+		description: "CPU stuck with interrupts disabled",
+		critical:    true,
+		patterns: []string{
+			`Watchdog detected hard LOCKUP on cpu [0-9]+`,
+		},
+	},
+	{
+		name:        "fs_error",
+		description: "EXT4/XFS filesystem errors",
+		critical:    true,
+		patterns: []string{
+			`EXT4-fs (error|warning) \(device [^)]*\)`,
+			`EXT4-fs \([^)]*\): (Remounting filesystem read-only|I/O error|failed to convert|error count since last fsck)`,
+			`XFS \([^)]*\): (Corruption|Internal error|metadata I/O error|log I/O error|Filesystem has been shut down|writeback error)`,
+		},
+	},
+	{
+		name:        "io_error",
+		description: "block device I/O errors on sd*/nvme*",
+		critical:    true,
+		patterns: []string{
+			`I/O error,? (on )?dev (sd[a-z]+|nvme[0-9]+n[0-9]+)`,
+			`\[sd[a-z]+\] .*FAILED Result`,
+			`nvme nvme[0-9]+: (I/O [0-9]+ QID [0-9]+ timeout|controller is down|Device not ready|Removing after probe failure)`,
+		},
+	},
+	{
+		name:        "nic_reset",
+		description: "NIC resets and accelerated networking VF changes",
+		patterns: []string{
+			`NETDEV WATCHDOG: .* transmit queue [0-9]+ timed out`,
+			`hv_netvsc .*(VF slot [0-9]+ removed|Data path switched from VF|unable to send|nvsp_rndis_pkt_complete error)`,
+			`mlx5_core .*(TX timeout|health compromised|health poll failed|cmd_work_handler.*timeout|Fatal)`,
+		},
+	},
+	{
+		name:        "segfault",
+		description: "process crashes",
+		patterns: []string{
+			`segfault at [0-9a-f]+ ip`,
+			`traps: .* general protection`,
+		},
+	},
+})
@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var kernelFixtures = map[string]map[string]int64{
+	"healthy.log": {},
+	"disk.log":    {"io_error": 5, "fs_error": 4, "hung_task": 2},
+	"cpu.log":     {"soft_lockup": 3, "hard_lockup": 1, "mce": 2, "segfault": 2},
+	"network.log": {"nic_reset": 5},
+}
+
+func TestClassifyKernelMessages(t *testing.T) {
+	for file, want := range kernelFixtures {
+		t.Run(file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", "kernel", file))
+			require.NoError(t, err)
+			defer f.Close()
+
+			got := make(map[string]int64)
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				if category := classifyLogMessage(kernelCategories, scanner.Text()); category != "" {
+					got[category]++
+				}
+			}
+			require.NoError(t, scanner.Err())
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestLogAwkProgram(t *testing.T) {
+	awk, err := exec.LookPath("awk")
+	if err != nil {
+		t.Skip("awk not available")
+	}
+	for file, want := range kernelFixtures {
+		t.Run(file, func(t *testing.T) {
+			out, err := exec.Command(awk, logAwkProgram(kernelCategories, 1, 80), filepath.Join("testdata", "kernel", file)).Output()
+			require.NoError(t, err)
+
+			values := parseKeyValues(string(out))
+			for _, c := range kernelCategories {
+				n, ok := intValue(values, "count_"+c.name)
+				require.True(t, ok, "missing count for %s", c.name)
+				assert.Equal(t, want[c.name], n, c.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newKubelet())
+}
+
+// kubeletCategories are the kubelet journal errors reported by the check.
+var kubeletCategories = compileLogCategories([]logCategory{
+	{
+		name:        "pleg",
+		description: "pod lifecycle event generator unhealthy, the runtime is slow or stuck",
+		critical:    true,
+		patterns: []string{
+			`PLEG is not healthy`,
+			`pleg was last seen active`,
+		},
+	},
+	{
+		name:        "certificate",
+		description: "client or serving certificate errors",
+		critical:    true,
+		patterns: []string{
+			`x509: certificate has expired`,
+			`[Ff]ailed while requesting a signed certificate`,
+			`certificate_manager.go.*[Ff]ailed`,
+			`[Cc]ertificate rotation.*(fail|error)`,
+		},
+	},
+	{
+		name:        "eviction",
+		description: "pods evicted because of node pressure",
+		critical:    true,
+		patterns: []string{
+			`[Ee]viction manager: (attempting to reclaim|must evict pod|pods? .*evicted|unable to evict)`,
+		},
+	},
+})
+
+// kubeletSettings are the kubelet config fields compared across the nodes of
+// a pool, with the flag overriding each of them.
+var kubeletSettings = []struct {
+	config string
+	flag   string
+}{
+	{"maxPods", "max-pods"},
+	{"evictionHard", "eviction-hard"},
+	{"cgroupDriver", "cgroup-driver"},
+	{"serializeImagePulls", "serialize-image-pulls"},
+}
+
+// kubeletFlagsLen is the maximum length of the kubelet flags reported, so
+// they fit in the RunCommand output limit with the other values.
+const kubeletFlagsLen = 1200
+
+type kubelet struct {
+	// since is the start of the journal window, in any format journalctl
+	// --since accepts.
+	since string
+}
+
+func newKubelet() *kubelet {
+	return &kubelet{since: "1 hour ago"}
+}
+
+func (c *kubelet) Name() string { return "kubelet" }
+func (c *kubelet) Description() string {
+	return "Check kubelet health, configuration and recent PLEG, certificate and eviction errors"
+}
+func (c *kubelet) Mode() Mode { return ModeVerify }
+
+func (c *kubelet) AddFlags(fs *pflag.FlagSet) {
+	fs.Var((*sinceValue)(&c.since), "since", `Start of the journal window (e.g. "30 min ago", "2024-05-01 10:00")`)
+}
+
+func (c *kubelet) Command() string {
+	// /configz requires authentication: the kubelet client certificate is
+	// used, falling back to the --config file (assumed to be JSON, as
+	// written by AKS) when it's rejected.
+	var flags, settings []string
+	for _, s := range kubeletSettings {
+		flags = append(flags, s.flag)
+		if s.config != "evictionHard" {
+			settings = append(settings, s.config)
+		}
+	}
+	return fmt.Sprintf(`pid=$(pgrep -o -x kubelet)
+[ -n "$pid" ] || { echo "kubelet:not-running"; exit 0; }
+echo "version:$($(readlink /proc/$pid/exe) --version 2>/dev/null)"
+echo "healthz:$(curl -s -m 5 http://127.0.0.1:10248/healthz 2>&1 | head -c 100)"
+args=$(tr '\0' '\n' < /proc/$pid/cmdline | tail -n +2)
+echo "flags:$(echo $args | cut -c1-%[1]d)"
+for f in %[2]s; do
+  v=$(echo "$args" | grep -- "^--$f=" | tail -1 | cut -d= -f2-)
+  [ -n "$v" ] && echo "flag_$f:$v"
+done
+pem=/var/lib/kubelet/pki/kubelet-client-current.pem
+cfg=$(curl -sk -m 5 --cert $pem --key $pem https://127.0.0.1:10250/configz 2>/dev/null)
+if echo "$cfg" | grep -q kubeletconfig; then
+  echo "config_source:configz"
+else
+  file=$(echo "$args" | grep -- '^--config=' | tail -1 | cut -d= -f2-)
+  cfg=$(cat "$file" 2>/dev/null)
+  echo "config_source:${file:-none}"
+fi
+cfg=$(echo "$cfg" | tr -d ' \n\t')
+for k in %[3]s; do
+  v=$(echo "$cfg" | grep -o "\"$k\":[^,}]*" | head -1 | cut -d: -f2- | tr -d '"')
+  [ -n "$v" ] && echo "config_$k:$v"
+done
+echo "$cfg" | grep -o '"evictionHard":{[^}]*}' | head -1 | sed 's/^"evictionHard":/config_evictionHard:/'
+journalctl -u kubelet --since %[4]s -o short-iso --no-pager 2>/dev/null | awk '%[5]s'`,
+		kubeletFlagsLen, strings.Join(flags, " "), strings.Join(settings, " "), pkgruntime.Quote(c.since),
+		logAwkProgram(kubeletCategories, 2, kernelSampleLen))
+}
+
+// normalizeEvictionHard formats an evictionHard config map (JSON) or an
+// --eviction-hard flag value as sorted "signal<value" items, so both forms
+// compare equal.
+func normalizeEvictionHard(v string) string {
+	var items []string
+	var m map[string]string
+	if err := json.Unmarshal([]byte(v), &m); err == nil {
+		for signal, threshold := range m {
+			items = append(items, signal+"<"+threshold)
+		}
+	} else {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+func (c *kubelet) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	values := parseKeyValues(res.Stdout)
+	if values["kubelet"] == "not-running" {
+		return &Result{
+			Success: false,
+			Message: "Kubelet is not running",
+		}, nil
+	}
+	if _, ok := values["healthz"]; !ok {
+		return nil, fmt.Errorf("couldn't parse kubelet output: %q", res.Stdout)
+	}
+
+	var issues []string
+	var details []string
+
+	version := strings.TrimPrefix(values["version"], "Kubernetes ")
+	if version == "" {
+		version = "(unknown version)"
+	}
+	if healthz := values["healthz"]; healthz != "ok" {
+		if healthz == "" {
+			healthz = "no response"
+		}
+		issues = append(issues, fmt.Sprintf("healthz: %s", healthz))
+	}
+
+	// Settings: /configz reports the effective values, while the flags
+	// override the config file.
+	source := values["config_source"]
+	facts := make(map[string]string)
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+	for _, s := range kubeletSettings {
+		v, from := values["config_"+s.config], source
+		if flag, ok := values["flag_"+s.flag]; ok && source != "configz" {
+			v, from = flag, "--"+s.flag
+		}
+		if v == "" {
+			continue
+		}
+		if s.config == "evictionHard" {
+			v = normalizeEvictionHard(v)
+		}
+		facts[s.config] = v
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.config, v, from)
+	}
+	w.Flush()
+	details = append(details, strings.TrimRight(b.String(), "\n"))
+	if flags := values["flags"]; flags != "" {
+		if len(flags) >= kubeletFlagsLen {
+			flags += " ..."
+		}
+		details = append(details, "flags: "+flags)
+	}
+
+	// Journal
+	for _, cat := range kubeletCategories {
+		if n, _ := intValue(values, "count_"+cat.name); n > 0 {
+			issues = append(issues, fmt.Sprintf("%d %s error(s) since %s", n, cat.name, c.since))
+		}
+	}
+	for _, v := range prefixedLines(res.Stdout, "kmsg") {
+		if category, msg, found := strings.Cut(v, "|"); found {
+			details = append(details, fmt.Sprintf("[%s] %s", category, msg))
+		}
+	}
+
+	if len(issues) > 0 {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("Kubelet %s: %s", version, strings.Join(issues, "; ")),
+			Details: strings.Join(details, "\n"),
+			Facts:   facts,
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: fmt.Sprintf("Kubelet %s healthy", version),
+		Details: strings.Join(details, "\n"),
+		Facts:   facts,
+	}, nil
+}
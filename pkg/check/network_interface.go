@@ -0,0 +1,269 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newNetworkInterface())
+}
+
+type networkInterface struct {
+	// peer is an optional additional host to probe the path MTU to.
+	peer string
+	// pingSizes are the IP packet sizes probed with the DF bit set, in
+	// addition to the MTU of the outgoing interface.
+	pingSizes []int
+	// interval is the delay in seconds between the two counter samples.
+	interval int
+}
+
+func newNetworkInterface() *networkInterface {
+	return &networkInterface{
+		pingSizes: []int{1500, 1450, 1400, 1280},
+		interval:  5,
+	}
+}
+
+func (c *networkInterface) Name() string { return "network-interface" }
+func (c *networkInterface) Description() string {
+	return "Check network interface MTU, link state and error counters, and the path MTU to the API server"
+}
+func (c *networkInterface) Mode() Mode { return ModeVerify }
+
+func (c *networkInterface) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.peer, "peer", c.peer, "Additional host (e.g. a VM in a peered VNet) to probe the path MTU to")
+	fs.IntSliceVar(&c.pingSizes, "ping-sizes", c.pingSizes, "IP packet sizes to probe with the don't-fragment bit set")
+	fs.IntVar(&c.interval, "interval", c.interval,
+		"Seconds between the two samples used to detect rising error counters (0 to sample once)")
+}
+
+// podInterfacePrefixes are the prefixes of the per-pod interfaces created by
+// the CNI plugins. They are only reported when they have errors or drops, to
+// keep the output small on nodes with many pods.
+var podInterfacePrefixes = []string{"azv", "lxc", "veth", "cali"}
+
+func (c *networkInterface) Command() string {
+	var sizes []string
+	for _, s := range c.pingSizes {
+		sizes = append(sizes, strconv.Itoa(s))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `sample() {
+  echo "sample:$1"
+  for i in /sys/class/net/*; do
+    n=${i##*/}
+    [ "$n" = lo ] && continue
+    s=$i/statistics
+    counters="$(cat $s/rx_errors) $(cat $s/tx_errors) $(cat $s/rx_dropped) $(cat $s/tx_dropped)"
+    case "$n" in %s*) [ "$counters" = "0 0 0 0" ] && continue ;; esac
+    echo "iface_$n:$(cat $i/mtu) $(cat $i/operstate) $counters"
+  done
+}
+probe() {
+  ip=$(getent ahostsv4 "$2" | awk 'NR==1 {print $1}')
+  [ -n "$ip" ] || { echo "ping:$1|$2|||unresolved"; return; }
+  dev=$(ip route get "$ip" 2>/dev/null | grep -o 'dev [^ ]*' | cut -d' ' -f2)
+  mtu=$(cat /sys/class/net/$dev/mtu 2>/dev/null)
+  res=""
+  tested=" "
+  for s in $mtu %s; do
+    [ "$s" -gt "${mtu:-1500}" ] && continue
+    case "$tested" in *" $s "*) continue ;; esac
+    tested="$tested$s "
+    if ping -c 1 -W 2 -M do -s $((s - 28)) "$ip" >/dev/null 2>&1; then res="$res $s:ok"; else res="$res $s:fail"; fi
+  done
+  echo "ping:$1|$2|$dev|$mtu|${res# }"
+}
+apiserver=$(awk '/server:/ {print $2; exit}' /var/lib/kubelet/kubeconfig 2>/dev/null)
+apiserver_host=${apiserver#https://}
+apiserver_host=${apiserver_host%%%%:*}
+sample 1
+[ -n "$apiserver_host" ] && probe apiserver "$apiserver_host"
+`, strings.Join(podInterfacePrefixes, "*|"), strings.Join(sizes, " "))
+	if c.peer != "" {
+		fmt.Fprintf(&b, "probe peer %s\n", pkgruntime.Quote(c.peer))
+	}
+	if c.interval > 0 {
+		fmt.Fprintf(&b, "sleep %d\nsample 2\n", c.interval)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// ifaceStats are the counters of a network interface.
+type ifaceStats struct {
+	mtu      int
+	state    string
+	counters [4]int64 // rx_errors, tx_errors, rx_dropped, tx_dropped
+}
+
+var ifaceCounterNames = [4]string{"rx_errors", "tx_errors", "rx_dropped", "tx_dropped"}
+
+func parseIfaceStats(v string) (ifaceStats, bool) {
+	fields := strings.Fields(v)
+	if len(fields) != 6 {
+		return ifaceStats{}, false
+	}
+	var st ifaceStats
+	var err error
+	if st.mtu, err = strconv.Atoi(fields[0]); err != nil {
+		return ifaceStats{}, false
+	}
+	st.state = fields[1]
+	for i := range st.counters {
+		if st.counters[i], err = strconv.ParseInt(fields[2+i], 10, 64); err != nil {
+			return ifaceStats{}, false
+		}
+	}
+	return st, true
+}
+
+func ifaceSample(values map[string]string) map[string]ifaceStats {
+	out := make(map[string]ifaceStats)
+	for k, v := range values {
+		name, ok := strings.CutPrefix(k, "iface_")
+		if !ok {
+			continue
+		}
+		if st, ok := parseIfaceStats(v); ok {
+			out[name] = st
+		}
+	}
+	return out
+}
+
+// pathMTUProbe is the result of the DF-bit pings to a target.
+type pathMTUProbe struct {
+	name, host, dev string
+	mtu             int
+	// largest is the largest packet size that got a reply, 0 if none did.
+	largest int
+	failed  []int
+	err     string
+}
+
+func parsePathMTUProbe(v string) (pathMTUProbe, bool) {
+	fields := strings.SplitN(v, "|", 5)
+	if len(fields) != 5 {
+		return pathMTUProbe{}, false
+	}
+	p := pathMTUProbe{name: fields[0], host: fields[1], dev: fields[2]}
+	p.mtu, _ = strconv.Atoi(fields[3])
+	for _, r := range strings.Fields(fields[4]) {
+		size, status, found := strings.Cut(r, ":")
+		n, err := strconv.Atoi(size)
+		if !found || err != nil {
+			p.err = r
+			continue
+		}
+		if status == "ok" {
+			if n > p.largest {
+				p.largest = n
+			}
+		} else {
+			p.failed = append(p.failed, n)
+		}
+	}
+	return p, true
+}
+
+func (c *networkInterface) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	samples := parseSamples(res.Stdout)
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("couldn't parse network-interface output: %q", res.Stdout)
+	}
+	first := ifaceSample(samples[0])
+	last := ifaceSample(samples[len(samples)-1])
+
+	var issues []string
+	var details []string
+
+	names := make([]string, 0, len(last))
+	for n := range last {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INTERFACE\tMTU\tSTATE\tRX_ERRORS\tTX_ERRORS\tRX_DROPPED\tTX_DROPPED")
+	for _, n := range names {
+		st := last[n]
+		// Pod interfaces are omitted from a sample while their counters are
+		// zero, so a missing first sample means all counters were zero.
+		before := first[n]
+		var cols [4]string
+		var rising []string
+		for i, v := range st.counters {
+			cols[i] = strconv.FormatInt(v, 10)
+			if len(samples) > 1 && v > before.counters[i] {
+				cols[i] += fmt.Sprintf(" (+%d)", v-before.counters[i])
+				if i < 2 {
+					rising = append(rising, ifaceCounterNames[i])
+				}
+			}
+		}
+		if len(rising) > 0 {
+			issues = append(issues, fmt.Sprintf("%s %s rising", n, strings.Join(rising, "/")))
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", n, st.mtu, st.state, cols[0], cols[1], cols[2], cols[3])
+	}
+	w.Flush()
+	details = append(details, strings.TrimRight(b.String(), "\n"))
+
+	for _, line := range strings.Split(res.Stdout, "\n") {
+		v, ok := strings.CutPrefix(strings.TrimSpace(line), "ping:")
+		if !ok {
+			continue
+		}
+		p, ok := parsePathMTUProbe(v)
+		if !ok {
+			continue
+		}
+		switch {
+		case p.err != "":
+			details = append(details, fmt.Sprintf("path MTU to %s (%s): %s", p.name, p.host, p.err))
+		case p.largest == 0:
+			details = append(details, fmt.Sprintf("path MTU to %s (%s): unknown, no ICMP reply at any size", p.name, p.host))
+		case p.largest < p.mtu:
+			issues = append(issues, fmt.Sprintf("path MTU to %s is %d, lower than %s MTU %d", p.name, p.largest, p.dev, p.mtu))
+			details = append(details, fmt.Sprintf("path MTU to %s (%s): %d via %s (MTU %d), failed sizes: %s",
+				p.name, p.host, p.largest, p.dev, p.mtu, joinInts(p.failed)))
+		default:
+			details = append(details, fmt.Sprintf("path MTU to %s (%s): %d via %s (MTU %d)", p.name, p.host, p.largest, p.dev, p.mtu))
+		}
+	}
+
+	if len(issues) > 0 {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("Network interfaces: %s", strings.Join(issues, "; ")),
+			Details: strings.Join(details, "\n"),
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: fmt.Sprintf("Network interfaces OK: %d interface(s), no rising errors", len(names)),
+		Details: strings.Join(details, "\n"),
+	}, nil
+}
+
+func joinInts(ns []int) string {
+	s := make([]string, len(ns))
+	for i, n := range ns {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,157 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sampleMarker is printed by commands that collect the same counters more
+// than once (e.g. "sample:1", sleep, "sample:2") so growth can be detected.
+const sampleMarker = "sample"
+
+// parseKeyValues parses "key:value" lines into a map. Lines without a colon
+// are ignored and later keys overwrite earlier ones.
+func parseKeyValues(stdout string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return values
+}
+
+// parseSamples splits "key:value" output into one map per "sample:N" block.
+// Output without any sample marker is returned as a single sample.
+func parseSamples(stdout string) []map[string]string {
+	var samples []map[string]string
+	var current map[string]string
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if key == sampleMarker {
+			current = make(map[string]string)
+			samples = append(samples, current)
+			continue
+		}
+		if current == nil {
+			current = make(map[string]string)
+			samples = append(samples, current)
+		}
+		current[key] = value
+	}
+	return samples
+}
+
+// intValue returns the integer value for key, and whether it was present and valid.
+func intValue(values map[string]string, key string) (int64, bool) {
+	v, ok := values[key]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// floatValue returns the float value for key, and whether it was present and valid.
+func floatValue(values map[string]string, key string) (float64, bool) {
+	v, ok := values[key]
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// percent returns used as a percentage of total, or 0 if total is not positive.
+func percent(used, total int64) int {
+	if total <= 0 {
+		return 0
+	}
+	return int(used * 100 / total)
+}
+
+// growing returns the keys whose integer value increased between two samples,
+// formatted as "key +delta", in the order given.
+func growing(before, after map[string]string, keys ...string) []string {
+	var out []string
+	for _, k := range keys {
+		b, ok1 := intValue(before, k)
+		a, ok2 := intValue(after, k)
+		if ok1 && ok2 && a > b {
+			out = append(out, k+" +"+strconv.FormatInt(a-b, 10))
+		}
+	}
+	return out
+}
+
+// logCategory groups the log messages that indicate the same kind of
+// problem. The patterns are POSIX extended regular expressions because they
+// are matched by awk on the node as well as by Go in tests.
+type logCategory struct {
+	name        string
+	description string
+	// critical categories fail the check, the others are only reported.
+	critical bool
+	patterns []string
+	re       *regexp.Regexp
+}
+
+func compileLogCategories(categories []logCategory) []logCategory {
+	for i := range categories {
+		categories[i].re = regexp.MustCompilePOSIX(patternAlternation(categories[i].patterns))
+	}
+	return categories
+}
+
+func patternAlternation(patterns []string) string {
+	return "(" + strings.Join(patterns, ")|(") + ")"
+}
+
+// classifyLogMessage returns the first category matching a log message, or
+// an empty string if it doesn't indicate a known problem.
+func classifyLogMessage(categories []logCategory, msg string) string {
+	for _, c := range categories {
+		if c.re.MatchString(msg) {
+			return c.name
+		}
+	}
+	return ""
+}
+
+// logAwkProgram returns an awk program applying categories to log lines. It
+// prints "count_<category>:N" for every category and up to samples
+// "kmsg:<category>|<line>" lines per category, truncated to maxLen
+// characters. Lines starting with "source:" are passed through.
+func logAwkProgram(categories []logCategory, samples, maxLen int) string {
+	var b strings.Builder
+	b.WriteString("/^source:/ {print; next}\n")
+	for _, c := range categories {
+		// The pattern is used as an awk regex literal, in which "/" ends the literal.
+		re := strings.ReplaceAll(patternAlternation(c.patterns), "/", `\/`)
+		fmt.Fprintf(&b, "/%s/ {n[\"%s\"]++; if (n[\"%[2]s\"] <= %[3]d) print \"kmsg:%[2]s|\" substr($0, 1, %[4]d); next}\n",
+			re, c.name, samples, maxLen)
+	}
+	b.WriteString("END {")
+	for _, c := range categories {
+		fmt.Fprintf(&b, "print \"count_%s:\" n[\"%[1]s\"] + 0; ", c.name)
+	}
+	b.WriteString("}")
+	return b.String()
+}
@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyValues(t *testing.T) {
+	values := parseKeyValues("a:1\nignored\nb: two \nc:host:443\n")
+	assert.Equal(t, map[string]string{"a": "1", "b": "two", "c": "host:443"}, values)
+}
+
+func TestParseSamples(t *testing.T) {
+	t.Run("with markers", func(t *testing.T) {
+		samples := parseSamples("sample:1\nx:1\nsample:2\nx:5\n")
+		require.Len(t, samples, 2)
+		assert.Equal(t, "1", samples[0]["x"])
+		assert.Equal(t, "5", samples[1]["x"])
+	})
+
+	t.Run("without markers", func(t *testing.T) {
+		samples := parseSamples("x:1\ny:2\n")
+		require.Len(t, samples, 1)
+		assert.Equal(t, "2", samples[0]["y"])
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Empty(t, parseSamples(""))
+	})
+}
+
+func TestGrowing(t *testing.T) {
+	before := map[string]string{"a": "1", "b": "5", "c": "x"}
+	after := map[string]string{"a": "4", "b": "5", "c": "y"}
+	assert.Equal(t, []string{"a +3"}, growing(before, after, "a", "b", "c", "missing"))
+}
+
+func TestPercent(t *testing.T) {
+	assert.Equal(t, 50, percent(5, 10))
+	assert.Equal(t, 0, percent(5, 0))
+}
@@ -30,6 +30,21 @@ func (c *processHealth) Command() string {
 done`
 }
 
+func (c *processHealth) CommandForOS(os pkgruntime.OS) string {
+	switch os {
+	case pkgruntime.OSWindows:
+		// Running services are reported as "active", like systemd does.
+		return `foreach ($svc in 'kubelet', 'containerd') {
+  $s = Get-Service -Name $svc -ErrorAction SilentlyContinue
+  if (-not $s) { $status = 'unknown' }
+  elseif ($s.Status -eq 'Running') { $status = 'active' }
+  else { $status = $s.Status.ToString().ToLower() }
+  "${svc}:$status"
+}`
+	}
+	return ""
+}
+
 func (c *processHealth) Parse(res *pkgruntime.RunResult) (*Result, error) {
 	lines := strings.Split(strings.TrimSpace(res.Stdout), "\n")
 	var failed []string
@@ -0,0 +1,260 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newResourcePressure())
+}
+
+// defaultMemoryEviction is the kubelet's built-in memory.available hard
+// eviction threshold, used when the node doesn't configure one.
+const defaultMemoryEviction = "100Mi"
+
+type resourcePressure struct {
+	// psiThreshold is the PSI "some" avg10 value (percent of time stalled)
+	// considered as pressure.
+	psiThreshold float64
+	// evictionMargin is the minimum headroom, in percent of total memory,
+	// between MemAvailable and the kubelet memory eviction threshold.
+	evictionMargin int
+	// topPods is the number of top memory consuming pods to report.
+	topPods int
+}
+
+func newResourcePressure() *resourcePressure {
+	return &resourcePressure{
+		psiThreshold:   25,
+		evictionMargin: 5,
+		topPods:        5,
+	}
+}
+
+func (c *resourcePressure) Name() string { return "resource-pressure" }
+func (c *resourcePressure) Description() string {
+	return "Check CPU, memory and IO pressure (PSI) and how close the node is to eviction or OOM"
+}
+func (c *resourcePressure) Mode() Mode { return ModeVerify }
+
+func (c *resourcePressure) AddFlags(fs *pflag.FlagSet) {
+	fs.Float64Var(&c.psiThreshold, "psi-threshold", c.psiThreshold,
+		"PSI 'some' avg10 (percent of time stalled) considered as pressure")
+	fs.IntVar(&c.evictionMargin, "eviction-margin", c.evictionMargin,
+		"Minimum headroom (percent of total memory) above the kubelet memory eviction threshold")
+	fs.IntVar(&c.topPods, "top", c.topPods, "Number of top memory consuming pods to report")
+}
+
+func (c *resourcePressure) Command() string {
+	// Pod cgroups are mapped to "namespace_name_uid" through /var/log/pods.
+	// The kubelet config file is assumed to be JSON, as written by AKS.
+	return fmt.Sprintf(`for r in cpu memory io; do
+  [ -r /proc/pressure/$r ] && awk -v r=$r '{print "psi_" r "_" $1 ":" $2 " " $3}' /proc/pressure/$r
+done
+awk '/^(MemTotal|MemAvailable):/ {print tolower($1) $2}' /proc/meminfo
+kubelet_args=$(tr '\0' ' ' < /proc/$(pgrep -o kubelet)/cmdline 2>/dev/null)
+flag=$(echo "$kubelet_args" | grep -o -- '--eviction-hard=[^ ]*' | head -1 | cut -d= -f2-)
+[ -n "$flag" ] && echo "eviction_hard_flag:$flag"
+config=$(echo "$kubelet_args" | grep -o -- '--config=[^ ]*' | head -1 | cut -d= -f2-)
+[ -r "$config" ] && tr -d ' \n\t' < "$config" | grep -o '"evictionHard":{[^}]*}' | sed 's/^"evictionHard":/eviction_hard_config:/'
+find /sys/fs/cgroup -maxdepth 5 -path '*kubepods*' \( -name memory.current -o -name memory.usage_in_bytes \) 2>/dev/null |
+  grep -E 'pod[0-9a-f_-]+(\.slice)?/memory\.[a-z_]+$' | while read f; do
+  uid=$(echo "$f" | sed -E 's#.*pod([0-9a-f_-]+)(\.slice)?/memory\..*#\1#' | tr _ -)
+  echo "$(cat "$f") $uid"
+done | sort -rn | head -%d | while read bytes uid; do
+  pod=$(ls /var/log/pods 2>/dev/null | grep -- "_${uid}$" | head -1)
+  echo "pod_memory:$bytes ${pod:-$uid}"
+done`, c.topPods)
+}
+
+// psi holds the avg10 and avg60 values of a /proc/pressure line.
+type psi struct {
+	avg10 float64
+	avg60 float64
+}
+
+func parsePSI(v string) (psi, bool) {
+	var p psi
+	if _, err := fmt.Sscanf(v, "avg10=%f avg60=%f", &p.avg10, &p.avg60); err != nil {
+		return p, false
+	}
+	return p, true
+}
+
+// podMemory is the memory usage of a pod cgroup.
+type podMemory struct {
+	pod   string
+	bytes int64
+}
+
+// parsePodMemory parses "<bytes> <namespace_name_uid|uid>" into a podMemory
+// formatted as "namespace/name".
+func parsePodMemory(v string) (podMemory, bool) {
+	fields := strings.Fields(v)
+	if len(fields) != 2 {
+		return podMemory{}, false
+	}
+	b, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return podMemory{}, false
+	}
+	return podMemory{pod: formatPodLogDir(fields[1]), bytes: b}, true
+}
+
+// formatPodLogDir formats a /var/log/pods directory name
+// ("namespace_name_uid") as "namespace/name". Anything else, e.g. a bare
+// pod UID, is returned as is.
+func formatPodLogDir(dir string) string {
+	if parts := strings.SplitN(dir, "_", 3); len(parts) == 3 {
+		return parts[0] + "/" + parts[1]
+	}
+	return dir
+}
+
+// memoryEvictionThreshold returns the memory.available hard eviction
+// threshold in bytes. The --eviction-hard flag takes precedence over the
+// kubelet config file, matching kubelet behavior.
+func memoryEvictionThreshold(values map[string]string, memTotal int64) (int64, string, error) {
+	raw := ""
+	source := "kubelet default"
+	if cfg, ok := values["eviction_hard_config"]; ok {
+		var m map[string]string
+		if err := json.Unmarshal([]byte(cfg), &m); err == nil {
+			if v, ok := m["memory.available"]; ok {
+				raw, source = v, "kubelet config"
+			}
+		}
+	}
+	if flag, ok := values["eviction_hard_flag"]; ok {
+		for _, signal := range strings.Split(flag, ",") {
+			if v, ok := strings.CutPrefix(signal, "memory.available<"); ok {
+				raw, source = v, "--eviction-hard"
+			}
+		}
+	}
+	if raw == "" {
+		raw = defaultMemoryEviction
+	}
+
+	if pct, ok := strings.CutSuffix(raw, "%"); ok {
+		f, err := strconv.ParseFloat(pct, 64)
+		if err != nil {
+			return 0, "", fmt.Errorf("parsing eviction threshold %q: %w", raw, err)
+		}
+		return int64(float64(memTotal) * f / 100), fmt.Sprintf("%s (%s)", raw, source), nil
+	}
+	q, err := resource.ParseQuantity(raw)
+	if err != nil {
+		return 0, "", fmt.Errorf("parsing eviction threshold %q: %w", raw, err)
+	}
+	return q.Value(), fmt.Sprintf("%s (%s)", raw, source), nil
+}
+
+func formatMiB(b int64) string {
+	return fmt.Sprintf("%dMi", b/(1024*1024))
+}
+
+func (c *resourcePressure) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	values := parseKeyValues(res.Stdout)
+
+	memTotalKB, okTotal := intValue(values, "memtotal")
+	memAvailKB, okAvail := intValue(values, "memavailable")
+	if !okTotal || !okAvail {
+		return nil, fmt.Errorf("couldn't parse meminfo from output: %q", res.Stdout)
+	}
+	memTotal, memAvail := memTotalKB*1024, memAvailKB*1024
+
+	var issues []string
+	var details []string
+
+	// PSI
+	var psiLines []string
+	for _, r := range []string{"cpu", "memory", "io"} {
+		for _, kind := range []string{"some", "full"} {
+			p, ok := parsePSI(values["psi_"+r+"_"+kind])
+			if !ok {
+				continue
+			}
+			psiLines = append(psiLines, fmt.Sprintf("%s %s: avg10=%.2f avg60=%.2f", r, kind, p.avg10, p.avg60))
+			if kind == "some" && p.avg10 >= c.psiThreshold {
+				issues = append(issues, fmt.Sprintf("%s pressure %.1f%%", r, p.avg10))
+			}
+		}
+	}
+	if len(psiLines) > 0 {
+		details = append(details, "PSI:")
+		for _, l := range psiLines {
+			details = append(details, "  "+l)
+		}
+	} else {
+		details = append(details, "PSI: not available on this kernel")
+	}
+
+	// Memory headroom
+	threshold, thresholdDesc, err := memoryEvictionThreshold(values, memTotal)
+	if err != nil {
+		return nil, err
+	}
+	headroom := memAvail - threshold
+	headroomPct := percent(headroom, memTotal)
+	details = append(details,
+		fmt.Sprintf("memory: %s available of %s (%d%%)", formatMiB(memAvail), formatMiB(memTotal), percent(memAvail, memTotal)),
+		fmt.Sprintf("eviction threshold: memory.available<%s", thresholdDesc),
+	)
+	if headroom <= 0 {
+		issues = append(issues, "memory below kubelet eviction threshold")
+		details = append(details, fmt.Sprintf("headroom to eviction: none (%s below)", formatMiB(-headroom)))
+	} else {
+		details = append(details, fmt.Sprintf("headroom to eviction: %s (%d%% of memory)", formatMiB(headroom), headroomPct))
+		if headroomPct < c.evictionMargin {
+			issues = append(issues, fmt.Sprintf("only %s above kubelet eviction threshold", formatMiB(headroom)))
+		}
+	}
+
+	// Top pods
+	var pods []podMemory
+	for _, line := range strings.Split(res.Stdout, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "pod_memory:"); ok {
+			if pm, ok := parsePodMemory(v); ok {
+				pods = append(pods, pm)
+			}
+		}
+	}
+	sort.SliceStable(pods, func(i, j int) bool { return pods[i].bytes > pods[j].bytes })
+	if len(pods) > 0 {
+		var b strings.Builder
+		w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "POD\tMEMORY\t% OF NODE")
+		for _, p := range pods {
+			fmt.Fprintf(w, "%s\t%s\t%d%%\n", p.pod, formatMiB(p.bytes), percent(p.bytes, memTotal))
+		}
+		w.Flush()
+		details = append(details, "top memory consumers:", strings.TrimRight(b.String(), "\n"))
+	}
+
+	if len(issues) > 0 {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("Resource pressure: %s", strings.Join(issues, "; ")),
+			Details: strings.Join(details, "\n"),
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: fmt.Sprintf("No resource pressure: %s above eviction threshold", formatMiB(headroom)),
+		Details: strings.Join(details, "\n"),
+	}, nil
+}
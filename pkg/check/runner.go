@@ -5,11 +5,15 @@ package check
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/Azure/kubectl-aks/pkg/nodegroup"
 	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
 )
 
@@ -27,7 +31,23 @@ type NodeResult struct {
 
 // RunOnNode executes a check on a single node using the given runtime.
 func RunOnNode(ctx context.Context, c Check, rt pkgruntime.Runtime, nodeName string, timeout int, duration int) (*NodeResult, error) {
-	command := c.Command()
+	nodeOS := pkgruntime.OSLinux
+	if d, ok := rt.(pkgruntime.OSDetector); ok {
+		var err error
+		if nodeOS, err = d.NodeOS(ctx, nodeName); err != nil {
+			return &NodeResult{
+				NodeName: nodeName,
+				Err:      fmt.Errorf("detecting OS of node %q: %w", nodeName, err),
+			}, nil
+		}
+	}
+	command, err := commandForOS(c, nodeOS)
+	if err != nil {
+		return &NodeResult{
+			NodeName: nodeName,
+			Err:      err,
+		}, nil
+	}
 
 	// For trace checks, substitute the duration template.
 	if c.Mode() == ModeTrace {
@@ -52,7 +72,12 @@ func RunOnNode(ctx context.Context, c Check, rt pkgruntime.Runtime, nodeName str
 		}, nil
 	}
 
-	result, err := c.Parse(res)
+	var result *Result
+	if na, ok := c.(NodeAware); ok {
+		result, err = na.ParseNode(nodeName, res)
+	} else {
+		result, err = c.Parse(res)
+	}
 	if err != nil {
 		return &NodeResult{
 			NodeName: nodeName,
@@ -66,6 +91,19 @@ func RunOnNode(ctx context.Context, c Check, rt pkgruntime.Runtime, nodeName str
 	}, nil
 }
 
+// commandForOS returns the command of a check for a node running nodeOS.
+func commandForOS(c Check, nodeOS pkgruntime.OS) (string, error) {
+	if nodeOS == pkgruntime.OSLinux {
+		return c.Command(), nil
+	}
+	if oa, ok := c.(OSAware); ok {
+		if command := oa.CommandForOS(nodeOS); command != "" {
+			return command, nil
+		}
+	}
+	return "", fmt.Errorf("check %q doesn't support %s nodes", c.Name(), nodeOS)
+}
+
 // RuntimeFactory creates a runtime for a given node name.
 // This allows the runner to be decoupled from the runtime construction details.
 type RuntimeFactory func(nodeName string) (pkgruntime.Runtime, error)
@@ -95,9 +133,145 @@ func RunOnNodes(ctx context.Context, c Check, nodes []string, factory RuntimeFac
 	}
 
 	wg.Wait()
+	markPoolOutliers(results)
 	return results
 }
 
+// aksNodeNameRe matches the names of AKS Linux nodes:
+// aks-<pool>-<hash>-vmss<instance>, or aks-<pool>-<hash>-[vms]<number> for
+// the standalone VMs of VirtualMachines node pools and availability sets.
+var aksNodeNameRe = regexp.MustCompile(`^aks-([a-z0-9]+)-[0-9]+-(?:vmss[0-9a-z]{6}|(?:vms)?[0-9]+)$`)
+
+// nodePool returns the AKS node pool of a node from its name. Windows node
+// names are "aks" followed by the pool name and a 6 character instance
+// suffix. Other nodes are considered to be in a single unnamed pool.
+func nodePool(nodeName string) string {
+	if m := aksNodeNameRe.FindStringSubmatch(nodeName); m != nil {
+		return m[1]
+	}
+	if strings.HasPrefix(nodeName, "aks") && !strings.Contains(nodeName, "-") && len(nodeName) > 9 {
+		return nodeName[3 : len(nodeName)-6]
+	}
+	return ""
+}
+
+// unsetFact is the value compared for a fact a node didn't report.
+const unsetFact = "<unset>"
+
+// markPoolOutliers compares the facts of the nodes of each pool and fails the
+// result of the nodes that differ from the pool majority. Values without a
+// strict majority aren't reported, so pools need at least three nodes.
+func markPoolOutliers(results []NodeResult) {
+	pools := make(map[string][]*NodeResult)
+	for i := range results {
+		r := &results[i]
+		if r.Result != nil && len(r.Result.Facts) > 0 {
+			pools[nodePool(r.NodeName)] = append(pools[nodePool(r.NodeName)], r)
+		}
+	}
+
+	for pool, nodes := range pools {
+		if len(nodes) < 3 {
+			continue
+		}
+		keys := make(map[string]bool)
+		for _, r := range nodes {
+			for k := range r.Result.Facts {
+				keys[k] = true
+			}
+		}
+		sortedKeys := make([]string, 0, len(keys))
+		for k := range keys {
+			sortedKeys = append(sortedKeys, k)
+		}
+		sort.Strings(sortedKeys)
+
+		outliers := make(map[*NodeResult][]string)
+		var order []*NodeResult
+		for _, k := range sortedKeys {
+			counts := make(map[string]int)
+			for _, r := range nodes {
+				counts[factValue(r.Result.Facts, k)]++
+			}
+			majority := ""
+			for v, n := range counts {
+				if n*2 > len(nodes) {
+					majority = v
+				}
+			}
+			if majority == "" {
+				continue
+			}
+			for _, r := range nodes {
+				if v := factValue(r.Result.Facts, k); v != majority {
+					if outliers[r] == nil {
+						order = append(order, r)
+					}
+					outliers[r] = append(outliers[r], fmt.Sprintf("%s: %s (pool majority: %s)", k, v, majority))
+				}
+			}
+		}
+
+		name := pool
+		if name == "" {
+			name = "(unnamed)"
+		}
+		for _, r := range order {
+			diffs := outliers[r]
+			r.Result.Success = false
+			r.Result.Message += fmt.Sprintf("; differs from the %s pool majority on %d setting(s)", name, len(diffs))
+			details := strings.Join(diffs, "\n")
+			if r.Result.Details != "" {
+				details = r.Result.Details + "\n" + details
+			}
+			r.Result.Details = details
+		}
+	}
+}
+
+func factValue(facts map[string]string, key string) string {
+	if v, ok := facts[key]; ok {
+		return v
+	}
+	return unsetFact
+}
+
+// resultText returns the human-readable result of a node and whether it's a
+// failure.
+func resultText(r NodeResult) (text string, failed bool) {
+	var b strings.Builder
+	if errors.Is(r.Err, context.Canceled) {
+		fmt.Fprintln(&b, "CANCELLED")
+		failed = true
+	} else if r.Err != nil {
+		fmt.Fprintf(&b, "ERROR: %s\n", r.Err)
+		failed = true
+	} else if r.Result.Success {
+		fmt.Fprintf(&b, "✓ %s\n", r.Result.Message)
+	} else {
+		fmt.Fprintf(&b, "✗ %s\n", r.Result.Message)
+		failed = true
+	}
+	if r.Result != nil && r.Result.Details != "" {
+		fmt.Fprintln(&b, r.Result.Details)
+	}
+	return b.String(), failed
+}
+
+// resultStatus returns the symbol summarizing the result of a node.
+func resultStatus(r NodeResult) string {
+	switch {
+	case errors.Is(r.Err, context.Canceled):
+		return "CANCELLED"
+	case r.Err != nil:
+		return "ERROR"
+	case r.Result.Success:
+		return "✓"
+	default:
+		return "✗"
+	}
+}
+
 // FormatResults produces a consistent human-readable output and returns
 // whether any check failed.
 func FormatResults(results []NodeResult) (output string, hasFailure bool) {
@@ -114,18 +288,54 @@ func FormatResults(results []NodeResult) (output string, hasFailure bool) {
 		if multiNode {
 			fmt.Fprintf(&b, "=== %s ===\n", prefix)
 		}
-		if r.Err != nil {
-			fmt.Fprintf(&b, "ERROR: %s\n", r.Err)
-			hasFailure = true
-		} else if r.Result.Success {
-			fmt.Fprintf(&b, "✓ %s\n", r.Result.Message)
-		} else {
-			fmt.Fprintf(&b, "✗ %s\n", r.Result.Message)
-			hasFailure = true
+		text, failed := resultText(r)
+		b.WriteString(text)
+		hasFailure = hasFailure || failed
+	}
+	return b.String(), hasFailure
+}
+
+// FormatGroupedResults is like FormatResults, but prints each distinct result
+// once with the nodes that produced it, as pdsh's dshbak does, followed by the
+// number of nodes per status, e.g. "95 nodes ✓, 5 nodes ✗ (nodes)".
+func FormatGroupedResults(results []NodeResult) (output string, hasFailure bool) {
+	nodes := make([]string, len(results))
+	texts := make([]string, len(results))
+	for i, r := range results {
+		var failed bool
+		nodes[i] = r.NodeName
+		texts[i], failed = resultText(r)
+		hasFailure = hasFailure || failed
+	}
+
+	var b strings.Builder
+	for i, g := range nodegroup.ByOutput(nodes, texts) {
+		if i > 0 {
+			fmt.Fprintln(&b)
+		}
+		fmt.Fprintf(&b, "=== %s (%s) ===\n", nodegroup.Compress(g.Nodes), nodegroup.Count(len(g.Nodes)))
+		b.WriteString(texts[g.Index])
+	}
+
+	// Only the nodes that didn't pass are listed.
+	var statuses []string
+	byStatus := make(map[string][]string)
+	for _, r := range results {
+		status := resultStatus(r)
+		if _, ok := byStatus[status]; !ok {
+			statuses = append(statuses, status)
 		}
-		if r.Result != nil && r.Result.Details != "" {
-			fmt.Fprintln(&b, r.Result.Details)
+		byStatus[status] = append(byStatus[status], r.NodeName)
+	}
+	sort.SliceStable(statuses, func(i, j int) bool { return statuses[i] == "✓" && statuses[j] != "✓" })
+	var summary []string
+	for _, status := range statuses {
+		s := fmt.Sprintf("%s %s", nodegroup.Count(len(byStatus[status])), status)
+		if status != "✓" {
+			s += fmt.Sprintf(" (%s)", nodegroup.Compress(byStatus[status]))
 		}
+		summary = append(summary, s)
 	}
+	fmt.Fprintf(&b, "\n%s\n", strings.Join(summary, ", "))
 	return b.String(), hasFailure
 }
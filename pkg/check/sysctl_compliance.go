@@ -0,0 +1,218 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package check
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func init() {
+	Register(newSysctlCompliance())
+}
+
+// defaultSysctlKeys are collected when there is no baseline, so nodes can
+// still be compared with their pool. They are the settings commonly tuned
+// through AKS custom node configuration.
+var defaultSysctlKeys = []string{
+	"fs.file-max",
+	"fs.inotify.max_user_instances",
+	"fs.inotify.max_user_watches",
+	"fs.aio-max-nr",
+	"kernel.pid_max",
+	"kernel.threads-max",
+	"net.core.netdev_max_backlog",
+	"net.core.rmem_max",
+	"net.core.somaxconn",
+	"net.core.wmem_max",
+	"net.ipv4.ip_local_port_range",
+	"net.ipv4.neigh.default.gc_thresh1",
+	"net.ipv4.neigh.default.gc_thresh2",
+	"net.ipv4.neigh.default.gc_thresh3",
+	"net.ipv4.tcp_fin_timeout",
+	"net.ipv4.tcp_keepalive_time",
+	"net.ipv4.tcp_max_syn_backlog",
+	"net.ipv4.tcp_tw_reuse",
+	"net.netfilter.nf_conntrack_max",
+	"vm.max_map_count",
+	"vm.swappiness",
+	"vm.vfs_cache_pressure",
+}
+
+// sysctlKeyRe restricts the keys to the characters of sysctl names, so they
+// can be passed to the shell unquoted.
+var sysctlKeyRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+type sysctlCompliance struct {
+	// baselineFile is a sysctl.conf style file with the expected values.
+	baselineFile string
+	// storedBaseline is the baseline stored in the config, if any.
+	storedBaseline []string
+}
+
+func newSysctlCompliance() *sysctlCompliance {
+	return &sysctlCompliance{}
+}
+
+func (c *sysctlCompliance) SetEnvironment(env *Environment) {
+	c.storedBaseline = env.SysctlBaseline
+}
+
+func (c *sysctlCompliance) Name() string { return "sysctl-compliance" }
+func (c *sysctlCompliance) Description() string {
+	return "Check kernel parameters against a sysctl baseline and the other nodes of the pool"
+}
+func (c *sysctlCompliance) Mode() Mode { return ModeVerify }
+
+func (c *sysctlCompliance) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.baselineFile, "baseline", c.baselineFile,
+		"sysctl.conf style file with the expected values (default: the baseline stored for the cluster, see 'kubectl aks config set-sysctl-baseline')")
+}
+
+// ParseSysctlBaseline parses sysctl.conf lines ("key = value"), ignoring
+// comments and blank lines. Keys may use "/" as separator and values are
+// whitespace-normalized like the output of sysctl.
+func ParseSysctlBaseline(lines []string) (map[string]string, error) {
+	baseline := make(map[string]string)
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		key = strings.ReplaceAll(strings.TrimSpace(key), "/", ".")
+		if !found || !sysctlKeyRe.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid sysctl entry %q", i+1, line)
+		}
+		baseline[key] = strings.Join(strings.Fields(value), " ")
+	}
+	return baseline, nil
+}
+
+// baseline returns the expected values from the --baseline file or the
+// config, or nil if there is no baseline.
+func (c *sysctlCompliance) baseline() (map[string]string, error) {
+	if c.baselineFile == "" {
+		if len(c.storedBaseline) == 0 {
+			return nil, nil
+		}
+		b, err := ParseSysctlBaseline(c.storedBaseline)
+		if err != nil {
+			return nil, fmt.Errorf("parsing stored sysctl baseline: %w", err)
+		}
+		return b, nil
+	}
+
+	f, err := os.Open(c.baselineFile)
+	if err != nil {
+		return nil, fmt.Errorf("opening sysctl baseline: %w", err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading sysctl baseline: %w", err)
+	}
+	b, err := ParseSysctlBaseline(lines)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", c.baselineFile, err)
+	}
+	return b, nil
+}
+
+// keys returns the sysctl keys to collect. An invalid baseline is reported by
+// Parse, the default keys are collected meanwhile.
+func (c *sysctlCompliance) keys() []string {
+	b, err := c.baseline()
+	if err != nil || len(b) == 0 {
+		return defaultSysctlKeys
+	}
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *sysctlCompliance) Command() string {
+	// -e ignores unknown keys, which are then reported as missing.
+	return "sysctl -e " + strings.Join(c.keys(), " ") + " 2>/dev/null"
+}
+
+func (c *sysctlCompliance) Parse(res *pkgruntime.RunResult) (*Result, error) {
+	baseline, err := c.baseline()
+	if err != nil {
+		return nil, err
+	}
+
+	actual := make(map[string]string)
+	for _, line := range strings.Split(res.Stdout, "\n") {
+		key, value, found := strings.Cut(line, " = ")
+		if !found {
+			continue
+		}
+		actual[strings.TrimSpace(key)] = strings.Join(strings.Fields(value), " ")
+	}
+	if len(actual) == 0 {
+		return nil, fmt.Errorf("couldn't parse sysctl-compliance output: %q", res.Stdout)
+	}
+
+	if len(baseline) == 0 {
+		return &Result{
+			Success: true,
+			Message: fmt.Sprintf("Collected %d kernel parameter(s), no baseline to compare with (use --baseline or 'kubectl aks config set-sysctl-baseline')", len(actual)),
+			Facts:   actual,
+		}, nil
+	}
+
+	keys := make([]string, 0, len(baseline))
+	for k := range baseline {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var drifted []string
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PARAMETER\tEXPECTED\tACTUAL")
+	for _, k := range keys {
+		v, ok := actual[k]
+		if !ok {
+			v = "(missing)"
+		}
+		if v == baseline[k] {
+			continue
+		}
+		drifted = append(drifted, k)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", k, baseline[k], v)
+	}
+	w.Flush()
+
+	if len(drifted) > 0 {
+		return &Result{
+			Success: false,
+			Message: fmt.Sprintf("%d/%d kernel parameter(s) differ from the baseline: %s", len(drifted), len(keys), strings.Join(drifted, ", ")),
+			Details: strings.TrimRight(b.String(), "\n"),
+			Facts:   actual,
+		}, nil
+	}
+	return &Result{
+		Success: true,
+		Message: fmt.Sprintf("All %d kernel parameter(s) match the baseline", len(keys)),
+		Facts:   actual,
+	}, nil
+}
@@ -0,0 +1,126 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package nodegroup groups the nodes that produced the same output, as pdsh's
+// dshbak does, so the outputs of many nodes can be compared at a glance.
+package nodegroup
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Group is a set of nodes that produced the same output.
+type Group struct {
+	Nodes []string
+	// Index is the index of the first node of the group in the input, to
+	// get the output of the group.
+	Index int
+}
+
+// Normalize returns output without the differences that don't matter when
+// comparing outputs: CRLF line endings, trailing whitespace and trailing
+// empty lines.
+func Normalize(output string) string {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+// ByOutput groups nodes[i] by the hash of the normalized outputs[i]. Groups
+// are in the order of their first node.
+func ByOutput(nodes, outputs []string) []Group {
+	var groups []Group
+	index := make(map[[sha256.Size]byte]int)
+	for i, node := range nodes {
+		h := sha256.Sum256([]byte(Normalize(outputs[i])))
+		if g, ok := index[h]; ok {
+			groups[g].Nodes = append(groups[g].Nodes, node)
+			continue
+		}
+		index[h] = len(groups)
+		groups = append(groups, Group{Nodes: []string{node}, Index: i})
+	}
+	return groups
+}
+
+// numberSuffixRe splits a node name into a prefix and a trailing number.
+var numberSuffixRe = regexp.MustCompile(`^(.*?)([0-9]+)$`)
+
+// Compress returns the names of nodes as a pdsh host list: names only
+// differing by a trailing number of the same width are folded into ranges,
+// e.g. aks-nodepool1-12345678-vmss[000000-000002,000005].
+func Compress(nodes []string) string {
+	type series struct {
+		prefix  string
+		width   int
+		numbers []int
+	}
+	var list []*series
+	var others []string
+	byKey := make(map[string]*series)
+	for _, node := range nodes {
+		m := numberSuffixRe.FindStringSubmatch(node)
+		if m == nil {
+			others = append(others, node)
+			continue
+		}
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			others = append(others, node)
+			continue
+		}
+		key := fmt.Sprintf("%s/%d", m[1], len(m[2]))
+		s, ok := byKey[key]
+		if !ok {
+			s = &series{prefix: m[1], width: len(m[2])}
+			byKey[key] = s
+			list = append(list, s)
+		}
+		s.numbers = append(s.numbers, n)
+	}
+
+	var parts []string
+	for _, s := range list {
+		sort.Ints(s.numbers)
+		if len(s.numbers) == 1 {
+			parts = append(parts, fmt.Sprintf("%s%0*d", s.prefix, s.width, s.numbers[0]))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s[%s]", s.prefix, ranges(s.numbers, s.width)))
+	}
+	return strings.Join(append(parts, others...), ",")
+}
+
+// ranges returns the sorted numbers with consecutive ones folded into ranges,
+// e.g. 0-2,5.
+func ranges(numbers []int, width int) string {
+	var parts []string
+	for i := 0; i < len(numbers); {
+		j := i
+		for j+1 < len(numbers) && numbers[j+1] == numbers[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprintf("%0*d", width, numbers[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%0*d-%0*d", width, numbers[i], width, numbers[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// Count returns "1 node" or "N nodes".
+func Count(n int) string {
+	if n == 1 {
+		return "1 node"
+	}
+	return fmt.Sprintf("%d nodes", n)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package nodegroup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "a\n\nb", Normalize("a  \r\n\t\r\nb\n\n"))
+	assert.Equal(t, Normalize("5.15.0-1057-azure\n"), Normalize("5.15.0-1057-azure \r\n"))
+}
+
+func TestByOutput(t *testing.T) {
+	nodes := []string{"node0", "node1", "node2", "node3"}
+	outputs := []string{"5.15.0-1057-azure\n", "5.15.0-1060-azure\n", "5.15.0-1057-azure\r\n", "5.15.0-1060-azure"}
+
+	assert.Equal(t, []Group{
+		{Nodes: []string{"node0", "node2"}, Index: 0},
+		{Nodes: []string{"node1", "node3"}, Index: 1},
+	}, ByOutput(nodes, outputs))
+	assert.Empty(t, ByOutput(nil, nil))
+}
+
+func TestCompress(t *testing.T) {
+	for _, tc := range []struct {
+		nodes    []string
+		expected string
+	}{
+		{[]string{"aks-nodepool1-12345678-vmss000000"}, "aks-nodepool1-12345678-vmss000000"},
+		{
+			[]string{
+				"aks-nodepool1-12345678-vmss000000",
+				"aks-nodepool1-12345678-vmss000001",
+				"aks-nodepool1-12345678-vmss000002",
+				"aks-nodepool1-12345678-vmss000005",
+			},
+			"aks-nodepool1-12345678-vmss[000000-000002,000005]",
+		},
+		{
+			// Instance IDs are base 36, so not every name ends with a number.
+			[]string{"aks-gpu-87654321-vmss000009", "aks-gpu-87654321-vmss00000a", "akswin000001", "akswin000002"},
+			"aks-gpu-87654321-vmss000009,akswin[000001-000002],aks-gpu-87654321-vmss00000a",
+		},
+		{[]string{"node9", "node10", "node11"}, "node9,node[10-11]"},
+	} {
+		assert.Equal(t, tc.expected, Compress(tc.nodes))
+	}
+}
+
+func TestCount(t *testing.T) {
+	assert.Equal(t, "1 node", Count(1))
+	assert.Equal(t, "95 nodes", Count(95))
+}
@@ -0,0 +1,321 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+	"github.com/Azure/kubectl-aks/pkg/runtime/kubectldebug"
+)
+
+const (
+	// Namespace is the namespace of the agent DaemonSet.
+	Namespace = "kubectl-aks"
+	// Name is the name of the agent DaemonSet.
+	Name = "kubectl-aks-agent"
+
+	containerName  = "agent"
+	nameLabel      = "app.kubernetes.io/name"
+	versionLabel   = "app.kubernetes.io/version"
+	managedByLabel = "app.kubernetes.io/managed-by"
+)
+
+// DaemonSet returns the agent DaemonSet: a privileged pod sharing the host PID
+// namespace on every Linux node, labeled with the kubectl-aks version that
+// installed it.
+func DaemonSet(image, version string) *appsv1.DaemonSet {
+	privileged := true
+	zero := int64(0)
+	labels := map[string]string{
+		nameLabel:      Name,
+		versionLabel:   version,
+		managedByLabel: "kubectl-aks",
+	}
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      Name,
+			Namespace: Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{nameLabel: Name},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					HostPID:      true,
+					NodeSelector: map[string]string{"kubernetes.io/os": "linux"},
+					Tolerations: []corev1.Toleration{
+						{Operator: corev1.TolerationOpExists},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:    containerName,
+							Image:   image,
+							Command: []string{"sh", "-c", "while true; do sleep 3600; done"},
+							SecurityContext: &corev1.SecurityContext{
+								Privileged: &privileged,
+							},
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceCPU:    resource.MustParse("5m"),
+									corev1.ResourceMemory: resource.MustParse("16Mi"),
+								},
+							},
+						},
+					},
+					TerminationGracePeriodSeconds: &zero,
+				},
+			},
+		},
+	}
+}
+
+// Install creates the agent DaemonSet, or updates it if it already exists.
+func Install(ctx context.Context, clientset kubernetes.Interface, image, version string) error {
+	_, err := clientset.CoreV1().Namespaces().Create(ctx, kubectldebug.Namespace(Namespace), metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("creating namespace %s: %w", Namespace, err)
+	}
+
+	ds := DaemonSet(image, version)
+	_, err = clientset.AppsV1().DaemonSets(Namespace).Create(ctx, ds, metav1.CreateOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("creating DaemonSet %s: %w", Name, err)
+	}
+	existing, err := clientset.AppsV1().DaemonSets(Namespace).Get(ctx, Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting DaemonSet %s: %w", Name, err)
+	}
+	ds.ResourceVersion = existing.ResourceVersion
+	if _, err := clientset.AppsV1().DaemonSets(Namespace).Update(ctx, ds, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("updating DaemonSet %s: %w", Name, err)
+	}
+	return nil
+}
+
+// Uninstall deletes the agent DaemonSet and its namespace, if it was created
+// by kubectl-aks.
+func Uninstall(ctx context.Context, clientset kubernetes.Interface) error {
+	err := clientset.AppsV1().DaemonSets(Namespace).Delete(ctx, Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting DaemonSet %s: %w", Name, err)
+	}
+
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, Namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting namespace %s: %w", Namespace, err)
+	}
+	if ns.Labels[managedByLabel] != "kubectl-aks" {
+		return nil
+	}
+	if err := clientset.CoreV1().Namespaces().Delete(ctx, Namespace, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting namespace %s: %w", Namespace, err)
+	}
+	return nil
+}
+
+// execFunc runs command in the agent container of pod.
+type execFunc func(ctx context.Context, pod *corev1.Pod, command []string, stdout, stderr io.Writer) error
+
+// Runtime executes commands on AKS nodes through pod exec in the agent pod
+// running on the node, installed with Install.
+type Runtime struct {
+	Clientset kubernetes.Interface
+	Config    *rest.Config
+	// Version is the kubectl-aks version, compared with the one that
+	// installed the agent.
+	Version string
+
+	exec execFunc
+}
+
+// versionSkewOnce reports a version skew once, rather than for every node of
+// a cluster.
+var versionSkewOnce sync.Once
+
+func (r *Runtime) RunCommand(ctx context.Context, opts *pkgruntime.RunOptions) (*pkgruntime.RunResult, error) {
+	if r.Clientset == nil {
+		return nil, fmt.Errorf("kubernetes clientset is required for agent runtime")
+	}
+	if opts.NodeName == "" {
+		return nil, fmt.Errorf("node name is required for agent runtime")
+	}
+
+	pod, err := r.agentPod(ctx, opts.NodeName)
+	if err != nil {
+		return nil, pkgruntime.NotStarted(err)
+	}
+	versionSkewOnce.Do(func() {
+		if msg := versionSkew(pod, r.Version); msg != "" {
+			log.Warn(msg)
+		}
+	})
+
+	// No shell quoting is needed: the command is passed as an argument. The
+	// tag, passed as $0, identifies the processes to stop on cancellation.
+	tag := commandTag()
+	command := []string{"nsenter", "-t", "1", "-m", "-u", "-i", "-n", "-p", "--",
+		"timeout", strconv.Itoa(opts.Timeout), "sh", "-c", opts.Command, tag}
+	exec := r.exec
+	if exec == nil {
+		exec = r.remoteExec
+	}
+	// The output is buffered, as with the other runtimes.
+	var stdout, stderr bytes.Buffer
+	err = exec(ctx, pod, command, &stdout, &stderr)
+	if ctx.Err() != nil {
+		r.stop(exec, pod, tag)
+		return nil, fmt.Errorf("command cancelled: %w", ctx.Err())
+	}
+	// As with the other runtimes, a non-zero exit code isn't an error.
+	exitCode := 0
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitStatus()
+	} else if err != nil {
+		return nil, fmt.Errorf("running command in agent pod %s: %w", pod.Name, err)
+	}
+
+	return &pkgruntime.RunResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: &exitCode,
+	}, nil
+}
+
+// Probe checks that the node is ready and runs an agent pod.
+func (r *Runtime) Probe(ctx context.Context, nodeName string) error {
+	if r.Clientset == nil {
+		return fmt.Errorf("kubernetes clientset is required for agent runtime")
+	}
+	if err := kubectldebug.ProbeNode(ctx, r.Clientset, nodeName); err != nil {
+		return err
+	}
+	_, err := r.agentPod(ctx, nodeName)
+	return err
+}
+
+// agentPod returns the running agent pod of a node.
+func (r *Runtime) agentPod(ctx context.Context, nodeName string) (*corev1.Pod, error) {
+	pods, err := r.Clientset.CoreV1().Pods(Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: nameLabel + "=" + Name,
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing agent pods: %w", err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName == nodeName && pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil {
+			return pod, nil
+		}
+	}
+	return nil, fmt.Errorf("no running agent pod on node %s: install it with 'kubectl aks agent install'", nodeName)
+}
+
+// versionSkew returns a warning if the agent pod was installed by another
+// kubectl-aks version, or "" otherwise.
+func versionSkew(pod *corev1.Pod, version string) string {
+	agentVersion := pod.Labels[versionLabel]
+	if agentVersion == version {
+		return ""
+	}
+	if agentVersion == "" {
+		agentVersion = "unknown"
+	}
+	return fmt.Sprintf("The agent was installed by kubectl-aks %s, but this is kubectl-aks %s: "+
+		"run 'kubectl aks agent install' to update it", agentVersion, version)
+}
+
+// stopTimeout bounds the stop of a cancelled command.
+const stopTimeout = 10 * time.Second
+
+// commandTag returns a unique tag for the processes of a command.
+func commandTag() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("kubectl-aks-%d", time.Now().UnixNano())
+	}
+	return "kubectl-aks-" + hex.EncodeToString(b)
+}
+
+// stop terminates the processes of a cancelled command on the node, which
+// also ends its exec stream. GNU timeout, tagged with its command, forwards
+// SIGTERM to the process group of the command.
+func (r *Runtime) stop(exec execFunc, pod *corev1.Pod, tag string) {
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	// The brackets keep the pattern from matching the pkill command line.
+	pattern := "[" + tag[:1] + "]" + tag[1:]
+	err := exec(ctx, pod, []string{"nsenter", "-t", "1", "-m", "-p", "--", "pkill", "-TERM", "-f", pattern}, io.Discard, io.Discard)
+	// pkill exits with 1 when the command already completed.
+	var exitErr utilexec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitStatus() == 1) {
+		log.Warnf("Couldn't stop the command on node %s, it keeps running until its timeout: %s", pod.Spec.NodeName, err)
+	}
+}
+
+// remoteExec runs command in the agent container of pod via the API server.
+// When ctx is cancelled, it returns right away, and the caller stops the
+// command, which ends the stream.
+func (r *Runtime) remoteExec(ctx context.Context, pod *corev1.Pod, command []string, stdout, stderr io.Writer) error {
+	req := r.Clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: containerName,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(r.Config, "POST", req.URL())
+	if err != nil {
+		return pkgruntime.NotStarted(fmt.Errorf("creating executor: %w", err))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- executor.Stream(remotecommand.StreamOptions{Stdout: stdout, Stderr: stderr})
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	utilexec "k8s.io/client-go/util/exec"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+func agentPod(name, nodeName, version string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: Namespace,
+			Labels:    map[string]string{nameLabel: Name, versionLabel: version},
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestDaemonSet(t *testing.T) {
+	ds := DaemonSet("busybox:latest", "v1.2.0")
+
+	assert.Equal(t, Namespace, ds.Namespace)
+	assert.Equal(t, map[string]string{nameLabel: Name}, ds.Spec.Selector.MatchLabels)
+	assert.Equal(t, "v1.2.0", ds.Spec.Template.Labels[versionLabel])
+	spec := ds.Spec.Template.Spec
+	assert.True(t, spec.HostPID)
+	assert.Equal(t, []corev1.Toleration{{Operator: corev1.TolerationOpExists}}, spec.Tolerations)
+	require.Len(t, spec.Containers, 1)
+	assert.Equal(t, "busybox:latest", spec.Containers[0].Image)
+	assert.True(t, *spec.Containers[0].SecurityContext.Privileged)
+}
+
+func TestInstallAndUninstall(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+
+	require.NoError(t, Install(ctx, clientset, "busybox:latest", "v1.2.0"))
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, Namespace, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "privileged", ns.Labels["pod-security.kubernetes.io/enforce"])
+
+	// Installing again updates the DaemonSet.
+	require.NoError(t, Install(ctx, clientset, "busybox:1.36", "v1.3.0"))
+	ds, err := clientset.AppsV1().DaemonSets(Namespace).Get(ctx, Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "busybox:1.36", ds.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, "v1.3.0", ds.Spec.Template.Labels[versionLabel])
+
+	require.NoError(t, Uninstall(ctx, clientset))
+	_, err = clientset.AppsV1().DaemonSets(Namespace).Get(ctx, Name, metav1.GetOptions{})
+	assert.Error(t, err)
+	_, err = clientset.CoreV1().Namespaces().Get(ctx, Namespace, metav1.GetOptions{})
+	assert.Error(t, err)
+
+	// Uninstalling again is a no-op.
+	require.NoError(t, Uninstall(ctx, clientset))
+}
+
+func TestRunCommand(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		agentPod("agent-pending", "node1", "v1.2.0", corev1.PodPending),
+		agentPod("agent-node1", "node1", "v1.2.0", corev1.PodRunning),
+		agentPod("agent-node2", "node2", "v1.2.0", corev1.PodRunning),
+	)
+
+	var gotPod string
+	var gotCommand []string
+	r := &Runtime{
+		Clientset: clientset,
+		Version:   "v1.2.0",
+		exec: func(ctx context.Context, pod *corev1.Pod, command []string, stdout, stderr io.Writer) error {
+			gotPod, gotCommand = pod.Name, command
+			fmt.Fprint(stdout, "out\n")
+			fmt.Fprint(stderr, "err\n")
+			return utilexec.CodeExitError{Err: fmt.Errorf("command terminated with exit code 1"), Code: 1}
+		},
+	}
+
+	res, err := r.RunCommand(context.Background(), &pkgruntime.RunOptions{
+		NodeName: "node1",
+		Command:  "echo 'it works'",
+		Timeout:  30,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "agent-node1", gotPod)
+	require.Len(t, gotCommand, 15)
+	assert.Equal(t, []string{"nsenter", "-t", "1", "-m", "-u", "-i", "-n", "-p", "--",
+		"timeout", "30", "sh", "-c", "echo 'it works'"}, gotCommand[:14])
+	assert.Regexp(t, "^kubectl-aks-[0-9a-f]{16}$", gotCommand[14])
+	assert.Equal(t, "out\n", res.Stdout)
+	assert.Equal(t, "err\n", res.Stderr)
+	require.NotNil(t, res.ExitCode)
+	assert.Equal(t, 1, *res.ExitCode)
+
+	_, err = r.RunCommand(context.Background(), &pkgruntime.RunOptions{NodeName: "node3", Command: "hostname"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "kubectl aks agent install")
+}
+
+func TestRunCommand_ExecError(t *testing.T) {
+	r := &Runtime{
+		Clientset: fake.NewSimpleClientset(agentPod("agent-node1", "node1", "v1.2.0", corev1.PodRunning)),
+		exec: func(ctx context.Context, pod *corev1.Pod, command []string, stdout, stderr io.Writer) error {
+			return fmt.Errorf("unable to upgrade connection")
+		},
+	}
+	_, err := r.RunCommand(context.Background(), &pkgruntime.RunOptions{NodeName: "node1", Command: "hostname"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to upgrade connection")
+}
+
+func TestRunCommand_Cancelled(t *testing.T) {
+	var commands [][]string
+	r := &Runtime{
+		Clientset: fake.NewSimpleClientset(agentPod("agent-node1", "node1", "v1.2.0", corev1.PodRunning)),
+		exec: func(ctx context.Context, pod *corev1.Pod, command []string, stdout, stderr io.Writer) error {
+			commands = append(commands, command)
+			if len(commands) == 1 {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := r.RunCommand(ctx, &pkgruntime.RunOptions{NodeName: "node1", Command: "sleep 600", Timeout: 700})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.Len(t, commands, 2)
+	tag := commands[0][len(commands[0])-1]
+	assert.Equal(t, []string{"nsenter", "-t", "1", "-m", "-p", "--", "pkill", "-TERM", "-f", "[k]" + tag[1:]}, commands[1])
+}
+
+func TestVersionSkew(t *testing.T) {
+	assert.Empty(t, versionSkew(agentPod("agent", "node1", "v1.2.0", corev1.PodRunning), "v1.2.0"))
+	assert.Contains(t, versionSkew(agentPod("agent", "node1", "v1.1.0", corev1.PodRunning), "v1.2.0"),
+		"installed by kubectl-aks v1.1.0, but this is kubectl-aks v1.2.0")
+	assert.Contains(t, versionSkew(agentPod("agent", "node1", "", corev1.PodRunning), "v1.2.0"),
+		"installed by kubectl-aks unknown")
+}
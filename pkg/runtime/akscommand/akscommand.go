@@ -0,0 +1,201 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package akscommand
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/briandowns/spinner"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+	"github.com/Azure/kubectl-aks/pkg/runtime/kubectldebug"
+)
+
+// aksAADServerAppID is the application ID of the AKS AAD server, used to get
+// the cluster token of clusters with managed AAD integration.
+const aksAADServerAppID = "6dae42f8-4368-4678-94ff-3960e28e3630"
+
+// Invoker runs a command with kubectl inside a cluster.
+type Invoker interface {
+	Invoke(ctx context.Context, command string) (*armcontainerservice.CommandResultProperties, error)
+}
+
+// ClusterInvoker runs commands via the AKS command invoke API
+// (managedClusters/runCommand).
+type ClusterInvoker struct {
+	Client        *armcontainerservice.ManagedClustersClient
+	ResourceGroup string
+	ClusterName   string
+	// ClusterToken is the AAD token of the cluster, required by clusters
+	// with managed AAD integration.
+	ClusterToken string
+}
+
+// NewClusterInvoker returns a ClusterInvoker for the given cluster, getting
+// its cluster token if needed.
+func NewClusterInvoker(ctx context.Context, cred azcore.TokenCredential, armOpts *arm.ClientOptions,
+	subscriptionID, resourceGroup, clusterName string,
+) (*ClusterInvoker, error) {
+	client, err := armcontainerservice.NewManagedClustersClient(subscriptionID, cred, armOpts)
+	if err != nil {
+		return nil, fmt.Errorf("creating AKS client: %w", err)
+	}
+	cluster, err := client.Get(ctx, resourceGroup, clusterName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting cluster: %w", err)
+	}
+
+	inv := &ClusterInvoker{
+		Client:        client,
+		ResourceGroup: resourceGroup,
+		ClusterName:   clusterName,
+	}
+	if p := cluster.Properties; p != nil && p.AADProfile != nil && to.Bool(p.AADProfile.Managed) {
+		token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{aksAADServerAppID + "/.default"}})
+		if err != nil {
+			return nil, fmt.Errorf("getting cluster token: %w", err)
+		}
+		inv.ClusterToken = token.Token
+	}
+	return inv, nil
+}
+
+func (c *ClusterInvoker) Invoke(ctx context.Context, command string) (*armcontainerservice.CommandResultProperties, error) {
+	req := armcontainerservice.RunCommandRequest{Command: to.StringPtr(command)}
+	if c.ClusterToken != "" {
+		req.ClusterToken = to.StringPtr(c.ClusterToken)
+	}
+	poller, err := c.Client.BeginRunCommand(ctx, c.ResourceGroup, c.ClusterName, req, nil)
+	if err != nil {
+		return nil, pkgruntime.NotStarted(fmt.Errorf("begin running command: %w", err))
+	}
+	res, err := poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("polling command response: %w", err)
+	}
+	if res.Properties == nil {
+		return nil, fmt.Errorf("no response received after command execution")
+	}
+	return res.Properties, nil
+}
+
+// Runtime executes commands on AKS nodes by creating the debug pod of the
+// kube-api runtime through the AKS command invoke API. kubectl runs inside
+// the cluster, so it works with private clusters whose API server isn't
+// reachable.
+type Runtime struct {
+	Invoker   Invoker
+	Image     string
+	Namespace string
+	// Profile is a strategic merge patch applied to the debug pod, see
+	// kubectldebug.ApplyProfile.
+	Profile []byte
+}
+
+func (r *Runtime) RunCommand(ctx context.Context, opts *pkgruntime.RunOptions) (*pkgruntime.RunResult, error) {
+	if r.Invoker == nil {
+		return nil, fmt.Errorf("AKS client is required for aks-command runtime")
+	}
+	if opts.NodeName == "" {
+		return nil, fmt.Errorf("node name is required for aks-command runtime")
+	}
+
+	debug := &kubectldebug.Runtime{Image: r.Image, Namespace: r.Namespace, Profile: r.Profile}
+	pod, err := debug.BuildDebugPod(opts.NodeName, kubectldebug.HostCommand(opts.Command), opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+	manifest, err := json.Marshal(pod)
+	if err != nil {
+		return nil, fmt.Errorf("encoding debug pod: %w", err)
+	}
+
+	s := spinner.New(spinner.CharSets[9], 200*time.Millisecond)
+	s.Suffix = " Running via command invoke..."
+	s.Start()
+	res, err := r.Invoker.Invoke(ctx, script(manifest, pod.Namespace, opts.Timeout))
+	s.Stop()
+	if err != nil {
+		return nil, err
+	}
+	return parseResult(res)
+}
+
+// script returns the kubectl commands that create the debug pod, wait at
+// most timeout seconds for it to complete, print its logs and exit code and
+// delete it.
+// As with the kube-api runtime, a namespace other than default is created if
+// it doesn't exist. The manifests are base64-encoded so they don't need
+// quoting.
+func script(manifest []byte, namespace string, timeout int) string {
+	var createNamespace string
+	if namespace != "default" {
+		ns, _ := json.Marshal(kubectldebug.Namespace(namespace))
+		createNamespace = fmt.Sprintf("kubectl get namespace %s >/dev/null 2>&1 || echo %s | base64 -d | kubectl create -f - >/dev/null\n",
+			namespace, base64.StdEncoding.EncodeToString(ns))
+	}
+	return createNamespace + fmt.Sprintf(`pod=$(echo %[1]s | base64 -d | kubectl create -f - -o jsonpath='{.metadata.name}') || exit 1
+trap 'kubectl delete pod -n %[2]s "$pod" --wait=false >/dev/null 2>&1' EXIT
+end=$(($(date +%%s) + %[3]d))
+until phase=$(kubectl get pod -n %[2]s "$pod" -o jsonpath='{.status.phase}') && [ "$phase" = Succeeded -o "$phase" = Failed ]; do
+  if [ "$(date +%%s)" -ge "$end" ]; then
+    echo "timed out waiting for debug pod $pod to complete" >&2
+    exit 124
+  fi
+  sleep 2
+done
+kubectl logs -n %[2]s "$pod" -c debug
+printf '\n%[4]s%%s\n' "$(kubectl get pod -n %[2]s "$pod" -o jsonpath='{.status.containerStatuses[?(@.name=="debug")].state.terminated.exitCode}')"`,
+		base64.StdEncoding.EncodeToString(manifest), namespace, timeout, exitCodeMarker)
+}
+
+// exitCodeMarker starts the line the script prints after the debug pod logs,
+// with the exit code of its container.
+const exitCodeMarker = "__kubectl_aks_exit_code="
+
+// splitExitCode returns the debug pod logs and the exit code the script
+// printed after them, or nil if it isn't there.
+func splitExitCode(logs string) (string, *int) {
+	i := strings.LastIndex(logs, "\n"+exitCodeMarker)
+	if i < 0 {
+		return logs, nil
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(logs[i+1+len(exitCodeMarker):]))
+	if err != nil {
+		return logs[:i], nil
+	}
+	return logs[:i], &code
+}
+
+// parseResult returns the debug pod logs from the result of the script.
+// Command invoke combines stdout and stderr, so kubectl errors are only
+// reported through the exit code.
+func parseResult(res *armcontainerservice.CommandResultProperties) (*pkgruntime.RunResult, error) {
+	logs := to.String(res.Logs)
+	if state := to.String(res.ProvisioningState); state != "Succeeded" {
+		return nil, fmt.Errorf("command invoke didn't succeed: %s %s", state, to.String(res.Reason))
+	}
+	// The script exits with 124 when the debug pod didn't complete in time.
+	if code := to.Int32(res.ExitCode); code == 124 {
+		return nil, fmt.Errorf("%w: %s", pkgruntime.ErrTimeout, strings.TrimSpace(logs))
+	} else if code != 0 {
+		return nil, fmt.Errorf("running debug pod (exit code %d): %s", code, strings.TrimSpace(logs))
+	}
+	stdout, exitCode := splitExitCode(logs)
+	return &pkgruntime.RunResult{Stdout: stdout, ExitCode: exitCode}, nil
+}
@@ -0,0 +1,154 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package akscommand
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os/exec"
+	"regexp"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+type fakeInvoker struct {
+	command string
+	result  *armcontainerservice.CommandResultProperties
+}
+
+func (f *fakeInvoker) Invoke(ctx context.Context, command string) (*armcontainerservice.CommandResultProperties, error) {
+	f.command = command
+	return f.result, nil
+}
+
+func TestRunCommand_MissingInvoker(t *testing.T) {
+	r := &Runtime{}
+	_, err := r.RunCommand(context.Background(), &pkgruntime.RunOptions{NodeName: "node1", Command: "hostname"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "AKS client is required")
+}
+
+func TestRunCommand_DebugPod(t *testing.T) {
+	inv := &fakeInvoker{result: &armcontainerservice.CommandResultProperties{
+		ProvisioningState: to.StringPtr("Succeeded"),
+		ExitCode:          to.Int32Ptr(0),
+		Logs:              to.StringPtr("aks-nodepool1-12345678-vmss000000\n"),
+	}}
+	r := &Runtime{Invoker: inv, Image: "alpine:latest", Namespace: "kube-system"}
+
+	res, err := r.RunCommand(context.Background(), &pkgruntime.RunOptions{
+		NodeName: "aks-nodepool1-12345678-vmss000000",
+		Command:  "hostname",
+		Timeout:  60,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "aks-nodepool1-12345678-vmss000000\n", res.Stdout)
+
+	assert.Contains(t, inv.command, "kubectl get namespace kube-system")
+	m := regexp.MustCompile(`pod=\$\(echo ([A-Za-z0-9+/=]+) \| base64 -d`).FindStringSubmatch(inv.command)
+	require.Len(t, m, 2, inv.command)
+	manifest, err := base64.StdEncoding.DecodeString(m[1])
+	require.NoError(t, err)
+	var pod corev1.Pod
+	require.NoError(t, json.Unmarshal(manifest, &pod))
+	assert.Equal(t, "Pod", pod.Kind)
+	assert.Equal(t, "kube-system", pod.Namespace)
+	assert.Equal(t, "aks-nodepool1-12345678-vmss000000", pod.Spec.NodeName)
+	require.Len(t, pod.Spec.Containers, 1)
+	assert.Equal(t, "alpine:latest", pod.Spec.Containers[0].Image)
+	assert.True(t, *pod.Spec.Containers[0].SecurityContext.Privileged)
+	assert.Contains(t, inv.command, "-n kube-system")
+	assert.Contains(t, inv.command, "+ 60))")
+}
+
+func TestScriptSyntax(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	out, err := exec.Command(sh, "-n", "-c", script([]byte(`{"kind":"Pod"}`), "aks-debug", 30)).CombinedOutput()
+	require.NoError(t, err, string(out))
+}
+
+func TestParseResult(t *testing.T) {
+	for _, tc := range []struct {
+		description      string
+		result           *armcontainerservice.CommandResultProperties
+		expectedExitCode *int
+		expectedErr      string
+	}{
+		{
+			description: "succeeded",
+			result: &armcontainerservice.CommandResultProperties{
+				ProvisioningState: to.StringPtr("Succeeded"),
+				ExitCode:          to.Int32Ptr(0),
+				Logs:              to.StringPtr("ok\n\n__kubectl_aks_exit_code=0\n"),
+			},
+			expectedExitCode: to.IntPtr(0),
+		},
+		{
+			description: "command failed",
+			result: &armcontainerservice.CommandResultProperties{
+				ProvisioningState: to.StringPtr("Succeeded"),
+				ExitCode:          to.Int32Ptr(0),
+				Logs:              to.StringPtr("ok\n\n__kubectl_aks_exit_code=2\n"),
+			},
+			expectedExitCode: to.IntPtr(2),
+		},
+		{
+			description: "no exit code",
+			result: &armcontainerservice.CommandResultProperties{
+				ProvisioningState: to.StringPtr("Succeeded"),
+				ExitCode:          to.Int32Ptr(0),
+				Logs:              to.StringPtr("ok\n"),
+			},
+		},
+		{
+			description: "kubectl failed",
+			result: &armcontainerservice.CommandResultProperties{
+				ProvisioningState: to.StringPtr("Succeeded"),
+				ExitCode:          to.Int32Ptr(1),
+				Logs:              to.StringPtr("Error from server (Forbidden): pods is forbidden\n"),
+			},
+			expectedErr: "exit code 1): Error from server (Forbidden)",
+		},
+		{
+			description: "debug pod timed out",
+			result: &armcontainerservice.CommandResultProperties{
+				ProvisioningState: to.StringPtr("Succeeded"),
+				ExitCode:          to.Int32Ptr(124),
+				Logs:              to.StringPtr("timed out waiting for debug pod kubectl-aks-debug-abcde to complete\n"),
+			},
+			expectedErr: "command timed out: timed out waiting for debug pod",
+		},
+		{
+			description: "command invoke failed",
+			result: &armcontainerservice.CommandResultProperties{
+				ProvisioningState: to.StringPtr("Failed"),
+				Reason:            to.StringPtr("command pod couldn't be scheduled"),
+			},
+			expectedErr: "Failed command pod couldn't be scheduled",
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			res, err := parseResult(tc.result)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "ok\n", res.Stdout)
+			assert.Equal(t, tc.expectedExitCode, res.ExitCode)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package auto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+// probeTimeout bounds the probe of a runtime, so an unreachable service
+// doesn't delay the fallback for long.
+const probeTimeout = 15 * time.Second
+
+// Candidate is a runtime the auto runtime can choose.
+type Candidate struct {
+	Name string
+	// New creates the runtime. An error means the runtime isn't usable, e.g.
+	// because of missing credentials or configuration.
+	New func() (pkgruntime.Runtime, error)
+}
+
+// Runtime executes commands with the first usable runtime among its
+// candidates, in order of preference. Runtimes implementing
+// pkgruntime.Prober are probed for the node first. When a runtime fails with a
+// retryable error, see IsRetryable, the next one is used instead.
+type Runtime struct {
+	Candidates []Candidate
+
+	mu       sync.Mutex
+	runtimes map[string]pkgruntime.Runtime
+	errs     map[string]error
+}
+
+// runtime returns the runtime of a candidate, creating it once.
+func (r *Runtime) runtime(c Candidate) (pkgruntime.Runtime, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runtimes == nil {
+		r.runtimes = make(map[string]pkgruntime.Runtime)
+		r.errs = make(map[string]error)
+	}
+	if rt, ok := r.runtimes[c.Name]; ok {
+		return rt, r.errs[c.Name]
+	}
+	rt, err := c.New()
+	r.runtimes[c.Name], r.errs[c.Name] = rt, err
+	return rt, err
+}
+
+func (r *Runtime) RunCommand(ctx context.Context, opts *pkgruntime.RunOptions) (*pkgruntime.RunResult, error) {
+	if len(r.Candidates) == 0 {
+		return nil, fmt.Errorf("no runtime to choose from")
+	}
+
+	var reasons []string
+	for _, c := range r.Candidates {
+		rt, err := r.runtime(c)
+		if err == nil {
+			err = probe(ctx, rt, opts.NodeName)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Debugf("Not using runtime %s for node %s: %s", c.Name, opts.NodeName, err)
+			reasons = append(reasons, fmt.Sprintf("%s: %s", c.Name, err))
+			continue
+		}
+
+		res, err := rt.RunCommand(ctx, opts)
+		if err == nil {
+			res.Runtime = c.Name
+			return res, nil
+		}
+		if !IsRetryable(err) {
+			return nil, fmt.Errorf("%s runtime: %w", c.Name, err)
+		}
+		log.Warnf("Runtime %s failed on node %s, trying the next one: %s", c.Name, opts.NodeName, err)
+		reasons = append(reasons, fmt.Sprintf("%s: %s", c.Name, err))
+	}
+	return nil, fmt.Errorf("no usable runtime for node %s:\n  %s", opts.NodeName, strings.Join(reasons, "\n  "))
+}
+
+// NodeOS returns the OS of a node as told by the first candidate able to, or
+// Linux if none is.
+func (r *Runtime) NodeOS(ctx context.Context, nodeName string) (pkgruntime.OS, error) {
+	for _, c := range r.Candidates {
+		rt, err := r.runtime(c)
+		if err != nil {
+			continue
+		}
+		if d, ok := rt.(pkgruntime.OSDetector); ok {
+			return d.NodeOS(ctx, nodeName)
+		}
+	}
+	return pkgruntime.OSLinux, nil
+}
+
+// probe checks whether rt is usable for a node, if it can tell.
+func probe(ctx context.Context, rt pkgruntime.Runtime, nodeName string) error {
+	p, ok := rt.(pkgruntime.Prober)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	return p.Probe(ctx, nodeName)
+}
+
+// IsRetryable returns whether a runtime error means the command didn't start
+// because of the runtime, so another runtime may succeed: another RunCommand
+// in progress on the VM, throttling, or an unreachable or unavailable API
+// server. Errors the runtime didn't mark with pkgruntime.NotStarted never
+// are, since the command may have run, and running it again may not be safe.
+func IsRetryable(err error) bool {
+	if !errors.Is(err, pkgruntime.ErrNotStarted) {
+		return false
+	}
+	// context.DeadlineExceeded is a net.Error too.
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode == http.StatusConflict || respErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	if apierrors.IsServiceUnavailable(err) || apierrors.IsServerTimeout(err) || apierrors.IsTooManyRequests(err) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,164 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package auto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
+)
+
+type fakeRuntime struct {
+	probeErr error
+	runErr   error
+	runs     int
+}
+
+func (f *fakeRuntime) RunCommand(ctx context.Context, opts *pkgruntime.RunOptions) (*pkgruntime.RunResult, error) {
+	f.runs++
+	if f.runErr != nil {
+		return nil, f.runErr
+	}
+	return &pkgruntime.RunResult{Stdout: opts.NodeName + "\n"}, nil
+}
+
+func (f *fakeRuntime) Probe(ctx context.Context, nodeName string) error {
+	return f.probeErr
+}
+
+func candidate(name string, rt *fakeRuntime) Candidate {
+	return Candidate{Name: name, New: func() (pkgruntime.Runtime, error) { return rt, nil }}
+}
+
+func conflictErr() error {
+	return pkgruntime.NotStarted(fmt.Errorf("begin running command: %w", &azcore.ResponseError{
+		StatusCode: http.StatusConflict,
+		ErrorCode:  "Conflict",
+	}))
+}
+
+func unreachableErr() error {
+	return pkgruntime.NotStarted(fmt.Errorf("creating debug pod: %w", &url.Error{
+		Op:  "Post",
+		URL: "https://myaks.hcp.eastus.azmk8s.io/api/v1/namespaces/default/pods",
+		Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+	}))
+}
+
+func TestRunCommand(t *testing.T) {
+	for _, tc := range []struct {
+		description     string
+		agent, kubeAPI  *fakeRuntime
+		expectedRuntime string
+		expectedErr     string
+	}{
+		{
+			description:     "first usable",
+			agent:           &fakeRuntime{},
+			kubeAPI:         &fakeRuntime{},
+			expectedRuntime: "agent",
+		},
+		{
+			description:     "probe fails",
+			agent:           &fakeRuntime{probeErr: errors.New("no running agent pod")},
+			kubeAPI:         &fakeRuntime{},
+			expectedRuntime: "kube-api",
+		},
+		{
+			description:     "retryable error",
+			agent:           &fakeRuntime{runErr: conflictErr()},
+			kubeAPI:         &fakeRuntime{},
+			expectedRuntime: "kube-api",
+		},
+		{
+			description: "non-retryable error",
+			agent:       &fakeRuntime{runErr: errors.New("command execution didn't succeed")},
+			kubeAPI:     &fakeRuntime{},
+			expectedErr: "agent runtime: command execution didn't succeed",
+		},
+		{
+			description: "network error after the command started",
+			agent:       &fakeRuntime{runErr: errors.Unwrap(unreachableErr())},
+			kubeAPI:     &fakeRuntime{},
+			expectedErr: "agent runtime: creating debug pod",
+		},
+		{
+			description: "no usable runtime",
+			agent:       &fakeRuntime{probeErr: errors.New("no running agent pod")},
+			kubeAPI:     &fakeRuntime{runErr: unreachableErr()},
+			expectedErr: "no usable runtime for node node1:\n  agent: no running agent pod\n  kube-api: creating debug pod",
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			r := &Runtime{Candidates: []Candidate{
+				candidate("agent", tc.agent),
+				candidate("kube-api", tc.kubeAPI),
+			}}
+			res, err := r.RunCommand(context.Background(), &pkgruntime.RunOptions{NodeName: "node1", Command: "hostname"})
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "node1\n", res.Stdout)
+			assert.Equal(t, tc.expectedRuntime, res.Runtime)
+		})
+	}
+}
+
+func TestRunCommand_NewError(t *testing.T) {
+	calls := 0
+	kubeAPI := &fakeRuntime{}
+	r := &Runtime{Candidates: []Candidate{
+		{Name: "azure-api", New: func() (pkgruntime.Runtime, error) {
+			calls++
+			return nil, errors.New("authenticating")
+		}},
+		candidate("kube-api", kubeAPI),
+	}}
+	for i := 0; i < 2; i++ {
+		res, err := r.RunCommand(context.Background(), &pkgruntime.RunOptions{NodeName: "node1", Command: "hostname"})
+		require.NoError(t, err)
+		assert.Equal(t, "kube-api", res.Runtime)
+	}
+	// Runtimes are only created once.
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 2, kubeAPI.runs)
+}
+
+func TestIsRetryable(t *testing.T) {
+	gr := schema.GroupResource{Resource: "pods"}
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{conflictErr(), true},
+		{pkgruntime.NotStarted(&azcore.ResponseError{StatusCode: http.StatusTooManyRequests}), true},
+		{pkgruntime.NotStarted(&azcore.ResponseError{StatusCode: http.StatusNotFound}), false},
+		{unreachableErr(), true},
+		{pkgruntime.NotStarted(apierrors.NewServiceUnavailable("etcd unavailable")), true},
+		{pkgruntime.NotStarted(apierrors.NewForbidden(gr, "debug", errors.New("denied"))), false},
+		// The command may have started before the connection was lost.
+		{fmt.Errorf("polling command response: %w", &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}), false},
+		{fmt.Errorf("polling command response: %w", &azcore.ResponseError{StatusCode: http.StatusTooManyRequests}), false},
+		{fmt.Errorf("waiting for debug pod to complete: %w", context.DeadlineExceeded), false},
+		{context.Canceled, false},
+		{errors.New("no response received after command execution"), false},
+	} {
+		assert.Equal(t, tc.retryable, IsRetryable(tc.err), tc.err.Error())
+	}
+}
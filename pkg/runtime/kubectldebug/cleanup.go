@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package kubectldebug
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	managedByLabel    = "app.kubernetes.io/managed-by"
+	OwnerAnnotation   = "kubectl-aks.azure.com/owner"
+	sessionAnnotation = "kubectl-aks.azure.com/session"
+
+	// activeDeadlineMargin is added to the command timeout for the
+	// activeDeadlineSeconds of debug pods, so leaked pods stop running.
+	activeDeadlineMargin = 60
+
+	// DefaultCleanupTTL is the minimum age of leaked debug pods, so the
+	// sessions that just created them still get their logs.
+	DefaultCleanupTTL = time.Hour
+
+	// staleMargin is added to the active deadline of debug pods before
+	// they're considered leaked, so the session waiting for them has given up.
+	staleMargin = 5 * time.Minute
+)
+
+// Session identifies the kubectl-aks process that creates debug pods.
+var Session = newSession()
+
+func newSession() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", os.Getpid())
+	}
+	return hex.EncodeToString(b)
+}
+
+// owner returns the user and host running kubectl-aks.
+func owner() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
+
+// isDebugPod returns whether a pod is a debug pod created by kubectl-aks, as
+// opposed to e.g. the pods of the agent DaemonSet.
+func isDebugPod(pod *corev1.Pod) bool {
+	if pod.Labels[managedByLabel] != "kubectl-aks" || len(pod.OwnerReferences) > 0 {
+		return false
+	}
+	_, ok := pod.Annotations[sessionAnnotation]
+	return ok || strings.HasPrefix(pod.Name, podPrefix)
+}
+
+// isStale returns whether a debug pod was leaked: it was created more than
+// ttl ago, and it completed or its active deadline passed, so no session is
+// waiting for it whatever its command timeout.
+func isStale(pod *corev1.Pod, ttl time.Duration, now time.Time) bool {
+	age := now.Sub(pod.CreationTimestamp.Time)
+	if age <= ttl {
+		return false
+	}
+	switch {
+	case pod.Status.Phase == corev1.PodSucceeded, pod.Status.Phase == corev1.PodFailed:
+		return true
+	case pod.Spec.ActiveDeadlineSeconds != nil:
+		return age > time.Duration(*pod.Spec.ActiveDeadlineSeconds)*time.Second+staleMargin
+	default:
+		// Pods created by older versions have no deadline.
+		return true
+	}
+}
+
+// StalePods returns the leaked debug pods of all namespaces, see isStale.
+func StalePods(ctx context.Context, clientset kubernetes.Interface, ttl time.Duration) ([]corev1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: managedByLabel + "=kubectl-aks",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing debug pods: %w", err)
+	}
+
+	now := time.Now()
+	var stale []corev1.Pod
+	for _, pod := range pods.Items {
+		if isDebugPod(&pod) && isStale(&pod, ttl, now) {
+			stale = append(stale, pod)
+		}
+	}
+	return stale, nil
+}
+
+// Cleanup deletes the leaked debug pods, see isStale, and returns them.
+func Cleanup(ctx context.Context, clientset kubernetes.Interface, ttl time.Duration) ([]corev1.Pod, error) {
+	stale, err := StalePods(ctx, clientset, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []corev1.Pod
+	for _, pod := range stale {
+		err := clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return deleted, fmt.Errorf("deleting debug pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+		deleted = append(deleted, pod)
+	}
+	return deleted, nil
+}
@@ -6,6 +6,7 @@ package kubectldebug
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"time"
@@ -32,6 +33,9 @@ type Runtime struct {
 	Config    *rest.Config
 	Image     string
 	Namespace string
+	// Profile is a strategic merge patch applied to the debug pod, see
+	// ApplyProfile.
+	Profile []byte
 }
 
 func (r *Runtime) image() string {
@@ -56,68 +60,107 @@ func (r *Runtime) RunCommand(ctx context.Context, opts *pkgruntime.RunOptions) (
 		return nil, fmt.Errorf("node name is required for kube-api runtime")
 	}
 
-	// Use nsenter to get host-level access, matching VMSS RunCommand behavior
-	nsenterCmd := fmt.Sprintf("nsenter -t 1 -m -u -i -n -p -- sh -c '%s'", opts.Command)
-
-	pod := r.buildDebugPod(opts.NodeName, nsenterCmd)
+	pod, err := r.BuildDebugPod(opts.NodeName, HostCommand(opts.Command), opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	ns := pod.Namespace
 
 	s := spinner.New(spinner.CharSets[9], 200*time.Millisecond)
 	s.Suffix = " Creating debug pod..."
 	s.Start()
 
+	if err := r.ensureNamespace(ctx, ns); err != nil {
+		s.Stop()
+		return nil, pkgruntime.NotStarted(err)
+	}
+
 	// Create the debug pod
-	createdPod, err := r.Clientset.CoreV1().Pods(r.namespace()).Create(ctx, pod, metav1.CreateOptions{})
+	createdPod, err := r.Clientset.CoreV1().Pods(ns).Create(ctx, pod, metav1.CreateOptions{})
 	if err != nil {
 		s.Stop()
-		return nil, fmt.Errorf("creating debug pod: %w", err)
+		return nil, pkgruntime.NotStarted(fmt.Errorf("creating debug pod: %w", err))
 	}
 
 	// Always clean up the pod
 	defer func() {
 		cleanupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
-		_ = r.Clientset.CoreV1().Pods(r.namespace()).Delete(cleanupCtx, createdPod.Name, metav1.DeleteOptions{})
+		_ = r.Clientset.CoreV1().Pods(ns).Delete(cleanupCtx, createdPod.Name, metav1.DeleteOptions{})
 	}()
 
 	s.Suffix = " Running..."
 
 	// Wait for pod to complete
-	if err := r.waitForPodComplete(ctx, createdPod.Name, opts.Timeout); err != nil {
+	completedPod, err := r.waitForPodComplete(ctx, ns, createdPod.Name, opts.Timeout)
+	if err != nil {
 		s.Stop()
+		if errors.Is(err, wait.ErrWaitTimeout) {
+			err = fmt.Errorf("%w after %d seconds", pkgruntime.ErrTimeout, opts.Timeout)
+		}
 		return nil, fmt.Errorf("waiting for debug pod to complete: %w", err)
 	}
 
 	s.Stop()
 
 	// Get logs (stdout/stderr combined in container logs)
-	stdout, err := r.getPodLogs(ctx, createdPod.Name)
+	stdout, err := r.getPodLogs(ctx, ns, createdPod.Name)
 	if err != nil {
 		return nil, fmt.Errorf("getting debug pod logs: %w", err)
 	}
 
 	return &pkgruntime.RunResult{
-		Stdout: stdout,
-		Stderr: "",
+		Stdout:   stdout,
+		Stderr:   "",
+		ExitCode: exitCode(completedPod),
 	}, nil
 }
 
-func (r *Runtime) buildDebugPod(nodeName, command string) *corev1.Pod {
+// exitCode returns the exit code of the debug container of a completed pod,
+// or nil if it didn't terminate, e.g. when the pod couldn't start.
+func exitCode(pod *corev1.Pod) *int {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == "debug" && cs.State.Terminated != nil {
+			code := int(cs.State.Terminated.ExitCode)
+			return &code
+		}
+	}
+	return nil
+}
+
+// HostCommand returns the debug pod command that runs command on the host.
+// It uses nsenter to get host-level access, matching VMSS RunCommand behavior.
+func HostCommand(command string) string {
+	return "nsenter -t 1 -m -u -i -n -p -- sh -c " + pkgruntime.Quote(command)
+}
+
+// BuildDebugPod returns the privileged pod that runs command on nodeName for
+// at most timeout seconds, with the profile applied. By default, it tolerates
+// all taints so it can run on any node. It's annotated with the session and
+// owner that created it, so it can be garbage collected if it leaks.
+func (r *Runtime) BuildDebugPod(nodeName, command string, timeout int) (*corev1.Pod, error) {
 	privileged := true
 	hostPID := true
 	zero := int64(0)
+	deadline := int64(timeout + activeDeadlineMargin)
 
-	return &corev1.Pod{
+	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: podPrefix,
 			Namespace:    r.namespace(),
 			Labels: map[string]string{
-				"app.kubernetes.io/managed-by": "kubectl-aks",
+				managedByLabel: "kubectl-aks",
+			},
+			Annotations: map[string]string{
+				OwnerAnnotation:   owner(),
+				sessionAnnotation: Session,
 			},
 		},
 		Spec: corev1.PodSpec{
-			NodeName:      nodeName,
-			HostPID:       hostPID,
-			RestartPolicy: corev1.RestartPolicyNever,
+			NodeName:              nodeName,
+			ActiveDeadlineSeconds: &deadline,
+			HostPID:               hostPID,
+			RestartPolicy:         corev1.RestartPolicyNever,
 			Containers: []corev1.Container{
 				{
 					Name:    "debug",
@@ -128,16 +171,26 @@ func (r *Runtime) buildDebugPod(nodeName, command string) *corev1.Pod {
 					},
 				},
 			},
+			Tolerations: []corev1.Toleration{
+				{Operator: corev1.TolerationOpExists},
+			},
 			TerminationGracePeriodSeconds: &zero,
 		},
 	}
+	if len(r.Profile) == 0 {
+		return pod, nil
+	}
+	return ApplyProfile(pod, r.Profile)
 }
 
-func (r *Runtime) waitForPodComplete(ctx context.Context, podName string, timeoutSeconds int) error {
+// waitForPodComplete waits for a pod to succeed or fail, and returns it.
+func (r *Runtime) waitForPodComplete(ctx context.Context, namespace, podName string, timeoutSeconds int) (*corev1.Pod, error) {
 	timeout := time.Duration(timeoutSeconds) * time.Second
 
-	return wait.PollImmediate(2*time.Second, timeout, func() (bool, error) {
-		pod, err := r.Clientset.CoreV1().Pods(r.namespace()).Get(ctx, podName, metav1.GetOptions{})
+	var pod *corev1.Pod
+	err := wait.PollImmediateWithContext(ctx, 2*time.Second, timeout, func(ctx context.Context) (bool, error) {
+		var err error
+		pod, err = r.Clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
 		if err != nil {
 			return false, err
 		}
@@ -148,10 +201,18 @@ func (r *Runtime) waitForPodComplete(ctx context.Context, podName string, timeou
 			return false, nil
 		}
 	})
+	// The poll reports a cancelled ctx as a timeout.
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pod, nil
 }
 
-func (r *Runtime) getPodLogs(ctx context.Context, podName string) (string, error) {
-	req := r.Clientset.CoreV1().Pods(r.namespace()).GetLogs(podName, &corev1.PodLogOptions{
+func (r *Runtime) getPodLogs(ctx context.Context, namespace, podName string) (string, error) {
+	req := r.Clientset.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{
 		Container: "debug",
 	})
 	stream, err := req.Stream(ctx)
@@ -167,3 +228,33 @@ func (r *Runtime) getPodLogs(ctx context.Context, podName string) (string, error
 
 	return buf.String(), nil
 }
+
+// Probe checks that the API server is reachable and the node is a ready Linux
+// node, on which a debug pod can run.
+func (r *Runtime) Probe(ctx context.Context, nodeName string) error {
+	if r.Clientset == nil {
+		return fmt.Errorf("kubernetes clientset is required for kube-api runtime")
+	}
+	return ProbeNode(ctx, r.Clientset, nodeName)
+}
+
+// ProbeNode checks that the API server is reachable and the node is a ready
+// Linux node.
+func ProbeNode(ctx context.Context, clientset kubernetes.Interface, nodeName string) error {
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting node %s: %w", nodeName, err)
+	}
+	if os := node.Labels[corev1.LabelOSStable]; os != "" && os != string(pkgruntime.OSLinux) {
+		return fmt.Errorf("node %s runs %s", nodeName, os)
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			if c.Status != corev1.ConditionTrue {
+				return fmt.Errorf("node %s isn't ready: %s", nodeName, c.Message)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("node %s isn't ready: no Ready condition", nodeName)
+}
@@ -6,6 +6,7 @@ package kubectldebug
 import (
 	"context"
 	"testing"
+	"time"
 
 	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
 	"github.com/stretchr/testify/assert"
@@ -45,7 +46,8 @@ func TestBuildDebugPod(t *testing.T) {
 		Namespace: "kube-system",
 	}
 
-	pod := r.buildDebugPod("my-node", "nsenter -t 1 -m -u -i -n -p -- sh -c 'hostname'")
+	pod, err := r.BuildDebugPod("my-node", HostCommand("hostname"), 30)
+	require.NoError(t, err)
 
 	assert.Equal(t, "kube-system", pod.Namespace)
 	assert.Equal(t, podPrefix, pod.GenerateName)
@@ -54,8 +56,17 @@ func TestBuildDebugPod(t *testing.T) {
 	assert.Equal(t, corev1.RestartPolicyNever, pod.Spec.RestartPolicy)
 	require.Len(t, pod.Spec.Containers, 1)
 	assert.Equal(t, "alpine:latest", pod.Spec.Containers[0].Image)
+	assert.Equal(t, []string{"sh", "-c", "nsenter -t 1 -m -u -i -n -p -- sh -c 'hostname'"}, pod.Spec.Containers[0].Command)
 	assert.True(t, *pod.Spec.Containers[0].SecurityContext.Privileged)
 	assert.Equal(t, "kubectl-aks", pod.Labels["app.kubernetes.io/managed-by"])
+	assert.Equal(t, []corev1.Toleration{{Operator: corev1.TolerationOpExists}}, pod.Spec.Tolerations)
+	assert.Equal(t, int64(30+activeDeadlineMargin), *pod.Spec.ActiveDeadlineSeconds)
+	assert.Equal(t, Session, pod.Annotations[sessionAnnotation])
+	assert.NotEmpty(t, pod.Annotations[OwnerAnnotation])
+}
+
+func TestHostCommand(t *testing.T) {
+	assert.Equal(t, `nsenter -t 1 -m -u -i -n -p -- sh -c 'echo '\''it works'\'''`, HostCommand("echo 'it works'"))
 }
 
 func TestDefaultImageAndNamespace(t *testing.T) {
@@ -96,7 +107,7 @@ func TestRunCommand_PodCreatedAndCleaned(t *testing.T) {
 	})
 
 	// Expect timeout error since fake pods don't transition to Succeeded
-	assert.Error(t, err)
+	assert.ErrorIs(t, err, pkgruntime.ErrTimeout)
 	assert.Contains(t, err.Error(), "waiting for debug pod")
 
 	// Verify pod was cleaned up
@@ -106,14 +117,18 @@ func TestRunCommand_PodCreatedAndCleaned(t *testing.T) {
 }
 
 func TestWaitForPodComplete(t *testing.T) {
-	// Create a fake client with a pod already in Succeeded state
+	// Create a fake client with a pod already in Failed state
 	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "test-pod",
 			Namespace: "default",
 		},
 		Status: corev1.PodStatus{
-			Phase: corev1.PodSucceeded,
+			Phase: corev1.PodFailed,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "debug",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 2}},
+			}},
 		},
 	}
 	clientset := fake.NewSimpleClientset(pod)
@@ -122,6 +137,175 @@ func TestWaitForPodComplete(t *testing.T) {
 		Namespace: "default",
 	}
 
-	err := r.waitForPodComplete(context.Background(), "test-pod", 10)
-	assert.NoError(t, err)
+	completed, err := r.waitForPodComplete(context.Background(), "default", "test-pod", 10)
+	require.NoError(t, err)
+	require.NotNil(t, exitCode(completed))
+	assert.Equal(t, 2, *exitCode(completed))
+}
+
+func TestWaitForPodComplete_Cancelled(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	r := &Runtime{Clientset: fake.NewSimpleClientset(pod)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err := r.waitForPodComplete(ctx, "default", "test-pod", 10)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestApplyProfile(t *testing.T) {
+	r := &Runtime{
+		Image: "alpine:latest",
+		Profile: []byte(`metadata:
+  namespace: aks-debug
+  labels:
+    team: platform
+  annotations:
+    sidecar.istio.io/inject: "false"
+spec:
+  priorityClassName: system-node-critical
+  serviceAccountName: aks-debug
+  imagePullSecrets:
+  - name: acr
+  tolerations:
+  - key: sku
+    value: gpu
+    effect: NoSchedule
+  containers:
+  - name: debug
+    resources:
+      requests:
+        cpu: 10m
+        memory: 32Mi
+`),
+	}
+
+	pod, err := r.BuildDebugPod("my-node", HostCommand("hostname"), 30)
+	require.NoError(t, err)
+
+	assert.Equal(t, "aks-debug", pod.Namespace)
+	assert.Equal(t, "kubectl-aks", pod.Labels["app.kubernetes.io/managed-by"])
+	assert.Equal(t, "platform", pod.Labels["team"])
+	assert.Equal(t, "false", pod.Annotations["sidecar.istio.io/inject"])
+	assert.Equal(t, "system-node-critical", pod.Spec.PriorityClassName)
+	assert.Equal(t, "aks-debug", pod.Spec.ServiceAccountName)
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "acr"}}, pod.Spec.ImagePullSecrets)
+	assert.Equal(t, []corev1.Toleration{{Key: "sku", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}}, pod.Spec.Tolerations)
+	assert.Equal(t, "my-node", pod.Spec.NodeName)
+	require.Len(t, pod.Spec.Containers, 1)
+	assert.Equal(t, "alpine:latest", pod.Spec.Containers[0].Image)
+	assert.True(t, *pod.Spec.Containers[0].SecurityContext.Privileged)
+	assert.Equal(t, "10m", pod.Spec.Containers[0].Resources.Requests.Cpu().String())
+}
+
+func TestApplyProfileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		profile     string
+		expectedErr string
+	}{
+		{"invalid YAML", "spec: [", "parsing debug pod profile"},
+		{"unknown field", "spec:\n  priorityClass: high", "unknown field"},
+		{"node name", "spec:\n  nodeName: other-node", "can't change the node"},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			r := &Runtime{Profile: []byte(tc.profile)}
+			_, err := r.BuildDebugPod("my-node", HostCommand("hostname"), 30)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestRunCommand_CreatesNamespace(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	r := &Runtime{
+		Clientset: clientset,
+		Profile:   []byte("metadata:\n  namespace: aks-debug\n"),
+	}
+
+	// The pod stays in Pending, as in TestRunCommand_PodCreatedAndCleaned.
+	_, err := r.RunCommand(context.Background(), &pkgruntime.RunOptions{
+		NodeName: "node1",
+		Command:  "hostname",
+		Timeout:  1,
+	})
+	assert.Error(t, err)
+
+	ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), "aks-debug", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "privileged", ns.Labels["pod-security.kubernetes.io/enforce"])
+}
+
+func TestCleanup(t *testing.T) {
+	pod := func(namespace, name string, age time.Duration, labels, annotations map[string]string, owners ...metav1.OwnerReference) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         namespace,
+				Labels:            labels,
+				Annotations:       annotations,
+				OwnerReferences:   owners,
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+			},
+		}
+	}
+	managed := map[string]string{managedByLabel: "kubectl-aks"}
+	session := map[string]string{sessionAnnotation: "abcd1234"}
+	withDeadline := func(p *corev1.Pod, seconds int64) *corev1.Pod {
+		p.Spec.ActiveDeadlineSeconds = &seconds
+		return p
+	}
+	completed := withDeadline(pod("default", "kubectl-aks-debug-completed", 2*time.Hour, managed, session), 4*3600)
+	completed.Status.Phase = corev1.PodSucceeded
+
+	clientset := fake.NewSimpleClientset(
+		withDeadline(pod("default", "kubectl-aks-debug-old", 2*time.Hour, managed, session), 360),
+		pod("aks-debug", "custom-old", 2*time.Hour, managed, session),
+		pod("default", "kubectl-aks-debug-legacy", 2*time.Hour, managed, nil),
+		completed,
+		// Still running a command with a 2 hours timeout.
+		withDeadline(pod("default", "kubectl-aks-debug-long", 2*time.Hour, managed, session), 7260),
+		pod("default", "kubectl-aks-debug-recent", time.Minute, managed, session),
+		pod("kubectl-aks", "kubectl-aks-agent-x", 2*time.Hour, managed, nil, metav1.OwnerReference{Kind: "DaemonSet", Name: "kubectl-aks-agent"}),
+		pod("default", "kubectl-aks-debug-unmanaged", 2*time.Hour, nil, session),
+	)
+
+	ctx := context.Background()
+	deleted, err := Cleanup(ctx, clientset, time.Hour)
+	require.NoError(t, err)
+	var names []string
+	for _, p := range deleted {
+		names = append(names, p.Name)
+	}
+	assert.ElementsMatch(t, []string{"kubectl-aks-debug-old", "custom-old", "kubectl-aks-debug-legacy", "kubectl-aks-debug-completed"}, names)
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, pods.Items, 4)
+}
+
+func TestProbeNode(t *testing.T) {
+	node := func(name, os string, ready corev1.ConditionStatus) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{corev1.LabelOSStable: os}},
+			Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: ready, Message: "kubelet stopped posting node status"},
+			}},
+		}
+	}
+	r := &Runtime{Clientset: fake.NewSimpleClientset(
+		node("ready", "linux", corev1.ConditionTrue),
+		node("notready", "linux", corev1.ConditionUnknown),
+		node("windows", "windows", corev1.ConditionTrue),
+	)}
+	ctx := context.Background()
+
+	assert.NoError(t, r.Probe(ctx, "ready"))
+	assert.ErrorContains(t, r.Probe(ctx, "notready"), "isn't ready: kubelet stopped posting node status")
+	assert.ErrorContains(t, r.Probe(ctx, "windows"), "runs windows")
+	assert.ErrorContains(t, r.Probe(ctx, "missing"), "getting node missing")
 }
@@ -0,0 +1,96 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package kubectldebug
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+)
+
+// ApplyProfile applies a debug pod profile to pod. The profile is a strategic
+// merge patch of the pod, in YAML or JSON, e.g.:
+//
+//	metadata:
+//	  namespace: aks-debug
+//	spec:
+//	  priorityClassName: system-node-critical
+//	  containers:
+//	  - name: debug
+//	    resources:
+//	      requests:
+//	        cpu: 10m
+func ApplyProfile(pod *corev1.Pod, profile []byte) (*corev1.Pod, error) {
+	patch, err := yaml.YAMLToJSON(profile)
+	if err != nil {
+		return nil, fmt.Errorf("parsing debug pod profile: %w", err)
+	}
+	original, err := json.Marshal(pod)
+	if err != nil {
+		return nil, fmt.Errorf("encoding debug pod: %w", err)
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, patch, corev1.Pod{})
+	if err != nil {
+		return nil, fmt.Errorf("applying debug pod profile: %w", err)
+	}
+
+	// Unknown fields are most likely typos, which would otherwise be
+	// silently ignored.
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	var res corev1.Pod
+	if err := dec.Decode(&res); err != nil {
+		return nil, fmt.Errorf("applying debug pod profile: %w", err)
+	}
+	if res.Spec.NodeName != pod.Spec.NodeName {
+		return nil, fmt.Errorf("debug pod profile can't change the node of the pod")
+	}
+	if res.Namespace == "" {
+		res.Namespace = defaultNamespace
+	}
+	return &res, nil
+}
+
+// Namespace returns the namespace created for debug pods, labeled so that Pod
+// Security Admission allows privileged pods.
+func Namespace(name string) *corev1.Namespace {
+	return &corev1.Namespace{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by":       "kubectl-aks",
+				"pod-security.kubernetes.io/enforce": "privileged",
+				"pod-security.kubernetes.io/audit":   "privileged",
+				"pod-security.kubernetes.io/warn":    "privileged",
+			},
+		},
+	}
+}
+
+// ensureNamespace creates the namespace of the debug pods if it doesn't
+// exist. Existing namespaces are left as they are.
+func (r *Runtime) ensureNamespace(ctx context.Context, name string) error {
+	if name == defaultNamespace {
+		return nil
+	}
+	_, err := r.Clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err == nil || !apierrors.IsNotFound(err) {
+		// Without permission to get namespaces, creating the pod tells
+		// whether the namespace is usable.
+		return nil
+	}
+	_, err = r.Clientset.CoreV1().Namespaces().Create(ctx, Namespace(name), metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("creating namespace %s: %w", name, err)
+	}
+	return nil
+}
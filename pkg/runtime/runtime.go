@@ -3,13 +3,61 @@
 
 package runtime
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrTimeout is returned by runtimes when a command didn't complete before
+// its timeout.
+var ErrTimeout = errors.New("command timed out")
+
+// ErrNotStarted is matched by the errors of runtimes that failed before
+// starting the command on the node, see NotStarted.
+var ErrNotStarted = errors.New("command not started")
+
+// NotStarted marks err as happening before the command was started on the
+// node, so running it again, e.g. with another runtime, is safe. The message
+// of err is kept.
+func NotStarted(err error) error {
+	return &notStartedError{err: err}
+}
+
+type notStartedError struct {
+	err error
+}
+
+func (e *notStartedError) Error() string        { return e.err.Error() }
+func (e *notStartedError) Unwrap() error        { return e.err }
+func (e *notStartedError) Is(target error) bool { return target == ErrNotStarted }
 
 // Runtime abstracts command execution on a node.
 type Runtime interface {
 	RunCommand(ctx context.Context, opts *RunOptions) (*RunResult, error)
 }
 
+// OS is the operating system of a node, as in the kubernetes.io/os label.
+type OS string
+
+const (
+	OSLinux   OS = "linux"
+	OSWindows OS = "windows"
+)
+
+// OSDetector is an optional interface for runtimes that can tell the
+// operating system of a node before running a command on it. Nodes of
+// runtimes that don't implement it are assumed to run Linux.
+type OSDetector interface {
+	NodeOS(ctx context.Context, nodeName string) (OS, error)
+}
+
+// Prober is an optional interface for runtimes that can tell whether they're
+// usable for a node, e.g. whether the services they rely on are reachable,
+// without running a command on it.
+type Prober interface {
+	Probe(ctx context.Context, nodeName string) error
+}
+
 // RunOptions contains the options for running a command on a node.
 type RunOptions struct {
 	NodeName string
@@ -21,4 +69,10 @@ type RunOptions struct {
 type RunResult struct {
 	Stdout string
 	Stderr string
+	// ExitCode is the exit code of the command, or nil when the runtime
+	// can't tell it. As with GNU timeout, 124 means the command timed out.
+	ExitCode *int
+	// Runtime is the name of the runtime that ran the command, set by
+	// runtimes choosing among others.
+	Runtime string
 }
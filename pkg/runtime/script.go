@@ -0,0 +1,82 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package runtime
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Quote returns s quoted as a single word for a POSIX shell. Every runtime
+// running commands through a shell quotes them with it.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// envNameRe matches the valid names of environment variables.
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateEnv checks that env contains KEY=VALUE pairs.
+func ValidateEnv(env []string) error {
+	for _, kv := range env {
+		name, _, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid environment variable %q: expected KEY=VALUE", kv)
+		}
+		if !envNameRe.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// envCommand returns the command running args with env set.
+func envCommand(env []string, args ...string) string {
+	words := make([]string, 0, len(env)+len(args)+1)
+	if len(env) > 0 {
+		words = append(words, "env")
+		for _, kv := range env {
+			words = append(words, Quote(kv))
+		}
+	}
+	return strings.Join(append(words, args...), " ")
+}
+
+// CommandWithEnv returns a shell command running command with the KEY=VALUE
+// pairs of env set.
+func CommandWithEnv(command string, env []string) string {
+	if len(env) == 0 {
+		return command
+	}
+	return envCommand(env, "sh", "-c", Quote(command))
+}
+
+// Script is a script run on a node by an interpreter.
+type Script struct {
+	Content []byte
+	// Interpreter is the program running the script, sh by default.
+	Interpreter string
+	Args        []string
+	// Env contains the KEY=VALUE pairs set for the script.
+	Env []string
+}
+
+// Command returns a shell command running the script. The script is
+// transported base64-encoded and decoded to a temporary file on the node, so
+// its content needs no quoting. The command is a subshell, so it can be piped
+// like a single command.
+func (s *Script) Command() string {
+	interpreter := s.Interpreter
+	if interpreter == "" {
+		interpreter = "sh"
+	}
+	args := []string{Quote(interpreter), `"$f"`}
+	for _, arg := range s.Args {
+		args = append(args, Quote(arg))
+	}
+	return fmt.Sprintf(`(f=$(mktemp) || exit 1; echo %s | base64 -d >"$f"; %s; rc=$?; rm -f "$f"; exit $rc)`,
+		base64.StdEncoding.EncodeToString(s.Content), envCommand(s.Env, args...))
+}
@@ -0,0 +1,64 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package runtime
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runShell runs command with sh -c, as the runtimes do on the node.
+func runShell(t *testing.T, command string) string {
+	t.Helper()
+	for _, tool := range []string{"sh", "base64", "mktemp", "env"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+	out, err := exec.Command("sh", "-c", command).CombinedOutput()
+	require.NoError(t, err, string(out))
+	return string(out)
+}
+
+func TestQuote(t *testing.T) {
+	assert.Equal(t, "'hostname'", Quote("hostname"))
+	assert.Equal(t, `'echo '\''it works'\'''`, Quote("echo 'it works'"))
+	assert.Equal(t, "it's $HOME `id`\n", runShell(t, "echo "+Quote("it's $HOME `id`")))
+}
+
+func TestValidateEnv(t *testing.T) {
+	assert.NoError(t, ValidateEnv([]string{"FOO=bar", "_EMPTY=", "URL=http://a?b=c"}))
+	assert.ErrorContains(t, ValidateEnv([]string{"FOO"}), "expected KEY=VALUE")
+	assert.ErrorContains(t, ValidateEnv([]string{"1FOO=bar"}), "invalid environment variable name")
+}
+
+func TestCommandWithEnv(t *testing.T) {
+	assert.Equal(t, "hostname", CommandWithEnv("hostname", nil))
+	out := runShell(t, CommandWithEnv(`echo "$GREETING, it's $NAME"`, []string{"GREETING=hello", "NAME=O'Brien"}))
+	assert.Equal(t, "hello, it's O'Brien\n", out)
+}
+
+func TestScriptCommand(t *testing.T) {
+	s := &Script{
+		Content: []byte("#!/bin/sh\necho \"args: $1 / $2\"\necho 'env:' \"$LEVEL\"\nexit 3\n"),
+		Args:    []string{"it's", "two words"},
+		Env:     []string{"LEVEL=debug"},
+	}
+	// The script can be piped like a single command.
+	out := runShell(t, s.Command()+" | tr a-z A-Z")
+	assert.Equal(t, "ARGS: IT'S / TWO WORDS\nENV: DEBUG\n", out)
+
+	var exitErr *exec.ExitError
+	_, err := exec.Command("sh", "-c", s.Command()).Output()
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.ExitCode())
+
+	if _, err := exec.LookPath("python3"); err == nil {
+		py := &Script{Content: []byte("import sys\nprint(sys.argv[1:])\n"), Interpreter: "python3", Args: []string{"a b"}}
+		assert.Equal(t, "['a b']\n", runShell(t, py.Command()))
+	}
+}
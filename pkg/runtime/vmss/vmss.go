@@ -5,19 +5,34 @@ package vmss
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/Azure/kubectl-aks/cmd/utils"
 	pkgruntime "github.com/Azure/kubectl-aks/pkg/runtime"
 )
 
-// Runtime executes commands on AKS nodes via the Azure VMSS RunCommand API.
+// Runtime executes commands on AKS nodes via the Azure RunCommand API of
+// VMSS instances or, for VirtualMachines node pools, standalone VMs.
 type Runtime struct {
 	Credential     azcore.TokenCredential
-	VM             *utils.VirtualMachineScaleSetVM
+	VM             *utils.VirtualMachine
 	OutputTruncate utils.OutputTruncate
+	// Backend is the RunCommand API used, the action by default. The managed
+	// backend falls back to the action when it isn't available on the VM.
+	Backend utils.RunCommandBackend
+	// OutputContainer is the URL, with a SAS token, of the blob container the
+	// managed backend writes the output to, so it isn't truncated. If empty,
+	// the output is limited to 4,096 bytes.
+	OutputContainer string
+
+	// os caches the OS read from the VM when it isn't stored in VM.
+	osMu sync.Mutex
+	os   pkgruntime.OS
 }
 
 func (r *Runtime) RunCommand(ctx context.Context, opts *pkgruntime.RunOptions) (*pkgruntime.RunResult, error) {
@@ -31,13 +46,93 @@ func (r *Runtime) RunCommand(ctx context.Context, opts *pkgruntime.RunOptions) (
 	timeout := opts.Timeout
 	command := opts.Command
 
-	res, err := utils.RunCommand(ctx, r.Credential, r.VM, &command, &timeout, r.OutputTruncate)
+	// The command is wrapped for the OS of the VM, which is read from Azure
+	// if it isn't stored.
+	vm := r.VM
+	if vm.OS == "" {
+		os, err := r.NodeOS(ctx, opts.NodeName)
+		if err != nil {
+			return nil, pkgruntime.NotStarted(err)
+		}
+		withOS := *r.VM
+		withOS.OS = os
+		vm = &withOS
+	}
+
+	if r.Backend == utils.RunCommandBackendManaged {
+		res, err := utils.RunManagedCommand(ctx, r.Credential, vm, &command, &timeout, r.OutputTruncate, r.OutputContainer)
+		if err == nil {
+			return &pkgruntime.RunResult{
+				Stdout:   res.Stdout,
+				Stderr:   res.Stderr,
+				ExitCode: res.ExitCode,
+			}, nil
+		}
+		if !errors.Is(err, utils.ErrManagedRunCommandUnavailable) {
+			return nil, err
+		}
+		log.Warnf("Falling back to the RunCommand action: %s", err)
+	}
+
+	res, err := utils.RunCommand(ctx, r.Credential, vm, &command, &timeout, r.OutputTruncate)
 	if err != nil {
 		return nil, err
 	}
 
 	return &pkgruntime.RunResult{
-		Stdout: res.Stdout,
-		Stderr: res.Stderr,
+		Stdout:   res.Stdout,
+		Stderr:   res.Stderr,
+		ExitCode: res.ExitCode,
 	}, nil
 }
+
+// Probe checks that the credential can read the VM and that it's running
+// with a ready VM agent, as RunCommand requires.
+func (r *Runtime) Probe(ctx context.Context, nodeName string) error {
+	if r.Credential == nil {
+		return fmt.Errorf("credential is required for azure-api runtime")
+	}
+	if r.VM == nil {
+		return fmt.Errorf("VM information is required for azure-api runtime")
+	}
+	status, err := utils.GetInstanceStatus(ctx, r.Credential, r.VM)
+	if err != nil {
+		return err
+	}
+	if status.PowerState != "running" {
+		return fmt.Errorf("VM is %s", status.PowerState)
+	}
+	switch status.AgentStatus {
+	case "Ready":
+		return nil
+	case "":
+		return errors.New("VM agent status isn't reported")
+	default:
+		return fmt.Errorf("VM agent is %s", status.AgentStatus)
+	}
+}
+
+// NodeOS returns the OS of the VM, as stored by config import or read from
+// the Node object with --node. Otherwise, e.g. for nodes imported by older
+// versions or given with --id, it's read from the OS disk of the VM once.
+func (r *Runtime) NodeOS(ctx context.Context, nodeName string) (pkgruntime.OS, error) {
+	if r.VM == nil {
+		return "", fmt.Errorf("VM information is required for azure-api runtime")
+	}
+	if r.VM.OS != "" {
+		return r.VM.OS, nil
+	}
+	if r.Credential == nil {
+		return "", fmt.Errorf("credential is required for azure-api runtime")
+	}
+	r.osMu.Lock()
+	defer r.osMu.Unlock()
+	if r.os == "" {
+		os, err := utils.GetVMOS(ctx, r.Credential, r.VM)
+		if err != nil {
+			return "", fmt.Errorf("detecting OS of the VM: %w", err)
+		}
+		r.os = os
+	}
+	return r.os, nil
+}